@@ -6,40 +6,131 @@ import (
 	"strings"
 
 	"github.com/jywei/toy-projects/cms"
+	"github.com/jywei/toy-projects/users"
 )
 
 var pool = New()
 
+// page is the JSON form of a cms.Page. RenderedHTML is only filled in when the
+// client asks for it with ?render=html.
+type page struct {
+	*cms.Page
+	RenderedHTML string `json:"rendered_html,omitempty"`
+}
+
+// wantsHTML reports whether the client asked for rendered content
+func wantsHTML(r *http.Request) bool {
+	return r.URL.Query().Get("render") == "html"
+}
+
+// newPage wraps p for encoding, rendering its Markdown with the request's site
+// if the client asked for it
+func newPage(r *http.Request, p *cms.Page) *page {
+	out := &page{Page: p}
+	if wantsHTML(r) {
+		out.RenderedHTML = string(cms.SiteOf(r).Markdown(p.Content))
+	}
+	return out
+}
+
 // Doc lists all the routes for our API
 func Doc(w http.ResponseWriter, r *http.Request) {
 	data := (map[string]string{
-		"all_pages_url":   "/pages",
-		"page_url":        "/pages/{id}",
-		"create_page_url": "/newpage",
+		"all_pages_url":     "/pages{?limit,offset,after,before,sort,order,title,status,tag}",
+		"page_url":          "/pages/{slug}",
+		"rendered_page_url": "/pages/{slug}?render=html",
+		"create_page_url":   "/newpage",
+		"search_url":        "/search?q={query}",
+		"comments_url":      "/posts/{slug}/comments",
+		"revisions_url":     "/revisions/{kind}/{id}",
+		"revision_url":      "/revisions/{kind}/{id}/{number}",
+		"diff_url":          "/revisions/{kind}/{id}/diff?from={number}&to={number}",
+		"rollback_url":      "/revisions/{kind}/{id}/rollback?rev={number}",
 	})
 	writeJSON(w, data)
 }
 
-// AllPages return all the pages
+// pagination describes where a listing is up to, and how to get the rest
+type pagination struct {
+	Total   int    `json:"total"`
+	Limit   int    `json:"limit"`
+	Offset  int    `json:"offset,omitempty"`
+	Next    string `json:"next,omitempty"`
+	Prev    string `json:"prev,omitempty"`
+	NextURL string `json:"next_url,omitempty"`
+	PrevURL string `json:"prev_url,omitempty"`
+}
+
+// AllPages return the pages, a page at a time. It takes the same limit,
+// offset, after, before, sort, order, title, status and tag parameters as the
+// cms, and anonymous clients only get published pages.
 func AllPages(w http.ResponseWriter, r *http.Request) {
-	data, err := cms.GetPages()
+	site := cms.SiteOf(r)
+	q, err := cms.ParsePageQuery(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		errJSON(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	writeJSON(w, data)
+	if status := cms.VisibleStatus(r); status != "" {
+		q.Status = status
+	}
+	list, err := site.ListPages(q)
+	if err == cms.ErrBadCursor {
+		errJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == nil {
+		err = site.LoadPageTerms(list.Pages...)
+	}
+	if err != nil {
+		errJSON(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := []*page{}
+	for _, p := range list.Pages {
+		data = append(data, newPage(r, p))
+	}
+	writeJSON(w, map[string]interface{}{
+		"pages": data,
+		"pagination": pagination{
+			Total:   list.Total,
+			Limit:   q.Limit,
+			Offset:  q.Offset,
+			Next:    list.Next,
+			Prev:    list.Prev,
+			NextURL: q.Link("/pages", "after", list.Next),
+			PrevURL: q.Link("/pages", "before", list.Prev),
+		},
+	})
 }
 
-// CreatePage creates a new post or pages
+// CreatePage creates a new post or pages. It needs a login, and the page as
+// JSON from the same origin. Like the cms, only editors may publish.
 func CreatePage(w http.ResponseWriter, r *http.Request) {
+	site := cms.SiteOf(r)
+	user, err := users.SessionUser(r)
+	if err != nil {
+		errJSON(w, "Please login to create pages", http.StatusUnauthorized)
+		return
+	}
+	if !fromScript(r) {
+		errJSON(w, "Pages must be sent as JSON from this site", http.StatusForbidden)
+		return
+	}
 	page := new(cms.Page)
 	// take the body then decode it into our page varialbe
-	err := json.NewDecoder(r.Body).Decode(page)
+	err = json.NewDecoder(r.Body).Decode(page)
 	if err != nil {
 		errJSON(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	id, err := cms.CreatePage(page)
+	if !cms.CanPublish(r, page.Status, "") {
+		errJSON(w, "This needs the "+cms.RoleEditor+" role", http.StatusForbidden)
+		return
+	}
+	page.Author = user
+	id, err := site.CreatePage(page)
 	if err != nil {
 		errJSON(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -69,13 +160,38 @@ func errJSON(w http.ResponseWriter, err string, status int) {
 	w.Write([]byte("{\n\terror: " + err + "\n}\n"))
 }
 
-// GetPage gets a single page from the API
+// GetPage gets a single page from the API, by its slug, an old slug or its
+// ID. Unpublished pages are hidden from anonymous clients.
 func GetPage(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimLeft(r.URL.Path, "/pages/")
-	data, err := cms.GetPage(id)
+	site := cms.SiteOf(r)
+	ref := strings.TrimPrefix(r.URL.Path, "/pages/")
+	data, _, err := site.ResolvePage(ref)
+	if err == nil && !cms.CanSee(r, data.Status) {
+		err = cms.ErrNotFound
+	}
 	if err != nil {
 		errJSON(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	writeJSON(w, data)
+	err = site.LoadPageTerms(data)
+	if err == nil {
+		err = site.TranslatePages(cms.LangOf(r), data)
+	}
+	if err != nil {
+		errJSON(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newPage(r, data))
+}
+
+// Search returns the pages and posts matching ?q=, best matches first.
+// Anonymous clients only find published content.
+func Search(w http.ResponseWriter, r *http.Request) {
+	site := cms.SiteOf(r)
+	results, err := site.Search(r.URL.Query().Get("q"), cms.VisibleStatus(r))
+	if err != nil {
+		errJSON(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, results)
 }
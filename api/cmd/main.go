@@ -1,14 +1,28 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
 	"os"
 
 	"github.com/jywei/toy-projects/api"
+	"github.com/jywei/toy-projects/cms"
+	"github.com/jywei/toy-projects/users"
 )
 
 func main() {
+	backend := flag.String("store", "memory", "storage backend: memory, bolt or postgres")
+	dsn := flag.String("dsn", "", "bolt file or postgres connection string, defaults depend on -store")
+	flag.Parse()
+
+	store, err := cms.Open(*backend, *dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	cms.SetStore(store)
+
 	// Create images directory
 	os.Mkdir("images", 0777)
 
@@ -17,7 +31,16 @@ func main() {
 	http.HandleFunc("/newpage", api.CreatePage)
 	http.HandleFunc("/pages", api.AllPages)
 	http.HandleFunc("/pages/", api.GetPage)
+	http.HandleFunc("/posts/", api.PostComments)
+	http.HandleFunc("/search", api.Search)
+	http.HandleFunc("/revisions/", api.Revisions)
 	http.HandleFunc("/upload", api.UploadImage)
+	// Clients logged in to the cms see its unpublished content too
+	cms.CurrentUser = func(r *http.Request) string {
+		user, _ := users.SessionUser(r)
+		return user
+	}
+	cms.UserRole = users.GetRole
 
 	log.Fatal(http.ListenAndServe(":3000", nil))
 }
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jywei/toy-projects/cms"
+)
+
+// comment is the JSON form of a cms.Comment. It leaves out what only
+// moderators see.
+type comment struct {
+	ID            int        `json:"id"`
+	ParentID      int        `json:"parent_id,omitempty"`
+	Author        string     `json:"author"`
+	Comment       string     `json:"comment"`
+	DatePublished time.Time  `json:"date_published"`
+	Depth         int        `json:"depth"`
+	Replies       []*comment `json:"replies"`
+}
+
+// newComments converts a thread of comments, replies and all.
+func newComments(thread []*cms.Comment) []*comment {
+	out := []*comment{}
+	for _, c := range thread {
+		out = append(out, &comment{
+			ID:            c.ID,
+			ParentID:      c.ParentID,
+			Author:        c.Author,
+			Comment:       c.Comment,
+			DatePublished: c.DatePublished,
+			Depth:         c.Depth,
+			Replies:       newComments(c.Replies),
+		})
+	}
+	return out
+}
+
+// PostComments returns the approved comments on a post at
+// /posts/{slug}/comments, threaded the same way the cms shows them. The post
+// can also be given by an old slug or its ID.
+func PostComments(w http.ResponseWriter, r *http.Request) {
+	site := cms.SiteOf(r)
+	ref := strings.TrimPrefix(r.URL.Path, "/posts/")
+	if !strings.HasSuffix(ref, "/comments") {
+		errJSON(w, "not found", http.StatusNotFound)
+		return
+	}
+	p, _, err := site.ResolvePost(strings.TrimSuffix(ref, "/comments"))
+	if err == nil && !cms.CanSee(r, p.Status) {
+		err = cms.ErrNotFound
+	}
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	thread, err := site.GetComments(p.ID)
+	if err != nil {
+		errJSON(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, newComments(thread))
+}
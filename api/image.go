@@ -1,67 +1,57 @@
 package api
 
 import (
-	"io"
 	"net/http"
-	"os"
-	"strings"
-)
 
-var here = os.Getenv("GOPATH") + "/src/github.com/jywei/toy-projects/api/images/"
+	"github.com/jywei/toy-projects/cms"
+	"github.com/jywei/toy-projects/users"
+)
 
-// UploadImage allows uploading an image
+// UploadImage adds the image in the multipart form field image to the cms
+// media library, with the alt text in alt. Like the cms's media library, it
+// needs a login.
 func UploadImage(w http.ResponseWriter, r *http.Request) {
+	site := cms.SiteOf(r)
+	user, err := users.SessionUser(r)
+	if err != nil {
+		errJSON(w, "Please login to upload images", http.StatusUnauthorized)
+		return
+	}
+	if !sameOrigin(r) {
+		errJSON(w, "Upload images from this site", http.StatusForbidden)
+		return
+	}
 	// 1. get the image data and header from the request
-	// Content-Encoding: multipart/form-data
+	// Content-Encoding: multipart/form-data, read no further than an upload
+	// could need
+	r.Body = http.MaxBytesReader(w, r.Body, cms.MaxImageBytes+1<<20)
 	file, header, err := r.FormFile("image")
 	if err != nil {
-		errJSON(w, err.Error(), http.StatusInternalServerError)
+		errJSON(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	defer file.Close()
 
-	// 2. create a new file in image directory with os.Create
-	out, err := os.Create(here + header.Filename)
-	if err != nil {
-		errJSON(w, err.Error(), http.StatusInternalServerError)
+	// 2. save it in the media library, which writes it to the image directory
+	img, err := site.SaveImage(header.Filename, file, r.FormValue("alt"), user)
+	if err == cms.ErrNotImage || err == cms.ErrBadFilename {
+		errJSON(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err == cms.ErrImageTooBig {
+		errJSON(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
-	defer out.Close()
-
-	// 3. Write the image to the file
-	_, err = io.Copy(out, file)
 	if err != nil {
 		errJSON(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// 4. response with json
-	writeJSON(w, map[string]string{
-		"filename": header.Filename,
-	})
+	// 3. response with json
+	writeJSON(w, img)
 }
 
 // ShowImage shows the image based on the filename found in the path
 func ShowImage(w http.ResponseWriter, r *http.Request) {
-	// 1. Get the file name from the url
-	name := strings.TrimLeft(r.URL.Path, "/image/")
-	// 2. Open the file, this contains sercurity risks
-	file, err := os.Open(here + name)
-	if err != nil {
-		errJSON(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	buf := pool.Get()
-	defer pool.Put(buf)
-	// 3. Move the data from our file to our bufferpool
-	_, err = io.Copy(buf, file)
-	if err != nil {
-		errJSON(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// 4. Set the content-type and write the image to our ResponseWriter
-	w.Header().Set("Content-Type", "image/jpeg")
-	buf.WriteTo(w)
+	cms.ServeImage(w, r)
 }
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// sameOrigin reports whether a request came from a page of the site it's
+// for, or from a client that isn't a browser. Browsers send an Origin with
+// every POST from another site, so a form there is refused.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// fromScript reports whether a request using the client's login to change
+// content was sent as JSON, from the same origin. Forms can't send JSON, and
+// scripts on other sites can't without CORS letting them, so another site
+// can't make the change for the client.
+func fromScript(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json" && sameOrigin(r)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jywei/toy-projects/cms"
+	"github.com/jywei/toy-projects/users"
+)
+
+// Revisions serves the history of pages and posts:
+//
+//	GET  /revisions/{kind}/{id}                    every revision, newest first
+//	GET  /revisions/{kind}/{id}/{number}           a single revision
+//	GET  /revisions/{kind}/{id}/diff?from=1&to=2   a line diff of two revisions
+//	POST /revisions/{kind}/{id}/rollback?rev=1     restore an old revision
+//
+// Anonymous clients only see the history of published pages and posts, and
+// rolling back needs a login, and a Content-Type of application/json from
+// the same origin.
+func Revisions(w http.ResponseWriter, r *http.Request) {
+	site := cms.SiteOf(r)
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/revisions/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		errJSON(w, "not found", http.StatusNotFound)
+		return
+	}
+	kind := parts[0]
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		errJSON(w, "invalid id", http.StatusNotFound)
+		return
+	}
+	err = canSeeItem(r, site, kind, id)
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+
+	if len(parts) == 2 {
+		revs, err := site.GetRevisions(kind, id)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		writeJSON(w, revs)
+		return
+	}
+
+	switch parts[2] {
+	case "diff":
+		from, err1 := strconv.Atoi(r.FormValue("from"))
+		to, err2 := strconv.Atoi(r.FormValue("to"))
+		if err1 != nil || err2 != nil {
+			errJSON(w, "from and to must be revision numbers", http.StatusBadRequest)
+			return
+		}
+		a, err := site.GetRevision(kind, id, from)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		b, err := site.GetRevision(kind, id, to)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		writeJSON(w, cms.Diff(a.Content, b.Content))
+
+	case "rollback":
+		if r.Method != "POST" {
+			errJSON(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		user, err := users.SessionUser(r)
+		if err != nil {
+			errJSON(w, "Please login to roll back", http.StatusUnauthorized)
+			return
+		}
+		if !fromScript(r) {
+			errJSON(w, "Roll back with a JSON request from this site", http.StatusForbidden)
+			return
+		}
+		number, err := strconv.Atoi(r.FormValue("rev"))
+		if err != nil {
+			errJSON(w, "rev must be a revision number", http.StatusBadRequest)
+			return
+		}
+		err = site.Rollback(kind, id, number, user)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		revs, err := site.GetRevisions(kind, id)
+		if err != nil {
+			errJSON(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, revs[0])
+
+	default:
+		number, err := strconv.Atoi(parts[2])
+		if err != nil {
+			errJSON(w, "not found", http.StatusNotFound)
+			return
+		}
+		rev, err := site.GetRevision(kind, id, number)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		writeJSON(w, rev)
+	}
+}
+
+// canSeeItem checks the request may see the page or post, by the rule the cms
+// serves them with: anonymous clients only see what's published, and nobody
+// sees what's in the trash.
+func canSeeItem(r *http.Request, site *cms.Site, kind string, id int) error {
+	var status string
+	switch kind {
+	case cms.KindPage:
+		p, err := site.GetPage(strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		status = p.Status
+	case cms.KindPost:
+		p, err := site.GetPost(strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		status = p.Status
+	default:
+		return cms.ErrBadKind
+	}
+	if !cms.CanSee(r, status) {
+		return cms.ErrNotFound
+	}
+	return nil
+}
+
+// lookupError responds to a failed cms lookup with the right status code
+func lookupError(w http.ResponseWriter, err error) {
+	switch err {
+	case cms.ErrNotFound:
+		errJSON(w, err.Error(), http.StatusNotFound)
+	case cms.ErrBadKind:
+		errJSON(w, err.Error(), http.StatusBadRequest)
+	default:
+		errJSON(w, err.Error(), http.StatusInternalServerError)
+	}
+}
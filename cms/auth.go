@@ -0,0 +1,62 @@
+package cms
+
+import (
+	"net/http"
+	"time"
+)
+
+// The roles a logged-in user can have, from the least to the most trusted.
+// Authors write drafts and send them for review, editors publish them, and
+// admins can also delete.
+const (
+	RoleAuthor = "author"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Roles lists every role, from the least to the most trusted.
+var Roles = []string{RoleAuthor, RoleEditor, RoleAdmin}
+
+// UserRole returns the role of a logged-in user. Users without a role are
+// authors. Until the application replaces it there are no logins to tell
+// apart, so everybody is an admin.
+var UserRole = func(user string) string {
+	return RoleAdmin
+}
+
+// roleRank orders a role in Roles, treating an unknown role as an author.
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return 0
+}
+
+// HasRole reports whether the user making the request has the role, or a more
+// trusted one.
+func HasRole(r *http.Request, role string) bool {
+	return roleRank(UserRole(CurrentUser(r))) >= roleRank(role)
+}
+
+// CanPublish reports whether the request may move content to status from
+// previous, which is empty for new content. Only editors may publish, but
+// anyone may keep editing what's already published.
+func CanPublish(r *http.Request, status, previous string) bool {
+	if status != StatusPublished || previous == StatusPublished {
+		return true
+	}
+	return HasRole(r, RoleEditor)
+}
+
+// CanSchedule reports whether the request may give a post a publish time,
+// which the scheduler publishes it at whatever its status. Only editors may.
+func CanSchedule(r *http.Request, publishAt time.Time) bool {
+	return publishAt.IsZero() || HasRole(r, RoleEditor)
+}
+
+// forbidden refuses a request that needs a more trusted role.
+func forbidden(w http.ResponseWriter, role string) {
+	http.Error(w, "This needs the "+role+" role", http.StatusForbidden)
+}
@@ -0,0 +1,144 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loginAs logs every request in as user with the role, and returns a func
+// logging them out again.
+func loginAs(user, role string) func() {
+	oldUser, oldRole := CurrentUser, UserRole
+	CurrentUser = func(r *http.Request) string { return user }
+	UserRole = func(string) string { return role }
+	return func() { CurrentUser, UserRole = oldUser, oldRole }
+}
+
+// postForm posts form to the handler, returning what it answered.
+func postForm(h http.HandlerFunc, path string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func Test_HasRole(t *testing.T) {
+	tests := []struct {
+		role, needs string
+		has         bool
+	}{
+		{RoleAuthor, RoleAuthor, true},
+		{RoleAuthor, RoleEditor, false},
+		{"", RoleAuthor, true},
+		{"", RoleEditor, false},
+		{RoleEditor, RoleEditor, true},
+		{RoleEditor, RoleAdmin, false},
+		{RoleAdmin, RoleEditor, true},
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, test := range tests {
+		logout := loginAs("someone", test.role)
+		if HasRole(r, test.needs) != test.has {
+			t.Errorf("HasRole(%q, %q) = %t\n", test.role, test.needs, !test.has)
+		}
+		logout()
+	}
+}
+
+func Test_Authoring(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer loginAs("ann", RoleAuthor)()
+
+		published := url.Values{"contentType": {"page"}, "title": {"Too soon"}, "content": {"text"}, "status": {StatusPublished}}
+		w := postForm(HandleNew, "/new", published)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected an author not to publish, got %d\n", w.Code)
+		}
+
+		draft := url.Values{"contentType": {"post"}, "title": {"By Ann"}, "content": {"text"}, "status": {StatusReview}}
+		w = postForm(HandleNew, "/new", draft)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "By ann") {
+			t.Errorf("Expected the post credited to its author, got %d %s\n", w.Code, w.Body.String())
+		}
+		post, _, err := ResolvePost("by-ann")
+		if err != nil || post.Author != "ann" {
+			t.Fatalf("Expected the author to be saved, got %+v, %v\n", post, err)
+		}
+
+		publish := url.Values{"id": {strconv.Itoa(post.ID)}, "status": {StatusPublished}}
+		w = postForm(ServeAdminPosts, "/admin/posts", publish)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected an author not to publish from the admin, got %d\n", w.Code)
+		}
+
+		// The scheduler would publish a post in review with a publish time
+		later := time.Now().Add(time.Hour).UTC().Format(publishAtLayout)
+		scheduled := url.Values{"contentType": {"post"}, "title": {"Sneaky"}, "content": {"text"}, "status": {StatusReview}, "publish_at": {later}}
+		if w = postForm(HandleNew, "/new", scheduled); w.Code != http.StatusForbidden {
+			t.Errorf("Expected an author not to schedule a new post, got %d\n", w.Code)
+		}
+		w = postForm(ServeAdminPosts, "/admin/posts", url.Values{"id": {strconv.Itoa(post.ID)}, "status": {StatusReview}, "publish_at": {later}})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected an author not to schedule from the admin, got %d\n", w.Code)
+		}
+		err = SetPostStatus(post.ID, StatusReview, time.Now().Add(time.Hour), "ann")
+		if err != ErrCantSchedule {
+			t.Errorf("Expected ErrCantSchedule, got %v\n", err)
+		}
+		if post, _, err = ResolvePost("by-ann"); err != nil || post.Scheduled() {
+			t.Errorf("Expected the post not to be scheduled, got %+v, %v\n", post, err)
+		}
+
+		defer loginAs("ed", RoleEditor)()
+		w = postForm(ServeAdminPosts, "/admin/posts", publish)
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("Expected an editor to publish, got %d\n", w.Code)
+		}
+		post, _, err = ResolvePost("by-ann")
+		if err != nil || !post.Published() || post.Author != "ann" {
+			t.Errorf("Expected the post published and still by ann, got %+v, %v\n", post, err)
+		}
+
+		w = postForm(HandleNew, "/new", published)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected an editor to publish a page, got %d\n", w.Code)
+		}
+		page, _, err := ResolvePage("too-soon")
+		if err != nil || page.Author != "ed" {
+			t.Fatalf("Expected the page by ed, got %+v, %v\n", page, err)
+		}
+		base := "/admin/pages/" + strconv.Itoa(page.ID)
+		w = postForm(ServeAdminPages, base+"/delete", url.Values{})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected an editor not to delete, got %d\n", w.Code)
+		}
+		w = httptest.NewRecorder()
+		ServeTrash(w, httptest.NewRequest("GET", "/admin/trash", nil))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected an editor not to see the trash, got %d\n", w.Code)
+		}
+
+		defer loginAs("root", RoleAdmin)()
+		w = postForm(ServeAdminPages, base+"/edit", url.Values{"title": {"Edited"}, "content": {"new"}, "version": {"1"}})
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("Expected an admin to edit, got %d\n", w.Code)
+		}
+		if page, err = GetPage(strconv.Itoa(page.ID)); err != nil || page.Author != "ed" {
+			t.Errorf("Expected an edit to keep the author, got %+v, %v\n", page, err)
+		}
+		w = postForm(ServeAdminPages, base+"/delete", url.Values{})
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("Expected an admin to delete, got %d\n", w.Code)
+		}
+		trash, err := GetTrash()
+		if err != nil || len(trash) != 1 || trash[0].Author != "ed" {
+			t.Errorf("Expected the page in the trash with its author, got %+v, %v\n", trash, err)
+		}
+	})
+}
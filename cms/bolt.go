@@ -0,0 +1,1332 @@
+package cms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	pagesBucket    = []byte("Pages")
+	trashBucket    = []byte("Trash")
+	postsBucket    = []byte("Posts")
+	commentsBucket = []byte("Comments")
+
+	// redirectsBucket maps kind/slug to the ID that used to have the slug
+	redirectsBucket = []byte("SlugRedirects")
+	revisionsBucket = []byte("Revisions")
+	termsBucket     = []byte("Terms")
+
+	// termItemsBucket is keyed by kind/item ID/term ID, with no values
+	termItemsBucket = []byte("TermItems")
+	imagesBucket    = []byte("Images")
+
+	translationsBucket = []byte("Translations")
+)
+
+// BoltStore is a Store kept in a single BoltDB file, for running the cms
+// without a database server. Records are stored as JSON, keyed by their ID.
+// The search index lives in memory and is rebuilt when the file is opened.
+type BoltStore struct {
+	DB    *bolt.DB
+	index *searchIndex
+}
+
+// NewBoltStore opens, or creates, the BoltDB file at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: 1 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{pagesBucket, trashBucket, postsBucket, commentsBucket, redirectsBucket, revisionsBucket, termsBucket, termItemsBucket, imagesBucket, translationsBucket} {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &BoltStore{
+		DB:    db,
+		index: newSearchIndex(),
+	}
+	err = s.buildIndex()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// buildIndex adds every page and post to the search index.
+func (s *BoltStore) buildIndex() error {
+	pages, err := s.GetPages()
+	if err != nil {
+		return err
+	}
+	for _, p := range pages {
+		s.index.add(KindPage, p.ID, p.Slug, p.Title, p.Content, p.Status)
+	}
+	posts, err := s.GetPosts("", 0)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		s.index.add(KindPost, p.ID, p.Slug, p.Title, p.Content, p.Status)
+	}
+	return nil
+}
+
+// indexPage updates the search index once tx commits.
+func (s *BoltStore) indexPage(tx *bolt.Tx, p *Page) {
+	tx.OnCommit(func() {
+		s.index.add(KindPage, p.ID, p.Slug, p.Title, p.Content, p.Status)
+	})
+}
+
+// indexPost updates the search index once tx commits.
+func (s *BoltStore) indexPost(tx *bolt.Tx, p *Post) {
+	tx.OnCommit(func() {
+		s.index.add(KindPost, p.ID, p.Slug, p.Title, p.Content, p.Status)
+	})
+}
+
+// Close closes the BoltDB file.
+func (s *BoltStore) Close() error {
+	return s.DB.Close()
+}
+
+func (s *BoltStore) GetPage(id int) (*Page, error) {
+	var p Page
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return boltGet(tx, pagesBucket, id, &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *BoltStore) GetPageBySlug(slug string) (*Page, error) {
+	var found *Page
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltPageBySlug(tx, slug)
+		return err
+	})
+	return found, err
+}
+
+func (s *BoltStore) GetPages() ([]*Page, error) {
+	pages := []*Page{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		// Keys are big endian IDs, so the cursor already walks them in order
+		return tx.Bucket(pagesBucket).ForEach(func(k, v []byte) error {
+			var p Page
+			err := json.Unmarshal(v, &p)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, &p)
+			return nil
+		})
+	})
+	return pages, err
+}
+
+func (s *BoltStore) ListPages(q PageQuery) (*PageList, error) {
+	pages, err := s.GetPages()
+	if err != nil {
+		return nil, err
+	}
+	if q.Tag != "" {
+		var tagged map[int]bool
+		err = s.DB.View(func(tx *bolt.Tx) error {
+			tagged, err = boltTagged(tx, KindPage, q.Tag)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		all := pages
+		pages = []*Page{}
+		for _, p := range all {
+			if tagged[p.ID] {
+				pages = append(pages, p)
+			}
+		}
+	}
+	return listPages(pages, q)
+}
+
+func (s *BoltStore) CreatePage(p *Page) (int, error) {
+	if p.DateCreated.IsZero() {
+		p.DateCreated = now()
+	}
+	var id int
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = boltNextID(tx, pagesBucket)
+		if err != nil {
+			return err
+		}
+		_, err = boltPageBySlug(tx, p.Slug)
+		if err != ErrNotFound {
+			if err == nil {
+				err = ErrSlugTaken
+			}
+			return err
+		}
+		stored := *p
+		stored.ID = id
+		stored.Version = 1
+		stored.Tags, stored.Categories = nil, nil
+		stored.Posts, stored.Lang, stored.Alternates = nil, "", nil
+		p.Version = stored.Version
+		err = tx.Bucket(redirectsBucket).Delete([]byte(KindPage + "/" + p.Slug))
+		if err != nil {
+			return err
+		}
+		s.indexPage(tx, &stored)
+		return boltPut(tx, pagesBucket, id, &stored)
+	})
+	return id, err
+}
+
+func (s *BoltStore) UpdatePage(p *Page) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var stored Page
+		err := boltGet(tx, pagesBucket, p.ID, &stored)
+		if err != nil {
+			return err
+		}
+		if p.Version != 0 && p.Version != stored.Version {
+			return ErrConflict
+		}
+		stored.Title = p.Title
+		stored.Content = p.Content
+		stored.Status = p.Status
+		stored.Version++
+		s.indexPage(tx, &stored)
+		err = boltPut(tx, pagesBucket, p.ID, &stored)
+		if err == nil {
+			p.Version = stored.Version
+		}
+		return err
+	})
+}
+
+func (s *BoltStore) TrashPage(id int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var p Page
+		err := boltGet(tx, pagesBucket, id, &p)
+		if err != nil {
+			return err
+		}
+		p.DeletedAt = now()
+		err = boltPut(tx, trashBucket, id, &p)
+		if err != nil {
+			return err
+		}
+		tx.OnCommit(func() { s.index.remove(KindPage, id) })
+		return tx.Bucket(pagesBucket).Delete(itob(id))
+	})
+}
+
+func (s *BoltStore) GetTrash() ([]*Page, error) {
+	pages := []*Page{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(trashBucket).ForEach(func(k, v []byte) error {
+			var p Page
+			err := json.Unmarshal(v, &p)
+			if err != nil {
+				return err
+			}
+			pages = append(pages, &p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortTrash(pages)
+	return pages, nil
+}
+
+func (s *BoltStore) RestorePage(id int, slug string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var p Page
+		err := boltGet(tx, trashBucket, id, &p)
+		if err != nil {
+			return err
+		}
+		_, err = boltPageBySlug(tx, slug)
+		if err != ErrNotFound {
+			if err == nil {
+				err = ErrSlugTaken
+			}
+			return err
+		}
+		p.Slug = slug
+		p.DeletedAt = time.Time{}
+		err = tx.Bucket(redirectsBucket).Delete([]byte(KindPage + "/" + slug))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(trashBucket).Delete(itob(id))
+		if err != nil {
+			return err
+		}
+		s.indexPage(tx, &p)
+		return boltPut(tx, pagesBucket, id, &p)
+	})
+}
+
+func (s *BoltStore) PurgePage(id int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		trash := tx.Bucket(trashBucket)
+		if trash.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+		err := boltDeleteRedirects(tx, KindPage, id)
+		if err != nil {
+			return err
+		}
+		err = boltSetItemTerms(tx, "", KindPage, id, nil)
+		if err != nil {
+			return err
+		}
+		err = boltDeleteTranslations(tx, KindPage, id, "")
+		if err != nil {
+			return err
+		}
+		return trash.Delete(itob(id))
+	})
+}
+
+func (s *BoltStore) SetPageSlug(id int, slug string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var p Page
+		err := boltGet(tx, pagesBucket, id, &p)
+		if err != nil {
+			return err
+		}
+		other, err := boltPageBySlug(tx, slug)
+		if err == nil && other.ID != id {
+			return ErrSlugTaken
+		}
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		redirects := tx.Bucket(redirectsBucket)
+		err = redirects.Put([]byte(KindPage+"/"+p.Slug), itob(id))
+		if err != nil {
+			return err
+		}
+		err = redirects.Delete([]byte(KindPage + "/" + slug))
+		if err != nil {
+			return err
+		}
+		p.Slug = slug
+		s.indexPage(tx, &p)
+		return boltPut(tx, pagesBucket, id, &p)
+	})
+}
+
+func (s *BoltStore) GetPost(id int) (*Post, error) {
+	var p Post
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return boltGet(tx, postsBucket, id, &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *BoltStore) GetPostBySlug(slug string) (*Post, error) {
+	var found *Post
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltPostBySlug(tx, slug)
+		return err
+	})
+	return found, err
+}
+
+func (s *BoltStore) GetPosts(status string, limit int) ([]*Post, error) {
+	posts := []*Post{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(postsBucket).ForEach(func(k, v []byte) error {
+			var p Post
+			err := json.Unmarshal(v, &p)
+			if err != nil {
+				return err
+			}
+			if matchStatus(p.Status, status) {
+				posts = append(posts, &p)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recentPosts(posts, limit), nil
+}
+
+func (s *BoltStore) CreatePost(p *Post) (int, error) {
+	if p.DatePublished.IsZero() {
+		p.DatePublished = now()
+	}
+	var id int
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		var err error
+		id, err = boltNextID(tx, postsBucket)
+		if err != nil {
+			return err
+		}
+		_, err = boltPostBySlug(tx, p.Slug)
+		if err != ErrNotFound {
+			if err == nil {
+				err = ErrSlugTaken
+			}
+			return err
+		}
+		stored := *p
+		stored.ID = id
+		stored.Tags, stored.Categories = nil, nil
+		stored.Comments, stored.FeaturedImage = nil, nil
+		stored.Lang, stored.Alternates = "", nil
+		err = tx.Bucket(redirectsBucket).Delete([]byte(KindPost + "/" + p.Slug))
+		if err != nil {
+			return err
+		}
+		s.indexPost(tx, &stored)
+		return boltPut(tx, postsBucket, id, &stored)
+	})
+	return id, err
+}
+
+func (s *BoltStore) SetPostSlug(id int, slug string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var p Post
+		err := boltGet(tx, postsBucket, id, &p)
+		if err != nil {
+			return err
+		}
+		other, err := boltPostBySlug(tx, slug)
+		if err == nil && other.ID != id {
+			return ErrSlugTaken
+		}
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		redirects := tx.Bucket(redirectsBucket)
+		err = redirects.Put([]byte(KindPost+"/"+p.Slug), itob(id))
+		if err != nil {
+			return err
+		}
+		err = redirects.Delete([]byte(KindPost + "/" + slug))
+		if err != nil {
+			return err
+		}
+		p.Slug = slug
+		s.indexPost(tx, &p)
+		return boltPut(tx, postsBucket, id, &p)
+	})
+}
+
+func (s *BoltStore) UpdatePost(p *Post) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var stored Post
+		err := boltGet(tx, postsBucket, p.ID, &stored)
+		if err != nil {
+			return err
+		}
+		stored.Title = p.Title
+		stored.Content = p.Content
+		stored.Status = p.Status
+		stored.PublishAt = p.PublishAt
+		stored.FeaturedImageID = p.FeaturedImageID
+		if !p.DatePublished.IsZero() {
+			stored.DatePublished = p.DatePublished
+		}
+		s.indexPost(tx, &stored)
+		return boltPut(tx, postsBucket, p.ID, &stored)
+	})
+}
+
+func (s *BoltStore) PublishDuePosts(now time.Time) ([]*Post, error) {
+	posts := []*Post{}
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(postsBucket)
+		// Writing while iterating confuses the cursor, so collect the posts
+		// first
+		err := b.ForEach(func(k, v []byte) error {
+			var p Post
+			err := json.Unmarshal(v, &p)
+			if err != nil {
+				return err
+			}
+			if duePost(&p, now) {
+				posts = append(posts, &p)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range posts {
+			p.Status = StatusPublished
+			p.DatePublished = p.PublishAt
+			s.indexPost(tx, p)
+			err = boltPut(tx, postsBucket, p.ID, p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (s *BoltStore) DeletePost(id int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		posts := tx.Bucket(postsBucket)
+		if posts.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+
+		comments := tx.Bucket(commentsBucket)
+		// Deleting while iterating confuses the cursor, so collect the keys
+		// first
+		var keys [][]byte
+		err := comments.ForEach(func(k, v []byte) error {
+			var c Comment
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			if c.PostID == id {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			err = comments.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		err = boltDeleteRedirects(tx, KindPost, id)
+		if err != nil {
+			return err
+		}
+		err = boltSetItemTerms(tx, "", KindPost, id, nil)
+		if err != nil {
+			return err
+		}
+		err = boltDeleteTranslations(tx, KindPost, id, "")
+		if err != nil {
+			return err
+		}
+		tx.OnCommit(func() { s.index.remove(KindPost, id) })
+		return posts.Delete(itob(id))
+	})
+}
+
+func (s *BoltStore) GetComments(postID int, status string) ([]*Comment, error) {
+	comments := []*Comment{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(commentsBucket).ForEach(func(k, v []byte) error {
+			var c Comment
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			if c.PostID == postID && matchCommentStatus(c.Status, status) {
+				comments = append(comments, &c)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortComments(comments)
+	return comments, nil
+}
+
+func (s *BoltStore) GetCommentsByStatus(status string) ([]*Comment, error) {
+	comments := []*Comment{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(commentsBucket).ForEach(func(k, v []byte) error {
+			var c Comment
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			if matchCommentStatus(c.Status, status) {
+				comments = append(comments, &c)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortComments(comments)
+	return comments, nil
+}
+
+func (s *BoltStore) SetCommentStatus(id int, status string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var c Comment
+		err := boltGet(tx, commentsBucket, id, &c)
+		if err != nil {
+			return err
+		}
+		c.Status = status
+		return boltPut(tx, commentsBucket, id, &c)
+	})
+}
+
+func (s *BoltStore) CreateComment(c *Comment) (int, error) {
+	if c.DatePublished.IsZero() {
+		c.DatePublished = now()
+	}
+	var id int
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(postsBucket).Get(itob(c.PostID)) == nil {
+			return ErrNotFound
+		}
+		var err error
+		id, err = boltNextID(tx, commentsBucket)
+		if err != nil {
+			return err
+		}
+		stored := *c
+		stored.ID = id
+		stored.Depth, stored.Replies, stored.ReplyURL = 0, nil, ""
+		return boltPut(tx, commentsBucket, id, &stored)
+	})
+	return id, err
+}
+
+func (s *BoltStore) DeleteComment(id int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(commentsBucket)
+		if b.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+		// Replies go with the comment, and their replies with them
+		replies := map[int][]int{}
+		err := b.ForEach(func(k, v []byte) error {
+			var c Comment
+			err := json.Unmarshal(v, &c)
+			if err != nil {
+				return err
+			}
+			if c.ParentID != 0 {
+				replies[c.ParentID] = append(replies[c.ParentID], c.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for ids := []int{id}; len(ids) > 0; ids = ids[1:] {
+			err = b.Delete(itob(ids[0]))
+			if err != nil {
+				return err
+			}
+			ids = append(ids, replies[ids[0]]...)
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetSlugRedirect(kind, slug string) (int, error) {
+	var id int
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(redirectsBucket).Get([]byte(kind + "/" + slug))
+		if v == nil {
+			return ErrNotFound
+		}
+		id = int(binary.BigEndian.Uint64(v))
+		return nil
+	})
+	return id, err
+}
+
+func (s *BoltStore) Search(query, status string, limit int) ([]*SearchResult, error) {
+	return s.index.search(query, status, limit), nil
+}
+
+func (s *BoltStore) CreateRevision(r *Revision) (int, error) {
+	var id int
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		revs, err := boltRevisions(tx, r.Kind, r.ItemID)
+		if err != nil {
+			return err
+		}
+		id, err = boltNextID(tx, revisionsBucket)
+		if err != nil {
+			return err
+		}
+
+		stored := *r
+		stored.ID = id
+		stored.Number = 1
+		if len(revs) > 0 {
+			stored.Number = revs[0].Number + 1
+		}
+		if stored.DateCreated.IsZero() {
+			stored.DateCreated = now()
+		}
+		return boltPut(tx, revisionsBucket, id, &stored)
+	})
+	return id, err
+}
+
+func (s *BoltStore) GetRevisions(kind string, itemID int) ([]*Revision, error) {
+	var revs []*Revision
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		revs, err = boltRevisions(tx, kind, itemID)
+		return err
+	})
+	return revs, err
+}
+
+func (s *BoltStore) GetRevision(kind string, itemID, number int) (*Revision, error) {
+	revs, err := s.GetRevisions(kind, itemID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range revs {
+		if r.Number == number {
+			return r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *BoltStore) GetTerms(taxonomy string) ([]*Term, error) {
+	var terms []*Term
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		terms, err = boltTerms(tx, taxonomy)
+		return err
+	})
+	return terms, err
+}
+
+func (s *BoltStore) CreateTerm(t *Term) (int, error) {
+	var id int
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		terms, err := boltTerms(tx, t.Taxonomy)
+		if err != nil {
+			return err
+		}
+		for _, other := range terms {
+			if other.ParentID == t.ParentID && other.Slug == t.Slug {
+				return ErrSlugTaken
+			}
+		}
+		id, err = boltNextID(tx, termsBucket)
+		if err != nil {
+			return err
+		}
+		stored := *t
+		stored.ID = id
+		stored.Path, stored.Count = "", 0
+		return boltPut(tx, termsBucket, id, &stored)
+	})
+	return id, err
+}
+
+func (s *BoltStore) SetItemTerms(taxonomy, kind string, itemID int, termIDs []int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		for _, id := range termIDs {
+			if tx.Bucket(termsBucket).Get(itob(id)) == nil {
+				return ErrNotFound
+			}
+		}
+		return boltSetItemTerms(tx, taxonomy, kind, itemID, termIDs)
+	})
+}
+
+func (s *BoltStore) GetItemTerms(kind string, itemIDs []int) (map[int][]*Term, error) {
+	terms := map[int][]*Term{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		for _, id := range itemIDs {
+			termIDs, err := boltItemTerms(tx, kind, id)
+			if err != nil {
+				return err
+			}
+			for _, termID := range termIDs {
+				var t Term
+				err = boltGet(tx, termsBucket, termID, &t)
+				if err != nil {
+					return err
+				}
+				terms[id] = append(terms[id], &t)
+			}
+			sortTerms(terms[id])
+		}
+		return nil
+	})
+	return terms, err
+}
+
+func (s *BoltStore) GetTermContent(termIDs []int, status string) ([]*Page, []*Post, error) {
+	pages, posts := []*Page{}, []*Post{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		items, err := boltTermItems(tx)
+		if err != nil {
+			return err
+		}
+		want := map[int]bool{}
+		for _, id := range termIDs {
+			want[id] = true
+		}
+		// An item with several of the terms is only listed once
+		seen := map[termItem]bool{}
+		for _, item := range items {
+			key := termItem{Kind: item.Kind, ItemID: item.ItemID}
+			if !want[item.TermID] || seen[key] {
+				continue
+			}
+			seen[key] = true
+			switch item.Kind {
+			case KindPage:
+				var p Page
+				err = boltGet(tx, pagesBucket, item.ItemID, &p)
+				if err == nil && matchStatus(p.Status, status) {
+					pages = append(pages, &p)
+				}
+			case KindPost:
+				var p Post
+				err = boltGet(tx, postsBucket, item.ItemID, &p)
+				if err == nil && matchStatus(p.Status, status) {
+					posts = append(posts, &p)
+				}
+			}
+			if err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sortPagesByTitle(pages)
+	return pages, recentPosts(posts, 0), nil
+}
+
+func (s *BoltStore) CountTermItems(taxonomy, status string) (map[int]int, error) {
+	counts := map[int]int{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		terms, err := boltTerms(tx, taxonomy)
+		if err != nil {
+			return err
+		}
+		inTaxonomy := map[int]bool{}
+		for _, t := range terms {
+			inTaxonomy[t.ID] = true
+		}
+		items, err := boltTermItems(tx)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if !inTaxonomy[item.TermID] {
+				continue
+			}
+			var itemStatus string
+			switch item.Kind {
+			case KindPage:
+				var p Page
+				err = boltGet(tx, pagesBucket, item.ItemID, &p)
+				itemStatus = p.Status
+			case KindPost:
+				var p Post
+				err = boltGet(tx, postsBucket, item.ItemID, &p)
+				itemStatus = p.Status
+			}
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if matchStatus(itemStatus, status) {
+				counts[item.TermID]++
+			}
+		}
+		return nil
+	})
+	return counts, err
+}
+
+// boltTerms returns the terms of a taxonomy, in ID order.
+func boltTerms(tx *bolt.Tx, taxonomy string) ([]*Term, error) {
+	terms := []*Term{}
+	err := tx.Bucket(termsBucket).ForEach(func(k, v []byte) error {
+		var t Term
+		err := json.Unmarshal(v, &t)
+		if err != nil {
+			return err
+		}
+		if t.Taxonomy == taxonomy {
+			terms = append(terms, &t)
+		}
+		return nil
+	})
+	return terms, err
+}
+
+// termItemKey is the key recording that the item has the term.
+func termItemKey(kind string, itemID, termID int) []byte {
+	return append(append([]byte(kind+"/"), itob(itemID)...), itob(termID)...)
+}
+
+// boltTermItems decodes every key of the term items bucket.
+func boltTermItems(tx *bolt.Tx) ([]termItem, error) {
+	items := []termItem{}
+	err := tx.Bucket(termItemsBucket).ForEach(func(k, v []byte) error {
+		i := bytes.IndexByte(k, '/')
+		if i < 0 || len(k) != i+17 {
+			return fmt.Errorf("cms: bad term item key %q", k)
+		}
+		items = append(items, termItem{
+			Kind:   string(k[:i]),
+			ItemID: int(binary.BigEndian.Uint64(k[i+1 : i+9])),
+			TermID: int(binary.BigEndian.Uint64(k[i+9:])),
+		})
+		return nil
+	})
+	return items, err
+}
+
+// boltItemTerms returns the IDs of the terms on a page or post.
+func boltItemTerms(tx *bolt.Tx, kind string, itemID int) ([]int, error) {
+	prefix := append([]byte(kind+"/"), itob(itemID)...)
+	ids := []int{}
+	c := tx.Bucket(termItemsBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		ids = append(ids, int(binary.BigEndian.Uint64(k[len(prefix):])))
+	}
+	return ids, nil
+}
+
+// boltSetItemTerms replaces the terms of the taxonomy on a page or post. An
+// empty taxonomy replaces every term.
+func boltSetItemTerms(tx *bolt.Tx, taxonomy, kind string, itemID int, termIDs []int) error {
+	b := tx.Bucket(termItemsBucket)
+	old, err := boltItemTerms(tx, kind, itemID)
+	if err != nil {
+		return err
+	}
+	for _, id := range old {
+		var t Term
+		err = boltGet(tx, termsBucket, id, &t)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if taxonomy == "" || t.Taxonomy == taxonomy {
+			err = b.Delete(termItemKey(kind, itemID, id))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range termIDs {
+		err = b.Put(termItemKey(kind, itemID, id), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltTagged returns the IDs of the pages or posts with the tag.
+func boltTagged(tx *bolt.Tx, kind, slug string) (map[int]bool, error) {
+	tags, err := boltTerms(tx, TaxonomyTag)
+	if err != nil {
+		return nil, err
+	}
+	tagged := map[int]bool{}
+	items, err := boltTermItems(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		if t.Slug != slug {
+			continue
+		}
+		for _, item := range items {
+			if item.Kind == kind && item.TermID == t.ID {
+				tagged[item.ItemID] = true
+			}
+		}
+	}
+	return tagged, nil
+}
+
+// boltRevisions returns the revisions of a page or post, newest first.
+func boltRevisions(tx *bolt.Tx, kind string, itemID int) ([]*Revision, error) {
+	revs := []*Revision{}
+	err := tx.Bucket(revisionsBucket).ForEach(func(k, v []byte) error {
+		var r Revision
+		err := json.Unmarshal(v, &r)
+		if err != nil {
+			return err
+		}
+		if r.Kind == kind && r.ItemID == itemID {
+			revs = append(revs, &r)
+		}
+		return nil
+	})
+	sortRevisions(revs)
+	return revs, err
+}
+
+func (s *BoltStore) CreateImage(img *Image) (int, error) {
+	if img.DateCreated.IsZero() {
+		img.DateCreated = now()
+	}
+	var id int
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		_, err := boltImageByName(tx, img.Filename)
+		if err != ErrNotFound {
+			if err == nil {
+				err = ErrFilenameTaken
+			}
+			return err
+		}
+		id, err = boltNextID(tx, imagesBucket)
+		if err != nil {
+			return err
+		}
+		stored := *img
+		stored.ID = id
+		return boltPut(tx, imagesBucket, id, &stored)
+	})
+	return id, err
+}
+
+func (s *BoltStore) GetImage(id int) (*Image, error) {
+	var img Image
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return boltGet(tx, imagesBucket, id, &img)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+func (s *BoltStore) GetImageByName(filename string) (*Image, error) {
+	var found *Image
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltImageByName(tx, filename)
+		return err
+	})
+	return found, err
+}
+
+func (s *BoltStore) GetImages() ([]*Image, error) {
+	images := []*Image{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(k, v []byte) error {
+			var img Image
+			err := json.Unmarshal(v, &img)
+			if err != nil {
+				return err
+			}
+			images = append(images, &img)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortImages(images)
+	return images, nil
+}
+
+func (s *BoltStore) UpdateImage(img *Image) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		var stored Image
+		err := boltGet(tx, imagesBucket, img.ID, &stored)
+		if err != nil {
+			return err
+		}
+		stored.Alt = img.Alt
+		return boltPut(tx, imagesBucket, img.ID, &stored)
+	})
+}
+
+func (s *BoltStore) DeleteImage(id int) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		images := tx.Bucket(imagesBucket)
+		if images.Get(itob(id)) == nil {
+			return ErrNotFound
+		}
+		// Writing while iterating confuses the cursor, so collect the posts
+		// first
+		var featuring []*Post
+		err := tx.Bucket(postsBucket).ForEach(func(k, v []byte) error {
+			var p Post
+			err := json.Unmarshal(v, &p)
+			if err != nil {
+				return err
+			}
+			if p.FeaturedImageID == id {
+				featuring = append(featuring, &p)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range featuring {
+			p.FeaturedImageID = 0
+			err = boltPut(tx, postsBucket, p.ID, p)
+			if err != nil {
+				return err
+			}
+		}
+		return images.Delete(itob(id))
+	})
+}
+
+func (s *BoltStore) SetTranslation(t *Translation) (int, error) {
+	var id int
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		translations, err := boltTranslations(tx, t.Kind, t.ItemID)
+		if err != nil {
+			return err
+		}
+		for _, old := range translations {
+			if old.Lang == t.Lang {
+				id = old.ID
+			}
+		}
+		if id == 0 {
+			id, err = boltNextID(tx, translationsBucket)
+			if err != nil {
+				return err
+			}
+		}
+		stored := *t
+		stored.ID = id
+		return boltPut(tx, translationsBucket, id, &stored)
+	})
+	return id, err
+}
+
+func (s *BoltStore) GetTranslations(kind string, itemIDs []int) (map[int][]*Translation, error) {
+	translations := map[int][]*Translation{}
+	err := s.DB.View(func(tx *bolt.Tx) error {
+		for _, id := range itemIDs {
+			list, err := boltTranslations(tx, kind, id)
+			if err != nil {
+				return err
+			}
+			if len(list) > 0 {
+				translations[id] = list
+			}
+		}
+		return nil
+	})
+	return translations, err
+}
+
+func (s *BoltStore) DeleteTranslation(kind string, itemID int, lang string) error {
+	return s.DB.Update(func(tx *bolt.Tx) error {
+		return boltDeleteTranslations(tx, kind, itemID, lang)
+	})
+}
+
+// boltTranslations returns the translations of a page or post, by language.
+func boltTranslations(tx *bolt.Tx, kind string, itemID int) ([]*Translation, error) {
+	translations := []*Translation{}
+	err := tx.Bucket(translationsBucket).ForEach(func(k, v []byte) error {
+		var t Translation
+		err := json.Unmarshal(v, &t)
+		if err != nil {
+			return err
+		}
+		if t.Kind == kind && t.ItemID == itemID {
+			translations = append(translations, &t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortTranslations(translations)
+	return translations, nil
+}
+
+// boltDeleteTranslations deletes the translation of a page or post into the
+// language, or every translation of it if lang is empty. Deleting a single
+// translation that doesn't exist is ErrNotFound.
+func boltDeleteTranslations(tx *bolt.Tx, kind string, itemID int, lang string) error {
+	translations, err := boltTranslations(tx, kind, itemID)
+	if err != nil {
+		return err
+	}
+	deleted := false
+	for _, t := range translations {
+		if lang == "" || t.Lang == lang {
+			err = tx.Bucket(translationsBucket).Delete(itob(t.ID))
+			if err != nil {
+				return err
+			}
+			deleted = true
+		}
+	}
+	if lang != "" && !deleted {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// boltImageByName scans the images for one with the file name.
+func boltImageByName(tx *bolt.Tx, filename string) (*Image, error) {
+	var found *Image
+	err := tx.Bucket(imagesBucket).ForEach(func(k, v []byte) error {
+		var img Image
+		err := json.Unmarshal(v, &img)
+		if err != nil {
+			return err
+		}
+		if found == nil && img.Filename == filename {
+			found = &img
+		}
+		return nil
+	})
+	if err == nil && found == nil {
+		err = ErrNotFound
+	}
+	return found, err
+}
+
+// boltPageBySlug scans the pages for one with the slug.
+func boltPageBySlug(tx *bolt.Tx, slug string) (*Page, error) {
+	var found *Page
+	err := tx.Bucket(pagesBucket).ForEach(func(k, v []byte) error {
+		var p Page
+		err := json.Unmarshal(v, &p)
+		if err != nil {
+			return err
+		}
+		if found == nil && p.Slug == slug {
+			found = &p
+		}
+		return nil
+	})
+	if err == nil && found == nil {
+		err = ErrNotFound
+	}
+	return found, err
+}
+
+// boltPostBySlug scans the posts for one with the slug.
+func boltPostBySlug(tx *bolt.Tx, slug string) (*Post, error) {
+	var found *Post
+	err := tx.Bucket(postsBucket).ForEach(func(k, v []byte) error {
+		var p Post
+		err := json.Unmarshal(v, &p)
+		if err != nil {
+			return err
+		}
+		if found == nil && p.Slug == slug {
+			found = &p
+		}
+		return nil
+	})
+	if err == nil && found == nil {
+		err = ErrNotFound
+	}
+	return found, err
+}
+
+// boltDeleteRedirects removes every old slug of kind that points at id.
+func boltDeleteRedirects(tx *bolt.Tx, kind string, id int) error {
+	b := tx.Bucket(redirectsBucket)
+	prefix := []byte(kind + "/")
+	var keys [][]byte
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if int(binary.BigEndian.Uint64(v)) == id {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+	}
+	for _, k := range keys {
+		err := b.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itob encodes an ID as a big endian key, so keys sort in ID order.
+func itob(id int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+// boltNextID returns the next ID from a bucket's sequence.
+func boltNextID(tx *bolt.Tx, bucket []byte) (int, error) {
+	id, err := tx.Bucket(bucket).NextSequence()
+	return int(id), err
+}
+
+// boltGet decodes the record with the given ID into v.
+func boltGet(tx *bolt.Tx, bucket []byte, id int, v interface{}) error {
+	data := tx.Bucket(bucket).Get(itob(id))
+	if data == nil {
+		return ErrNotFound
+	}
+	return json.Unmarshal(data, v)
+}
+
+// boltPut encodes v as JSON and stores it under the given ID.
+func boltPut(tx *bolt.Tx, bucket []byte, id int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(itob(id), data)
+}
@@ -0,0 +1,376 @@
+package cms
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"html/template"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CacheSize is how many bytes of rendered HTML are kept for anonymous
+// readers, evicting the least recently used pages past it. 0 turns the cache
+// off.
+var CacheSize = 16 << 20
+
+// csrfPlaceholder stands in for the CSRF field in cached pages, since every
+// reader has their own token. It's replaced as the page is served.
+const csrfPlaceholder = "<!--cms:csrf-->"
+
+// cacheEntry is a rendered page.
+type cacheEntry struct {
+	key      string
+	body     []byte
+	etag     string
+	modified time.Time
+	// deps are what the page was rendered from, such as pageDep(id)
+	deps []string
+}
+
+// renderCache holds a site's rendered pages keyed by their cacheKey, and
+// which pages each dependency was used in, so a change can drop just those.
+type renderCache struct {
+	sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	deps    map[string]map[string]bool
+	size    int
+	// gen counts the invalidations, so a page rendered while its content
+	// changed isn't cached
+	gen uint64
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		deps:    map[string]map[string]bool{},
+	}
+}
+
+const (
+	// depPosts is the dependency of everything listing posts
+	depPosts = "posts"
+	// depComments is the dependency of everything showing comments. A
+	// comment's status can change knowing only its ID, so this can't be
+	// narrowed to the post.
+	depComments = "comments"
+)
+
+func pageDep(id int) string {
+	return "page:" + strconv.Itoa(id)
+}
+
+func postDep(id int) string {
+	return "post:" + strconv.Itoa(id)
+}
+
+func imageDep(id int) string {
+	return "image:" + strconv.Itoa(id)
+}
+
+func (c *renderCache) get(key string) *cacheEntry {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+// generation is read before a page's content is loaded, and handed back to
+// put.
+func (c *renderCache) generation() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.gen
+}
+
+// put caches a page, unless anything was invalidated since gen or it's
+// bigger than the whole cache.
+func (c *renderCache) put(e *cacheEntry, gen uint64) {
+	c.Lock()
+	defer c.Unlock()
+	size := len(e.key) + len(e.body)
+	if gen != c.gen || size > CacheSize {
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += size
+	for _, dep := range e.deps {
+		if c.deps[dep] == nil {
+			c.deps[dep] = map[string]bool{}
+		}
+		c.deps[dep][e.key] = true
+	}
+	for c.size > CacheSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *renderCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= len(e.key) + len(e.body)
+	for _, dep := range e.deps {
+		delete(c.deps[dep], e.key)
+		if len(c.deps[dep]) == 0 {
+			delete(c.deps, dep)
+		}
+	}
+}
+
+// invalidate drops the pages rendered from any of the dependencies.
+func (c *renderCache) invalidate(deps ...string) {
+	c.Lock()
+	defer c.Unlock()
+	c.gen++
+	for _, dep := range deps {
+		for key := range c.deps[dep] {
+			c.remove(c.entries[key])
+		}
+	}
+}
+
+// purge drops every page.
+func (c *renderCache) purge() {
+	c.Lock()
+	defer c.Unlock()
+	c.gen++
+	c.entries = map[string]*list.Element{}
+	c.lru.Init()
+	c.deps = map[string]map[string]bool{}
+	c.size = 0
+}
+
+// PurgeCache empties the site's rendered-page cache. Changes made through the
+// package are noticed by themselves; this is for ones made behind its back,
+// such as by editing the database.
+func (site *Site) PurgeCache() {
+	site.cache.purge()
+}
+
+// cacheable reports whether the response to r can be shared with everybody:
+// it's an anonymous GET, and it isn't for a static export.
+func cacheable(r *http.Request) bool {
+	return CacheSize > 0 && !DevMode && (r.Method == "GET" || r.Method == "HEAD") &&
+		anonymous(r) && !Exporting(r)
+}
+
+// cacheKey is what a request's page is cached under: its path and query, in
+// the language it's served in.
+func cacheKey(r *http.Request) string {
+	return LangOf(r) + " " + r.URL.RequestURI()
+}
+
+// cacheMiss is a request that wasn't in the cache, to be rendered by its
+// handler.
+type cacheMiss struct {
+	site *Site
+	r    *http.Request
+	gen  uint64
+	ok   bool
+}
+
+// serveCached serves r from the cache if it's there, returning true. Otherwise
+// the handler renders it with the returned cacheMiss.
+func serveCached(w http.ResponseWriter, r *http.Request) (*cacheMiss, bool) {
+	site := SiteOf(r)
+	if !cacheable(r) {
+		return &cacheMiss{site: site, r: r}, false
+	}
+	// Read the generation first: an invalidation between here and the
+	// render leaves the page uncached, rather than cached out of date
+	gen := site.cache.generation()
+	if e := site.cache.get(cacheKey(r)); e != nil {
+		serveEntry(w, r, e)
+		return nil, true
+	}
+	return &cacheMiss{site: site, r: r, gen: gen, ok: true}, false
+}
+
+// CSRFField is the CSRF field for the page's forms.
+func (m *cacheMiss) CSRFField() template.HTML {
+	if m.ok {
+		return csrfPlaceholder
+	}
+	return CSRFField(m.r)
+}
+
+// render renders the page like render, caching it with the dependencies when
+// the request is cacheable.
+func (m *cacheMiss) render(w http.ResponseWriter, name string, data interface{}, deps ...string) {
+	if !m.ok {
+		m.site.render(w, name, data)
+		return
+	}
+	t, err := m.site.Templates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	err = t.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := sha1.Sum(buf.Bytes())
+	e := &cacheEntry{
+		key:  cacheKey(m.r),
+		body: buf.Bytes(),
+		etag: `"` + hex.EncodeToString(sum[:8]) + `"`,
+		// Last-Modified only has seconds
+		modified: time.Now().UTC().Truncate(time.Second),
+		deps:     deps,
+	}
+	m.site.cache.put(e, m.gen)
+	serveEntry(w, m.r, e)
+}
+
+// serveEntry writes a cached page, or 304 Not Modified if the reader's copy
+// is still good.
+func serveEntry(w http.ResponseWriter, r *http.Request, e *cacheEntry) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("ETag", e.etag)
+	// Readers check back every time, which is cheap with the ETag
+	h.Set("Cache-Control", "no-cache")
+	if len(SiteOf(r).languages()) > 1 {
+		h.Set("Vary", "Accept-Language")
+	}
+	body := bytes.Replace(e.body, []byte(csrfPlaceholder), []byte(CSRFField(r)), -1)
+	http.ServeContent(w, r, "", e.modified, bytes.NewReader(body))
+}
+
+// invalidatingStore wraps a Store, dropping the cached pages rendered from
+// whatever it changes. Pages are dropped even when a change fails, since it
+// may have been partly made. New pages need nothing dropped, as only pages
+// that were found are cached.
+type invalidatingStore struct {
+	Store
+	cache *renderCache
+}
+
+// withInvalidation wraps s to invalidate the cache, unwrapping it first if
+// it's already wrapped.
+func withInvalidation(s Store, cache *renderCache) Store {
+	if wrapped, ok := s.(*invalidatingStore); ok {
+		s = wrapped.Store
+	}
+	return &invalidatingStore{s, cache}
+}
+
+// invalidateKind drops the pages of a page or post.
+func (s *invalidatingStore) invalidateKind(kind string, id int) {
+	if kind == KindPage {
+		s.cache.invalidate(pageDep(id))
+		return
+	}
+	s.cache.invalidate(postDep(id), depPosts)
+}
+
+func (s *invalidatingStore) UpdatePage(p *Page) error {
+	defer s.cache.invalidate(pageDep(p.ID))
+	return s.Store.UpdatePage(p)
+}
+
+func (s *invalidatingStore) SetPageSlug(id int, slug string) error {
+	defer s.cache.invalidate(pageDep(id))
+	return s.Store.SetPageSlug(id, slug)
+}
+
+func (s *invalidatingStore) TrashPage(id int) error {
+	defer s.cache.invalidate(pageDep(id))
+	return s.Store.TrashPage(id)
+}
+
+func (s *invalidatingStore) RestorePage(id int, slug string) error {
+	defer s.cache.invalidate(pageDep(id))
+	return s.Store.RestorePage(id, slug)
+}
+
+func (s *invalidatingStore) PurgePage(id int) error {
+	defer s.cache.invalidate(pageDep(id))
+	return s.Store.PurgePage(id)
+}
+
+func (s *invalidatingStore) CreatePost(p *Post) (int, error) {
+	defer s.cache.invalidate(depPosts)
+	return s.Store.CreatePost(p)
+}
+
+func (s *invalidatingStore) UpdatePost(p *Post) error {
+	defer s.cache.invalidate(postDep(p.ID), depPosts)
+	return s.Store.UpdatePost(p)
+}
+
+func (s *invalidatingStore) PublishDuePosts(now time.Time) ([]*Post, error) {
+	posts, err := s.Store.PublishDuePosts(now)
+	deps := []string{depPosts}
+	for _, p := range posts {
+		deps = append(deps, postDep(p.ID))
+	}
+	s.cache.invalidate(deps...)
+	return posts, err
+}
+
+func (s *invalidatingStore) DeletePost(id int) error {
+	defer s.cache.invalidate(postDep(id), depPosts)
+	return s.Store.DeletePost(id)
+}
+
+func (s *invalidatingStore) SetPostSlug(id int, slug string) error {
+	defer s.cache.invalidate(postDep(id), depPosts)
+	return s.Store.SetPostSlug(id, slug)
+}
+
+func (s *invalidatingStore) CreateComment(c *Comment) (int, error) {
+	defer s.cache.invalidate(postDep(c.PostID), depPosts)
+	return s.Store.CreateComment(c)
+}
+
+func (s *invalidatingStore) SetCommentStatus(id int, status string) error {
+	defer s.cache.invalidate(depComments)
+	return s.Store.SetCommentStatus(id, status)
+}
+
+func (s *invalidatingStore) DeleteComment(id int) error {
+	defer s.cache.invalidate(depComments)
+	return s.Store.DeleteComment(id)
+}
+
+func (s *invalidatingStore) SetItemTerms(taxonomy, kind string, itemID int, termIDs []int) error {
+	defer s.invalidateKind(kind, itemID)
+	return s.Store.SetItemTerms(taxonomy, kind, itemID, termIDs)
+}
+
+func (s *invalidatingStore) SetTranslation(t *Translation) (int, error) {
+	defer s.invalidateKind(t.Kind, t.ItemID)
+	return s.Store.SetTranslation(t)
+}
+
+func (s *invalidatingStore) DeleteTranslation(kind string, itemID int, lang string) error {
+	defer s.invalidateKind(kind, itemID)
+	return s.Store.DeleteTranslation(kind, itemID, lang)
+}
+
+func (s *invalidatingStore) UpdateImage(img *Image) error {
+	// Featured images are shown wherever their posts are
+	defer s.cache.invalidate(imageDep(img.ID), depPosts)
+	return s.Store.UpdateImage(img)
+}
+
+func (s *invalidatingStore) DeleteImage(id int) error {
+	defer s.cache.invalidate(imageDep(id), depPosts)
+	return s.Store.DeleteImage(id)
+}
@@ -0,0 +1,159 @@
+package cms
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withCacheSize sets CacheSize, and returns a func setting it back.
+func withCacheSize(size int) func() {
+	old := CacheSize
+	CacheSize = size
+	DefaultSite.cache.purge()
+	return func() {
+		CacheSize = old
+		DefaultSite.cache.purge()
+	}
+}
+
+// get serves a GET of path with the handler, with the request headers.
+func get(h http.HandlerFunc, path string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+func Test_RenderCacheEviction(t *testing.T) {
+	defer withCacheSize(30)()
+
+	c := newRenderCache()
+	put := func(key string) {
+		c.put(&cacheEntry{key: key, body: []byte("0123456789"), deps: []string{"dep:" + key}}, c.generation())
+	}
+	put("/a")
+	put("/b")
+	if c.get("/a") == nil {
+		t.Fatal("Expected /a to be cached")
+	}
+	// /b is now the least recently used, so it makes way for /c
+	put("/c")
+	if c.get("/b") != nil || c.get("/a") == nil || c.get("/c") == nil {
+		t.Errorf("Expected /b to be evicted, leaving %d bytes\n", c.size)
+	}
+	if _, ok := c.deps["dep:/b"]; ok {
+		t.Errorf("Expected the evicted page's dependencies forgotten\n")
+	}
+
+	gen := c.generation()
+	c.invalidate("dep:/a")
+	if c.get("/a") != nil || c.get("/c") == nil {
+		t.Errorf("Expected only /a to be invalidated\n")
+	}
+	c.put(&cacheEntry{key: "/stale", deps: []string{"x"}}, gen)
+	if c.get("/stale") != nil {
+		t.Errorf("Expected a page rendered before an invalidation not to be cached\n")
+	}
+	c.put(&cacheEntry{key: "/huge", body: make([]byte, 100)}, c.generation())
+	if c.get("/huge") != nil || c.size > CacheSize {
+		t.Errorf("Expected a page bigger than the cache not to be cached, size %d\n", c.size)
+	}
+}
+
+func Test_PageCache(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer withCacheSize(1 << 20)()
+
+		page := &Page{Title: "Cached", Content: "First draft", Status: StatusPublished}
+		id, err := CreatePage(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.ID = id
+
+		w := get(ServePage, "/page/cached")
+		etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+		if w.Code != http.StatusOK || etag == "" || modified == "" {
+			t.Fatalf("Expected the page with an ETag and Last-Modified, got %d %v\n", w.Code, w.Header())
+		}
+		if w = get(ServePage, "/page/cached", "If-None-Match", etag); w.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for the same ETag, got %d\n", w.Code)
+		}
+		if w = get(ServePage, "/page/cached", "If-Modified-Since", modified); w.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 when not modified since, got %d\n", w.Code)
+		}
+
+		page.Content = "Second draft"
+		err = UpdatePage(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = get(ServePage, "/page/cached", "If-None-Match", etag)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Second draft") {
+			t.Errorf("Expected the edit to be served, got %d %s\n", w.Code, w.Body.String())
+		}
+
+		err = DefaultSite.store.UpdatePage(&Page{ID: id, Title: "Cached", Content: "Draft", Status: StatusDraft})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w = get(ServePage, "/page/cached"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected an unpublished page to be dropped from the cache, got %d\n", w.Code)
+		}
+		logout := loginAs("ann", RoleAuthor)
+		w = get(ServePage, "/page/cached")
+		logout()
+		if w.Code != http.StatusOK || w.Header().Get("ETag") != "" {
+			t.Errorf("Expected a logged-in user's page not to be cached, got %d %v\n", w.Code, w.Header())
+		}
+	})
+}
+
+func Test_PostCache(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer withCacheSize(1 << 20)()
+		oldField := CSRFField
+		defer func() { CSRFField = oldField }()
+		token := 0
+		CSRFField = func(r *http.Request) template.HTML {
+			token++
+			return template.HTML(`<input name="token" value="` + strconv.Itoa(token) + `">`)
+		}
+
+		postID, err := CreatePost(&Post{Title: "Talked about", Content: "text", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := get(ServePost, "/post/talked-about")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="1"`) {
+			t.Fatalf("Expected the post with a CSRF field, got %d %s\n", w.Code, w.Body.String())
+		}
+		w = get(ServePost, "/post/talked-about")
+		if !strings.Contains(w.Body.String(), `value="2"`) || strings.Contains(w.Body.String(), csrfPlaceholder) {
+			t.Errorf("Expected every reader their own CSRF field, got %s\n", w.Body.String())
+		}
+		index := get(ServeIndex, "/").Header().Get("ETag")
+
+		id, err := DefaultSite.store.CreateComment(&Comment{PostID: postID, Author: "Reader", Comment: "Nice one", Status: CommentPending})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetCommentStatus(id, CommentApproved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w = get(ServePost, "/post/talked-about"); !strings.Contains(w.Body.String(), "Nice one") {
+			t.Errorf("Expected the approved comment on the post, got %s\n", w.Body.String())
+		}
+		if w = get(ServeIndex, "/", "If-None-Match", index); w.Code != http.StatusOK {
+			t.Errorf("Expected the home page to change with the comment, got %d\n", w.Code)
+		}
+	})
+}
@@ -1,15 +1,433 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gorilla/csrf"
 	"github.com/jywei/toy-projects/cms"
+	"github.com/jywei/toy-projects/users"
 )
 
+const usage = `Usage: cmd [flags] [command]
+
+The export, import and migrate commands act on the site chosen with -site.
+
+Commands:
+  serve                 run the cms web server (the default)
+  migrate up            apply every pending migration
+  migrate down [steps]  revert the last migration, or the last steps of them
+  migrate status        list migrations and whether they've been applied
+  export [-out dir] [-images dir]
+                        render the published site into dir as static files
+  import [-apply] [-uploads dir] source...
+                        import WordPress exports (.xml files) and directories
+                        of Markdown files, reporting what would be imported
+                        unless -apply is given
+  user name password [role]
+                        add a user who can log in, as an author, editor or
+                        admin, or change an existing user's role
+
+Flags:
+`
+
+// schedulerInterval is how often scheduled posts are checked for publishing
+const schedulerInterval = time.Minute
+
 func main() {
+	backend := flag.String("store", "memory", "storage backend: memory, bolt or postgres")
+	dsn := flag.String("dsn", "", "bolt file or postgres connection string, defaults depend on -store")
+	flag.StringVar(&cms.BaseURL, "base-url", cms.BaseURL, "public URL of the site, used for links in feeds")
+	flag.IntVar(&cms.Spam.MaxLinks, "max-links", cms.Spam.MaxLinks, "links a comment may have before it's spam, -1 for any")
+	blocked := flag.String("blocked-words", "", "comma separated words that mark a comment as spam")
+	flag.IntVar(&cms.Spam.RateLimit, "comment-rate", cms.Spam.RateLimit, "comments an address may leave every 10 minutes, 0 for any")
+	flag.IntVar(&cms.MaxCommentDepth, "comment-depth", cms.MaxCommentDepth, "how deeply comment replies nest")
+	flag.StringVar(&cms.DefaultTheme, "templates", cms.DefaultTheme, "directory of the default theme")
+	flag.StringVar(&cms.Theme, "theme", "", "directory of a theme overriding the default one")
+	flag.BoolVar(&cms.DevMode, "dev", false, "reload templates when they change")
+	flag.StringVar(&cms.ImageDir, "image-dir", cms.ImageDir, "directory uploaded images are kept in")
+	flag.StringVar(&cms.SnippetDir, "snippet-dir", cms.SnippetDir, "directory of the files the snippet shortcode shows")
+	flag.IntVar(&cms.CacheSize, "cache-size", cms.CacheSize, "bytes of rendered pages cached for anonymous readers, 0 for none")
+	sitesFile := flag.String("sites", "", "JSON file of the sites served besides the default one, chosen by host")
+	siteName := flag.String("site", "default", "site the export, import and migrate commands act on")
+	previewKey := flag.String("preview-key", "", "secret signing preview links, which otherwise stop working when the server restarts")
+	languages := flag.String("languages", strings.Join(cms.Languages, ","), "comma separated languages the sites can be read in, the first being the one content is written in, for sites without languages of their own")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	cms.Spam.BlockedWords = cms.ParseTerms(*blocked)
+	if *previewKey != "" {
+		cms.PreviewKey = []byte(*previewKey)
+	}
+	cms.Languages = cms.ParseTerms(strings.ToLower(*languages))
+	if len(cms.Languages) == 0 {
+		log.Fatal("-languages needs at least one language")
+	}
+	err := cms.LoadTemplates()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := cms.Open(*backend, *dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	cms.SetStore(store)
+	stores := map[string]cms.Store{cms.DefaultSite.Name: store}
+	if *sitesFile != "" {
+		err = addSites(*sitesFile, *backend, stores)
+		for _, s := range stores {
+			if s != store {
+				defer s.Close()
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	site, err := cms.GetSite(*siteName)
+	if err != nil {
+		log.Fatalf("unknown site %q", *siteName)
+	}
+
+	switch flag.Arg(0) {
+	case "", "serve":
+		serve()
+	case "migrate":
+		err = migrate(stores[site.Name], flag.Args()[1:])
+	case "export":
+		err = export(site, flag.Args()[1:])
+	case "import":
+		err = importContent(site, flag.Args()[1:])
+	case "user":
+		err = addUser(flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serve() {
 	http.HandleFunc("/", cms.ServeIndex)
-	http.HandleFunc("/new", cms.HandleNew)
-	http.HandleFunc("/page", cms.ServePage)
-	http.HandleFunc("/post", cms.ServePost)
-	http.ListenAndServe(":3000", nil)
+	http.Handle("/login", users.CSRF(http.HandlerFunc(login)))
+	http.Handle("/new", users.CSRF(authored(cms.HandleNew)))
+	http.HandleFunc("/page/", cms.ServePage)
+	// Pages with forms readers can post are protected from CSRF
+	http.Handle("/post/", users.CSRF(http.HandlerFunc(cms.ServePost)))
+	http.Handle("/comments", users.CSRF(http.HandlerFunc(cms.HandleComment)))
+	http.HandleFunc("/search", cms.ServeSearch)
+	http.HandleFunc("/feed.rss", cms.ServeFeed)
+	http.HandleFunc("/feed.atom", cms.ServeFeed)
+	http.Handle("/history/", users.CSRF(authored(cms.ServeHistory)))
+	http.HandleFunc("/tag/", cms.ServeTag)
+	http.HandleFunc("/category/", cms.ServeCategory)
+	http.HandleFunc("/theme/", cms.ServeTheme)
+	http.Handle("/admin/posts", users.CSRF(authored(cms.ServeAdminPosts)))
+	http.Handle("/admin/comments", users.CSRF(authored(cms.ServeAdminComments)))
+	http.Handle("/admin/pages/", users.CSRF(authored(cms.ServeAdminPages)))
+	http.Handle("/admin/trash", users.CSRF(authored(cms.ServeTrash)))
+	http.Handle("/admin/media", users.CSRF(authored(cms.ServeMedia)))
+	http.HandleFunc("/image/", cms.ServeImage)
+	http.HandleFunc("/preview/", cms.ServePreview)
+	http.Handle("/admin/translations", users.CSRF(authored(cms.ServeTranslations)))
+	http.Handle("/admin/translations/", users.CSRF(authored(cms.ServeTranslations)))
+	// /lang/{lang}/ serves the rest of the site in the language
+	http.Handle("/lang/", cms.LangPrefix(http.DefaultServeMux))
+	cms.CSRFField = csrf.TemplateField
+	cms.CurrentUser = func(r *http.Request) string {
+		user, _ := users.SessionUser(r)
+		return user
+	}
+	cms.UserRole = users.GetRole
+
+	// The scheduler runs for as long as the server does
+	cms.StartScheduler(schedulerInterval)
+	log.Fatal(http.ListenAndServe(":3000", nil))
+}
+
+// siteConfig is a site in the -sites file
+type siteConfig struct {
+	Name  string   `json:"name"`
+	Hosts []string `json:"hosts"`
+	Title string   `json:"title"`
+	// BaseURL, Theme, ImageDir, SnippetDir and Languages default to the
+	// flags of the same names
+	BaseURL    string   `json:"base_url"`
+	Theme      string   `json:"theme"`
+	ImageDir   string   `json:"image_dir"`
+	SnippetDir string   `json:"snippet_dir"`
+	Languages  []string `json:"languages"`
+	// DSN is the site's own database, in the -store backend
+	DSN string `json:"dsn"`
+}
+
+// addSites opens the store of every site in the file and starts serving it,
+// adding the stores to stores by site name
+func addSites(name, backend string, stores map[string]cms.Store) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var configs []siteConfig
+	err = json.Unmarshal(data, &configs)
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", name, err)
+	}
+	for _, c := range configs {
+		if backend != "memory" && c.DSN == "" {
+			return fmt.Errorf("site %q needs a dsn of its own", c.Name)
+		}
+		store, err := cms.Open(backend, c.DSN)
+		if err != nil {
+			return fmt.Errorf("opening the store of site %q: %s", c.Name, err)
+		}
+		stores[c.Name] = store
+		site := cms.NewSite(c.Name, store)
+		site.Hosts = c.Hosts
+		site.Title = c.Title
+		site.BaseURL = c.BaseURL
+		site.Theme = c.Theme
+		site.ImageDir = c.ImageDir
+		site.SnippetDir = c.SnippetDir
+		site.Languages = cms.ParseTerms(strings.ToLower(strings.Join(c.Languages, ",")))
+		err = cms.AddSite(site)
+		if err == cms.ErrSiteTaken {
+			return fmt.Errorf("site %q: another site has its name or one of its hosts", c.Name)
+		} else if err != nil {
+			return fmt.Errorf("site %q: %s", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// authored only lets logged-in users through to the authoring pages
+func authored(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if users.GetSession(w, r) == "" {
+			return
+		}
+		h(w, r)
+	})
+}
+
+const loginTemplate = `<h1>Log in</h1>
+<form action="/login" method="POST">
+	{{ .CSRFField }}
+	<input type="hidden" name="next" value="{{ .Next }}">
+	<label for="user">Email</label>
+	<input type="email" name="user" required>
+	<label for="password">Password</label>
+	<input type="password" name="password" required>
+	<input type="submit" value="Log in">
+</form>
+`
+
+var loginPage = template.Must(template.New("login").Parse(loginTemplate))
+
+// login logs a user in, and sends them back to where they were going
+func login(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		loginPage.Execute(w, struct {
+			CSRFField template.HTML
+			Next      string
+		}{csrf.TemplateField(r), r.FormValue("next")})
+	case "POST":
+		user := r.FormValue("user")
+		err := users.AuthenticateUser(user, r.FormValue("password"))
+		if err != nil {
+			http.Error(w, "Wrong email or password", http.StatusUnauthorized)
+			return
+		}
+		users.SetSession(w, user)
+		next := r.FormValue("next")
+		// Only go on to this site, never somewhere a link chose
+		if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+			next = "/"
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	default:
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+// addUser runs the user subcommand
+func addUser(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	name, password, role := args[0], args[1], cms.RoleAuthor
+	if len(args) == 3 {
+		role = args[2]
+	}
+	known := false
+	for _, r := range cms.Roles {
+		known = known || r == role
+	}
+	if !known {
+		return fmt.Errorf("unknown role %q, use one of %s", role, strings.Join(cms.Roles, ", "))
+	}
+
+	err := users.NewUser(name, password)
+	if err == users.ErrUserAlreadyExists {
+		fmt.Printf("%s already exists, only changing their role.\n", name)
+	} else if err != nil {
+		return err
+	}
+	err = users.SetRole(name, role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is an %s.\n", name, role)
+	return nil
+}
+
+// export runs the export subcommand, which has flags of its own
+func export(site *cms.Site, args []string) error {
+	imageDir := site.ImageDir
+	if imageDir == "" {
+		imageDir = cms.ImageDir
+	}
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "public", "directory to write the site to")
+	images := fs.String("images", imageDir, "directory of uploaded images to copy")
+	fs.Parse(args)
+
+	stats, err := site.Export(*out, *images)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported to %s: %d files written, %d unchanged.\n", *out, stats.Written, stats.Unchanged)
+	return nil
+}
+
+// importContent runs the import subcommand, which has flags of its own
+func importContent(site *cms.Site, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	apply := fs.Bool("apply", false, "import for real, rather than only reporting what would be imported")
+	uploads := fs.String("uploads", "", "copy of the WordPress wp-content/uploads directory, for its images")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var items []*cms.ImportItem
+	for _, source := range fs.Args() {
+		info, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		var read []*cms.ImportItem
+		if info.IsDir() {
+			read, err = cms.ReadMarkdownDir(source)
+		} else {
+			read, err = readWXR(source)
+		}
+		if err != nil {
+			return err
+		}
+		items = append(items, read...)
+	}
+
+	report, err := site.Import(items, *uploads, !*apply)
+	if report != nil {
+		report.Print(os.Stdout)
+	}
+	if err == nil && !*apply {
+		fmt.Println("Run again with -apply to import.")
+	}
+	return err
+}
+
+// readWXR reads the WordPress export in the file
+func readWXR(name string) ([]*cms.ImportItem, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	items, err := cms.ReadWXR(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %s", name, err)
+	}
+	return items, nil
+}
+
+// migrate runs the migrate subcommand against the store
+func migrate(store cms.Store, args []string) error {
+	m, ok := store.(cms.Migrator)
+	if !ok {
+		fmt.Println("This store has no schema, there is nothing to migrate.")
+		return nil
+	}
+
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.MigrateUp()
+		for _, mig := range done {
+			fmt.Printf("Applied %03d %s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("Already up to date.")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps: %q", args[1])
+			}
+			steps = n
+		}
+		done, err := m.MigrateDown(steps)
+		for _, mig := range done {
+			fmt.Printf("Reverted %03d %s\n", mig.Version, mig.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("No migrations to revert.")
+		}
+		return err
+
+	case "status":
+		status, err := m.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, st := range status {
+			applied := "pending"
+			if st.Applied {
+				applied = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%03d %-40s %s\n", st.Version, st.Name, applied)
+		}
+		return nil
+	}
+
+	flag.Usage()
+	os.Exit(2)
+	return nil
 }
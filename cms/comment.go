@@ -0,0 +1,170 @@
+package cms
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The states of a comment. Readers' comments start out pending, and only
+// approved comments are shown.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+	CommentSpam     = "spam"
+)
+
+// CommentStatuses lists every comment status, pending first.
+var CommentStatuses = []string{CommentPending, CommentApproved, CommentRejected, CommentSpam}
+
+var (
+	// ErrEmptyComment is returned for a comment without an author or text.
+	ErrEmptyComment = errors.New("cms: a comment needs a name and some text")
+
+	// ErrCommentRate is returned when an address has left too many comments
+	// too quickly.
+	ErrCommentRate = errors.New("cms: too many comments, try again later")
+
+	// ErrBadParent is returned for a reply to a comment that isn't on the
+	// same post.
+	ErrBadParent = errors.New("cms: replies must be to a comment on the same post")
+)
+
+// MaxCommentDepth is how deeply replies nest. Replies any deeper are shown
+// after the other replies at the deepest level, and 0 shows every comment at
+// the top level.
+var MaxCommentDepth = 4
+
+// CSRFField returns the hidden form field protecting the request's forms from
+// cross-site request forgery. Forms work without one until the application
+// replaces it, typically with csrf.TemplateField.
+var CSRFField = func(r *http.Request) template.HTML {
+	return ""
+}
+
+// matchCommentStatus reports whether a comment's status passes the filter,
+// where an empty filter matches everything. Comments left before there was
+// moderation are approved.
+func matchCommentStatus(status, filter string) bool {
+	if status == "" {
+		status = CommentApproved
+	}
+	return filter == "" || status == filter
+}
+
+// threadComments nests replies under the comments they reply to, filling in
+// Depth and Replies. comments must be oldest first, and replies keep that
+// order. A reply whose parent isn't among comments, say because it's waiting
+// for moderation, is left out along with its own replies.
+func threadComments(comments []*Comment, maxDepth int) []*Comment {
+	byID := map[int]*Comment{}
+	for _, c := range comments {
+		c.Depth, c.Replies = 0, nil
+		byID[c.ID] = c
+	}
+
+	// depths are the real nesting of each comment, or -1 if it's left out
+	depths := map[int]int{}
+	var depth func(c *Comment, seen int) int
+	depth = func(c *Comment, seen int) int {
+		if d, ok := depths[c.ID]; ok {
+			return d
+		}
+		d := 0
+		if c.ParentID != 0 {
+			parent := byID[c.ParentID]
+			// seen guards against a loop of replies, which can't be saved
+			// but would otherwise never end
+			if parent == nil || seen > len(comments) {
+				d = -1
+			} else if d = depth(parent, seen+1); d >= 0 {
+				d++
+			}
+		}
+		depths[c.ID] = d
+		return d
+	}
+
+	thread := []*Comment{}
+	for _, c := range comments {
+		d := depth(c, 0)
+		if d < 0 {
+			continue
+		}
+		if d > maxDepth {
+			d = maxDepth
+		}
+		c.Depth = d
+		if d == 0 {
+			thread = append(thread, c)
+			continue
+		}
+		// Hang the reply on its ancestor one level up from where it's shown
+		parent := byID[c.ParentID]
+		for depths[parent.ID] >= d {
+			parent = byID[parent.ParentID]
+		}
+		parent.Replies = append(parent.Replies, c)
+	}
+	return thread
+}
+
+// checkParent makes sure a reply is to a comment on the same post.
+func (site *Site) checkParent(c *Comment) error {
+	if c.ParentID == 0 {
+		return nil
+	}
+	comments, err := site.store.GetComments(c.PostID, "")
+	if err != nil {
+		return err
+	}
+	for _, parent := range comments {
+		if parent.ID == c.ParentID {
+			return nil
+		}
+	}
+	return ErrBadParent
+}
+
+// SubmitComment runs a reader's comment through the Spam filter and saves it
+// for moderation: pending, or marked as spam. honeypot is the value of the
+// filter's honeypot field.
+func (site *Site) SubmitComment(c *Comment, honeypot string) (int, error) {
+	c.Author = strings.TrimSpace(c.Author)
+	c.Comment = strings.TrimSpace(c.Comment)
+	if c.Author == "" || c.Comment == "" {
+		return 0, ErrEmptyComment
+	}
+	err := site.checkParent(c)
+	if err != nil {
+		return 0, err
+	}
+	if !Spam.Allow(c.IP, time.Now()) {
+		return 0, ErrCommentRate
+	}
+	c.Status = CommentPending
+	if Spam.Check(c, honeypot) != "" {
+		c.Status = CommentSpam
+	}
+	return site.store.CreateComment(c)
+}
+
+// GetCommentQueue returns the comments on every post with the status, oldest
+// first, for moderation.
+func (site *Site) GetCommentQueue(status string) ([]*Comment, error) {
+	if !contains(CommentStatuses, status) {
+		return nil, ErrBadStatus
+	}
+	return site.store.GetCommentsByStatus(status)
+}
+
+// SetCommentStatus approves, rejects or marks a comment as spam.
+func (site *Site) SetCommentStatus(id int, status string) error {
+	if !contains(CommentStatuses, status) {
+		return ErrBadStatus
+	}
+	return site.store.SetCommentStatus(id, status)
+}
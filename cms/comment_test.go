@@ -0,0 +1,268 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withSpamFilter swaps in a fresh filter for the test, so that the rate limit
+// doesn't carry over between tests, and returns a func restoring the old one.
+func withSpamFilter(f *SpamFilter) func() {
+	old := Spam
+	Spam = f
+	return func() { Spam = old }
+}
+
+func Test_SpamCheck(t *testing.T) {
+	f := &SpamFilter{MaxLinks: 1, BlockedWords: []string{"Casino"}, Honeypot: "website"}
+
+	tests := []struct {
+		comment  string
+		honeypot string
+		spam     bool
+	}{
+		{"Nice post, thanks", "", false},
+		{"See https://example.com", "", false},
+		{"See https://example.com and www.example.org", "", true},
+		{"Best online casino here", "", true},
+		{"Nice post, thanks", "http://bot.example", true},
+	}
+	for _, test := range tests {
+		reason := f.Check(&Comment{Author: "reader", Comment: test.comment}, test.honeypot)
+		if (reason != "") != test.spam {
+			t.Errorf("Check(%q, %q) = %q, expected spam: %t\n", test.comment, test.honeypot, reason, test.spam)
+		}
+	}
+}
+
+func Test_SpamRateLimit(t *testing.T) {
+	f := &SpamFilter{RateLimit: 2, RateWindow: time.Minute}
+	start := time.Now()
+
+	if !f.Allow("1.2.3.4", start) || !f.Allow("1.2.3.4", start.Add(time.Second)) {
+		t.Errorf("Expected the first comments to be allowed\n")
+	}
+	if f.Allow("1.2.3.4", start.Add(2*time.Second)) {
+		t.Errorf("Expected a third comment within the window to be refused\n")
+	}
+	if !f.Allow("5.6.7.8", start.Add(2*time.Second)) {
+		t.Errorf("Expected another address to be allowed\n")
+	}
+	if !f.Allow("1.2.3.4", start.Add(time.Minute+time.Second)) {
+		t.Errorf("Expected a comment after the window to be allowed\n")
+	}
+}
+
+func Test_CommentModeration(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer withSpamFilter(&SpamFilter{MaxLinks: 2, Honeypot: "website"})()
+
+		postID, err := CreatePost(&Post{Title: "Moderated", Content: "text", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = SubmitComment(&Comment{PostID: postID, Author: " ", Comment: "hello"}, "")
+		if err != ErrEmptyComment {
+			t.Errorf("Expected ErrEmptyComment, got %v\n", err)
+		}
+
+		id, err := SubmitComment(&Comment{PostID: postID, Author: "Reader", Comment: "First!", IP: "1.2.3.4"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = SubmitComment(&Comment{PostID: postID, Author: "Bot", Comment: "Buy now"}, "filled")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		comments, err := GetComments(postID)
+		if err != nil || len(comments) != 0 {
+			t.Errorf("Expected no visible comments before moderation, got %+v, %v\n", comments, err)
+		}
+
+		pending, err := GetCommentQueue(CommentPending)
+		if err != nil || len(pending) != 1 || pending[0].ID != id || pending[0].IP != "1.2.3.4" {
+			t.Errorf("Expected the reader's comment in the queue, got %+v, %v\n", pending, err)
+		}
+		spam, err := GetCommentQueue(CommentSpam)
+		if err != nil || len(spam) != 1 || spam[0].Author != "Bot" {
+			t.Errorf("Expected the bot's comment to be spam, got %+v, %v\n", spam, err)
+		}
+
+		err = SetCommentStatus(id, CommentApproved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		comments, err = GetComments(postID)
+		if err != nil || len(comments) != 1 || comments[0].ID != id {
+			t.Errorf("Expected the approved comment to be visible, got %+v, %v\n", comments, err)
+		}
+
+		if err = SetCommentStatus(id, "bogus"); err != ErrBadStatus {
+			t.Errorf("Expected ErrBadStatus, got %v\n", err)
+		}
+		if err = SetCommentStatus(id+100, CommentRejected); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v\n", err)
+		}
+	})
+}
+
+func Test_CommentHandlers(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer withSpamFilter(&SpamFilter{MaxLinks: 2, Honeypot: "website", RateLimit: 1, RateWindow: time.Minute})()
+
+		post := &Post{Title: "Comment on me", Content: "text", Status: StatusPublished}
+		postID, err := CreatePost(post)
+		if err != nil {
+			t.Fatal(err)
+		}
+		draftID, err := CreatePost(&Post{Title: "Hidden", Content: "text"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		submit := func(form url.Values) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("POST", "/comments", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			HandleComment(w, r)
+			return w
+		}
+
+		w := submit(url.Values{"post_id": {strconv.Itoa(draftID)}, "author": {"Reader"}, "comment": {"hi"}})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 commenting on a draft, got %d\n", w.Code)
+		}
+
+		w = submit(url.Values{"post_id": {strconv.Itoa(postID)}, "author": {"Reader"}, "comment": {"hi"}})
+		if w.Code != http.StatusSeeOther || !strings.Contains(w.Header().Get("Location"), "comment=pending") {
+			t.Errorf("Expected a redirect back to the post, got %d %q\n", w.Code, w.Header().Get("Location"))
+		}
+
+		w = submit(url.Values{"post_id": {strconv.Itoa(postID)}, "author": {"Reader"}, "comment": {"again"}})
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected 429 over the rate limit, got %d\n", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		ServeAdminComments(w, httptest.NewRequest("GET", "/admin/comments", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "hi") {
+			t.Errorf("Expected the comment in the moderation queue, got %d\n", w.Code)
+		}
+
+		pending, err := GetCommentQueue(CommentPending)
+		if err != nil || len(pending) != 1 {
+			t.Fatalf("Expected one pending comment, got %+v, %v\n", pending, err)
+		}
+		form := url.Values{"id": {strconv.Itoa(pending[0].ID)}, "status": {CommentApproved}, "from": {"pending\r\nX: y"}}
+		logout := loginAs("ann", RoleAuthor)
+		if w = postForm(ServeAdminComments, "/admin/comments", form); w.Code != http.StatusForbidden {
+			t.Errorf("Expected an author not to moderate, got %d\n", w.Code)
+		}
+		logout()
+		logout = loginAs("eve", RoleEditor)
+		w = postForm(ServeAdminComments, "/admin/comments", form)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/comments" {
+			t.Errorf("Expected a redirect to the queue after moderating, got %d %q\n", w.Code, w.Header().Get("Location"))
+		}
+		logout()
+
+		w = httptest.NewRecorder()
+		ServePost(w, httptest.NewRequest("GET", "/post/"+post.Slug, nil))
+		body := w.Body.String()
+		if !strings.Contains(body, "<p>hi</p>") || !strings.Contains(body, `name="website"`) {
+			t.Errorf("Expected the approved comment and the form on the post, got %s\n", body)
+		}
+	})
+}
+
+func Test_ThreadComments(t *testing.T) {
+	// 1 <- 2 <- 3 <- 4, 1 <- 5, 6, and 7 replies to a comment that isn't shown
+	comments := []*Comment{
+		{ID: 1}, {ID: 2, ParentID: 1}, {ID: 3, ParentID: 2}, {ID: 4, ParentID: 3},
+		{ID: 5, ParentID: 1}, {ID: 6}, {ID: 7, ParentID: 99},
+	}
+
+	thread := threadComments(comments, 2)
+	if len(thread) != 2 || thread[0].ID != 1 || thread[1].ID != 6 {
+		t.Fatalf("Expected comments 1 and 6 at the top, got %+v\n", thread)
+	}
+	replies := thread[0].Replies
+	if len(replies) != 2 || replies[0].ID != 2 || replies[1].ID != 5 {
+		t.Fatalf("Expected replies 2 and 5 to comment 1, got %+v\n", replies)
+	}
+	deepest := replies[0].Replies
+	if len(deepest) != 2 || deepest[0].ID != 3 || deepest[1].ID != 4 {
+		t.Errorf("Expected 3 and 4 flattened under 2, got %+v\n", deepest)
+	}
+	for _, c := range deepest {
+		if c.Depth != 2 {
+			t.Errorf("Expected comment %d at depth 2, got %d\n", c.ID, c.Depth)
+		}
+	}
+
+	flat := threadComments(comments, 0)
+	if len(flat) != 6 {
+		t.Errorf("Expected every shown comment at the top with no nesting, got %d\n", len(flat))
+	}
+}
+
+func Test_CommentReplies(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		postID, err := CreatePost(&Post{Title: "Threads", Content: "text", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherID, err := CreatePost(&Post{Title: "Elsewhere", Content: "text", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rootID, err := CreateComment(&Comment{PostID: postID, Author: "a", Comment: "root"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		replyID, err := CreateComment(&Comment{PostID: postID, ParentID: rootID, Author: "b", Comment: "reply"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreateComment(&Comment{PostID: postID, ParentID: replyID, Author: "c", Comment: "nested"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreateComment(&Comment{PostID: otherID, ParentID: rootID, Author: "d", Comment: "lost"})
+		if err != ErrBadParent {
+			t.Errorf("Expected ErrBadParent replying across posts, got %v\n", err)
+		}
+
+		thread, err := GetComments(postID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(thread) != 1 || len(thread[0].Replies) != 1 || len(thread[0].Replies[0].Replies) != 1 {
+			t.Fatalf("Expected a thread three deep, got %+v\n", thread)
+		}
+		if nested := thread[0].Replies[0].Replies[0]; nested.Comment != "nested" || nested.Depth != 2 {
+			t.Errorf("Expected the nested reply at depth 2, got %+v\n", nested)
+		}
+
+		err = DeleteComment(replyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread, err = GetComments(postID)
+		if err != nil || len(thread) != 1 || len(thread[0].Replies) != 0 {
+			t.Errorf("Expected the reply and its replies to be deleted, got %+v, %v\n", thread, err)
+		}
+		all, err := DefaultSite.store.GetComments(postID, "")
+		if err != nil || len(all) != 1 {
+			t.Errorf("Expected only the root comment left, got %d, %v\n", len(all), err)
+		}
+	})
+}
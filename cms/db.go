@@ -2,37 +2,256 @@ package cms
 
 import (
 	"database/sql"
+	"html"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
 
-	// Use the PG SQL driver
-	_ "github.com/lib/pq"
+	// The PG SQL driver, also used for its error codes
+	"github.com/lib/pq"
 )
 
-var store = newDB()
-
+// PgStore is the Postgres backed Store.
 type PgStore struct {
 	DB *sql.DB
 }
 
-func newDB() *PgStore {
-	db, err := sql.Open("postgres", "user=goprojects dbname=goprojects sslmode=disable")
+// NewPgStore connects to the Postgres database described by dsn.
+func NewPgStore(dsn string) (*PgStore, error) {
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	return &PgStore{
 		DB: db,
-	}
+	}, nil
 }
 
-func GetPage(id string) (*Page, error) {
+// Close closes the database connection.
+func (s *PgStore) Close() error {
+	return s.DB.Close()
+}
+
+// pageColumns are the columns scanned by scanPage, in order
+const pageColumns = "id, slug, title, content, status, version, date_created, author"
+
+// scanPage scans a row selected with pageColumns.
+func scanPage(row interface{ Scan(...interface{}) error }) (*Page, error) {
 	var p Page
-	err := store.DB.QueryRow("SELECT * FROM pages WHERE id = $1", id).Scan(&p.ID, &p.Title, &p.Content)
-	return &p, err
+	err := row.Scan(&p.ID, &p.Slug, &p.Title, &p.Content, &p.Status, &p.Version, &p.DateCreated, &p.Author)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &p, nil
+}
+
+func (s *PgStore) GetPage(id int) (*Page, error) {
+	return scanPage(s.DB.QueryRow("SELECT "+pageColumns+" FROM pages WHERE id = $1", id))
 }
 
-// GetPages is the new function that allows us to get every page from our db
-func GetPages() ([]*Page, error) {
-	rows, err := store.DB.Query("SELECT * FROM pages")
+func (s *PgStore) GetPageBySlug(slug string) (*Page, error) {
+	return scanPage(s.DB.QueryRow("SELECT "+pageColumns+" FROM pages WHERE slug = $1", slug))
+}
+
+func (s *PgStore) GetPages() ([]*Page, error) {
+	return s.queryPages("SELECT " + pageColumns + " FROM pages ORDER BY id")
+}
+
+// queryPages runs a query selecting pageColumns.
+func (s *PgStore) queryPages(query string, args ...interface{}) ([]*Page, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []*Page{}
+	for rows.Next() {
+		p, err := scanPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, p)
+	}
+	return pages, rows.Err()
+}
+
+// pageSortColumns maps each sort order onto what it sorts by
+var pageSortColumns = map[string]string{
+	SortID:    "id",
+	SortTitle: "lower(title)",
+	SortDate:  "date_created",
+}
+
+func (s *PgStore) ListPages(q PageQuery) (*PageList, error) {
+	column := pageSortColumns[q.Sort]
+	where := `strpos(lower(title), lower($1)) > 0 AND ($2 = '' OR status = $2) AND
+		($3 = '' OR id IN (SELECT ti.item_id FROM term_items ti JOIN terms t ON t.id = ti.term_id
+			WHERE ti.kind = 'page' AND t.taxonomy = 'tag' AND t.slug = $3))`
+	args := []interface{}{q.Title, q.Status, q.Tag}
+
+	var total int
+	err := s.DB.QueryRow("SELECT count(*) FROM pages WHERE "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keyset pagination compares (sort column, id) against the cursor's
+	// page. Going backwards runs the query in reverse and flips the results.
+	forward := q.Before == ""
+	desc := q.Desc != !forward
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	cursor := q.After
+	if !forward {
+		cursor = q.Before
+	}
+	if cursor != "" {
+		c, err := decodeCursor(cursor, q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = c.ID
+		switch q.Sort {
+		case SortTitle:
+			value = strings.ToLower(c.Title)
+		case SortDate:
+			value = c.DateCreated
+		}
+		where += " AND (" + column + ", id) " + cmp + " ($4, $5)"
+		args = append(args, value, c.ID)
+	}
+
+	query := "SELECT " + pageColumns + " FROM pages WHERE " + where +
+		" ORDER BY " + column + " " + dir + ", id " + dir +
+		" LIMIT " + strconv.Itoa(q.Limit+1)
+	if cursor == "" && q.Offset > 0 {
+		query += " OFFSET " + strconv.Itoa(q.Offset)
+	}
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []*Page{}
+	for rows.Next() {
+		p, err := scanPage(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The extra row only shows whether there's more in this direction
+	more := len(pages) > q.Limit
+	if more {
+		pages = pages[:q.Limit]
+	}
+	if !forward {
+		for i, j := 0, len(pages)-1; i < j; i, j = i+1, j-1 {
+			pages[i], pages[j] = pages[j], pages[i]
+		}
+	}
+
+	list := &PageList{Pages: pages, Total: total}
+	if len(pages) == 0 {
+		return list, nil
+	}
+	hasNext, hasPrev := more, cursor != "" || q.Offset > 0
+	if !forward {
+		hasNext, hasPrev = true, more
+	}
+	if hasNext {
+		list.Next = encodeCursor(pages[len(pages)-1], q.Sort)
+	}
+	if hasPrev {
+		list.Prev = encodeCursor(pages[0], q.Sort)
+	}
+	return list, nil
+}
+
+func (s *PgStore) CreatePage(p *Page) (int, error) {
+	if p.DateCreated.IsZero() {
+		p.DateCreated = now()
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("INSERT INTO pages(slug, title, content, status, date_created, author) VALUES($1, $2, $3, $4, $5, $6) RETURNING id, version",
+		p.Slug, p.Title, p.Content, statusOf(p.Status), p.DateCreated, p.Author).Scan(&id, &p.Version)
+	if err != nil {
+		return 0, slugConflict(err)
+	}
+	_, err = tx.Exec("DELETE FROM slug_redirects WHERE kind = $1 AND slug = $2", KindPage, p.Slug)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *PgStore) UpdatePage(p *Page) error {
+	var version int
+	err := s.DB.QueryRow("UPDATE pages SET title = $1, content = $2, status = $3, version = version + 1 WHERE id = $4 AND ($5 = 0 OR version = $5) RETURNING version",
+		p.Title, p.Content, statusOf(p.Status), p.ID, p.Version).Scan(&version)
+	if err == sql.ErrNoRows {
+		// Either there's no such page, or it has moved on to another version
+		_, err = s.GetPage(p.ID)
+		if err == nil {
+			err = ErrConflict
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	p.Version = version
+	return nil
+}
+
+func (s *PgStore) TrashPage(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO pages_trash(`+pageColumns+`, deleted_at)
+		SELECT `+pageColumns+`, $2 FROM pages WHERE id = $1`, id, now())
+	if err != nil {
+		return err
+	}
+	err = checkAffected(res)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM pages WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PgStore) GetTrash() ([]*Page, error) {
+	rows, err := s.DB.Query("SELECT " + pageColumns + ", deleted_at FROM pages_trash ORDER BY deleted_at DESC, id DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -41,17 +260,673 @@ func GetPages() ([]*Page, error) {
 	pages := []*Page{}
 	for rows.Next() {
 		var p Page
-		err = rows.Scan(&p.ID, &p.Title, &p.Content)
+		err = rows.Scan(&p.ID, &p.Slug, &p.Title, &p.Content, &p.Status, &p.Version, &p.DateCreated, &p.Author, &p.DeletedAt)
 		if err != nil {
 			return nil, err
 		}
 		pages = append(pages, &p)
 	}
-	return pages, nil
+	return pages, rows.Err()
+}
+
+func (s *PgStore) RestorePage(id int, slug string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`INSERT INTO pages(id, slug, title, content, status, version, date_created, author)
+		SELECT id, $2, title, content, status, version, date_created, author FROM pages_trash WHERE id = $1`, id, slug)
+	if err != nil {
+		return slugConflict(err)
+	}
+	err = checkAffected(res)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM pages_trash WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM slug_redirects WHERE kind = $1 AND slug = $2", KindPage, slug)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PgStore) PurgePage(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM slug_redirects WHERE kind = $1 AND item_id = $2", KindPage, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM term_items WHERE kind = $1 AND item_id = $2", KindPage, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM translations WHERE kind = $1 AND item_id = $2", KindPage, id)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM pages_trash WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	err = checkAffected(res)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PgStore) SetPageSlug(id int, slug string) error {
+	return s.setSlug("pages", KindPage, id, slug)
+}
+
+// postColumns are the columns scanned by scanPost, in order
+const postColumns = "id, slug, title, content, status, date_created, publish_at, author, featured_image_id"
+
+// scanPost scans a row selected with postColumns.
+func scanPost(row interface{ Scan(...interface{}) error }) (*Post, error) {
+	var p Post
+	var publishAt pq.NullTime
+	var featured sql.NullInt64
+	err := row.Scan(&p.ID, &p.Slug, &p.Title, &p.Content, &p.Status, &p.DatePublished, &publishAt, &p.Author, &featured)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	p.PublishAt = publishAt.Time
+	p.FeaturedImageID = int(featured.Int64)
+	return &p, nil
+}
+
+// nullTime stores a zero time as NULL.
+func nullTime(t time.Time) pq.NullTime {
+	return pq.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// nullID stores a zero ID as NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+func (s *PgStore) GetPost(id int) (*Post, error) {
+	return scanPost(s.DB.QueryRow("SELECT "+postColumns+" FROM posts WHERE id = $1", id))
+}
+
+func (s *PgStore) GetPostBySlug(slug string) (*Post, error) {
+	return scanPost(s.DB.QueryRow("SELECT "+postColumns+" FROM posts WHERE slug = $1", slug))
+}
+
+func (s *PgStore) GetPosts(status string, limit int) ([]*Post, error) {
+	query := "SELECT " + postColumns + " FROM posts WHERE $1 = '' OR status = $1 ORDER BY date_created DESC, id DESC"
+	args := []interface{}{status}
+	if limit > 0 {
+		query += " LIMIT $2"
+		args = append(args, limit)
+	}
+	return s.queryPosts(query, args...)
+}
+
+// queryPosts runs a query selecting postColumns.
+func (s *PgStore) queryPosts(query string, args ...interface{}) ([]*Post, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []*Post{}
+	for rows.Next() {
+		p, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+	return posts, rows.Err()
+}
+
+func (s *PgStore) CreatePost(p *Post) (int, error) {
+	if p.DatePublished.IsZero() {
+		p.DatePublished = now()
+	}
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`INSERT INTO posts(slug, title, content, status, date_created, publish_at, author, featured_image_id)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		p.Slug, p.Title, p.Content, statusOf(p.Status), p.DatePublished, nullTime(p.PublishAt), p.Author, nullID(p.FeaturedImageID)).Scan(&id)
+	if err != nil {
+		return 0, slugConflict(err)
+	}
+	_, err = tx.Exec("DELETE FROM slug_redirects WHERE kind = $1 AND slug = $2", KindPost, p.Slug)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (s *PgStore) SetPostSlug(id int, slug string) error {
+	return s.setSlug("posts", KindPost, id, slug)
+}
+
+func (s *PgStore) UpdatePost(p *Post) error {
+	res, err := s.DB.Exec(`UPDATE posts SET title = $1, content = $2, status = $3, publish_at = $4,
+		date_created = coalesce($5, date_created), featured_image_id = $6 WHERE id = $7`,
+		p.Title, p.Content, statusOf(p.Status), nullTime(p.PublishAt), nullTime(p.DatePublished), nullID(p.FeaturedImageID), p.ID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *PgStore) PublishDuePosts(now time.Time) ([]*Post, error) {
+	return s.queryPosts(`UPDATE posts SET status = $1, date_created = publish_at
+		WHERE status = $2 AND publish_at <= $3
+		RETURNING `+postColumns, StatusPublished, StatusReview, now.UTC())
+}
+
+func (s *PgStore) DeletePost(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM comments WHERE post_id = $1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM slug_redirects WHERE kind = $1 AND item_id = $2", KindPost, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM term_items WHERE kind = $1 AND item_id = $2", KindPost, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM translations WHERE kind = $1 AND item_id = $2", KindPost, id)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM posts WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	err = checkAffected(res)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// commentColumns are the columns scanned by queryComments, in order
+const commentColumns = "id, post_id, COALESCE(parent_id, 0), author, content, status, ip, date_created"
+
+// queryComments runs a query selecting commentColumns.
+func (s *PgStore) queryComments(query string, args ...interface{}) ([]*Comment, error) {
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		var c Comment
+		err = rows.Scan(&c.ID, &c.PostID, &c.ParentID, &c.Author, &c.Comment, &c.Status, &c.IP, &c.DatePublished)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, &c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *PgStore) GetComments(postID int, status string) ([]*Comment, error) {
+	return s.queryComments("SELECT "+commentColumns+" FROM comments WHERE post_id = $1 AND ($2 = '' OR status = $2) ORDER BY date_created, id",
+		postID, status)
+}
+
+func (s *PgStore) GetCommentsByStatus(status string) ([]*Comment, error) {
+	return s.queryComments("SELECT "+commentColumns+" FROM comments WHERE $1 = '' OR status = $1 ORDER BY date_created, id", status)
+}
+
+func (s *PgStore) CreateComment(c *Comment) (int, error) {
+	if c.DatePublished.IsZero() {
+		c.DatePublished = now()
+	}
+	status := c.Status
+	if status == "" {
+		status = CommentApproved
+	}
+	var id int
+	// Top-level comments have a NULL parent, which the foreign key allows
+	parent := sql.NullInt64{Int64: int64(c.ParentID), Valid: c.ParentID != 0}
+	err := s.DB.QueryRow("INSERT INTO comments(post_id, parent_id, author, content, status, ip, date_created) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		c.PostID, parent, c.Author, c.Comment, status, c.IP, c.DatePublished).Scan(&id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+		return 0, ErrNotFound
+	}
+	return id, err
+}
+
+func (s *PgStore) SetCommentStatus(id int, status string) error {
+	res, err := s.DB.Exec("UPDATE comments SET status = $1 WHERE id = $2", status, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *PgStore) DeleteComment(id int) error {
+	res, err := s.DB.Exec("DELETE FROM comments WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *PgStore) GetSlugRedirect(kind, slug string) (int, error) {
+	var id int
+	err := s.DB.QueryRow("SELECT item_id FROM slug_redirects WHERE kind = $1 AND slug = $2", kind, slug).Scan(&id)
+	return id, notFound(err)
+}
+
+// searchQuery searches pages and posts together. ts_headline marks matches
+// with control characters rather than tags, so the snippet can be escaped
+// before the marks are turned into HTML.
+const searchQuery = `
+SELECT kind, id, slug, title,
+       ts_headline('english', content, query, 'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxWords=30, MinWords=15'),
+       ts_rank(search, query) AS rank
+FROM (
+  SELECT 'page' AS kind, id, slug, title, content, status, search FROM pages
+  UNION ALL
+  SELECT 'post' AS kind, id, slug, title, content, status, search FROM posts
+) AS docs, plainto_tsquery('english', $1) AS query
+WHERE search @@ query AND ($2 = '' OR status = $2)
+ORDER BY rank DESC, id
+LIMIT $3`
+
+func (s *PgStore) Search(query, status string, limit int) ([]*SearchResult, error) {
+	rows, err := s.DB.Query(searchQuery, query, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		var headline string
+		err = rows.Scan(&r.Kind, &r.ID, &r.Slug, &r.Title, &headline, &r.Rank)
+		if err != nil {
+			return nil, err
+		}
+		r.Snippet = pgHighlight(headline)
+		results = append(results, &r)
+	}
+	return results, rows.Err()
+}
+
+// pgHighlight escapes a ts_headline result and turns its marks into <mark>.
+func pgHighlight(headline string) template.HTML {
+	escaped := html.EscapeString(headline)
+	escaped = strings.Replace(escaped, "\x02", "<mark>", -1)
+	escaped = strings.Replace(escaped, "\x03", "</mark>", -1)
+	return template.HTML(escaped)
+}
+
+func (s *PgStore) CreateRevision(r *Revision) (int, error) {
+	if r.DateCreated.IsZero() {
+		r.DateCreated = now()
+	}
+	var id int
+	err := s.DB.QueryRow(`INSERT INTO revisions(kind, item_id, number, title, content, author, note, date_created)
+		VALUES($1, $2, (SELECT coalesce(max(number), 0) + 1 FROM revisions WHERE kind = $1 AND item_id = $2), $3, $4, $5, $6, $7)
+		RETURNING id`, r.Kind, r.ItemID, r.Title, r.Content, r.Author, r.Note, r.DateCreated).Scan(&id)
+	return id, err
+}
+
+// revisionColumns are the columns scanned by scanRevision, in order
+const revisionColumns = "id, kind, item_id, number, title, content, author, note, date_created"
+
+// scanRevision scans a row selected with revisionColumns.
+func scanRevision(row interface{ Scan(...interface{}) error }) (*Revision, error) {
+	var r Revision
+	err := row.Scan(&r.ID, &r.Kind, &r.ItemID, &r.Number, &r.Title, &r.Content, &r.Author, &r.Note, &r.DateCreated)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &r, nil
+}
+
+func (s *PgStore) GetRevisions(kind string, itemID int) ([]*Revision, error) {
+	rows, err := s.DB.Query("SELECT "+revisionColumns+" FROM revisions WHERE kind = $1 AND item_id = $2 ORDER BY number DESC", kind, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revs := []*Revision{}
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revs = append(revs, r)
+	}
+	return revs, rows.Err()
+}
+
+func (s *PgStore) GetRevision(kind string, itemID, number int) (*Revision, error) {
+	return scanRevision(s.DB.QueryRow("SELECT "+revisionColumns+" FROM revisions WHERE kind = $1 AND item_id = $2 AND number = $3",
+		kind, itemID, number))
+}
+
+// termColumns are the columns scanned by scanTerm, in order
+const termColumns = "id, taxonomy, slug, name, parent_id"
+
+// scanTerm scans a row selected with termColumns, followed by any extra
+// destinations.
+func scanTerm(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*Term, error) {
+	var t Term
+	dest := append([]interface{}{&t.ID, &t.Taxonomy, &t.Slug, &t.Name, &t.ParentID}, extra...)
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &t, nil
+}
+
+func (s *PgStore) GetTerms(taxonomy string) ([]*Term, error) {
+	rows, err := s.DB.Query("SELECT "+termColumns+" FROM terms WHERE taxonomy = $1 ORDER BY id", taxonomy)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := []*Term{}
+	for rows.Next() {
+		t, err := scanTerm(rows)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+	}
+	return terms, rows.Err()
+}
+
+func (s *PgStore) CreateTerm(t *Term) (int, error) {
+	var id int
+	err := s.DB.QueryRow("INSERT INTO terms(taxonomy, slug, name, parent_id) VALUES($1, $2, $3, $4) RETURNING id",
+		t.Taxonomy, t.Slug, t.Name, t.ParentID).Scan(&id)
+	return id, slugConflict(err)
+}
+
+func (s *PgStore) SetItemTerms(taxonomy, kind string, itemID int, termIDs []int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM term_items WHERE kind = $1 AND item_id = $2
+		AND term_id IN (SELECT id FROM terms WHERE taxonomy = $3)`, kind, itemID, taxonomy)
+	if err != nil {
+		return err
+	}
+	for _, id := range termIDs {
+		_, err = tx.Exec("INSERT INTO term_items(term_id, kind, item_id) VALUES($1, $2, $3)", id, kind, itemID)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PgStore) GetItemTerms(kind string, itemIDs []int) (map[int][]*Term, error) {
+	rows, err := s.DB.Query(`SELECT `+termColumns+`, ti.item_id FROM terms JOIN term_items ti ON ti.term_id = terms.id
+		WHERE ti.kind = $1 AND ti.item_id = ANY($2) ORDER BY lower(name), id`, kind, pq.Array(itemIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	terms := map[int][]*Term{}
+	for rows.Next() {
+		var itemID int
+		t, err := scanTerm(rows, &itemID)
+		if err != nil {
+			return nil, err
+		}
+		terms[itemID] = append(terms[itemID], t)
+	}
+	return terms, rows.Err()
+}
+
+func (s *PgStore) GetTermContent(termIDs []int, status string) ([]*Page, []*Post, error) {
+	const tagged = "id IN (SELECT item_id FROM term_items WHERE kind = $1 AND term_id = ANY($2)) AND ($3 = '' OR status = $3)"
+	ids := pq.Array(termIDs)
+	pages, err := s.queryPages("SELECT "+pageColumns+" FROM pages WHERE "+tagged+" ORDER BY lower(title), id",
+		KindPage, ids, status)
+	if err != nil {
+		return nil, nil, err
+	}
+	posts, err := s.queryPosts("SELECT "+postColumns+" FROM posts WHERE "+tagged+" ORDER BY date_created DESC, id DESC",
+		KindPost, ids, status)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pages, posts, nil
+}
+
+func (s *PgStore) CountTermItems(taxonomy, status string) (map[int]int, error) {
+	rows, err := s.DB.Query(`SELECT ti.term_id, count(*) FROM term_items ti
+		JOIN terms t ON t.id = ti.term_id
+		LEFT JOIN pages ON ti.kind = 'page' AND pages.id = ti.item_id
+		LEFT JOIN posts ON ti.kind = 'post' AND posts.id = ti.item_id
+		WHERE t.taxonomy = $1 AND coalesce(pages.status, posts.status) IS NOT NULL
+		  AND ($2 = '' OR coalesce(pages.status, posts.status) = $2)
+		GROUP BY ti.term_id`, taxonomy, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var id, n int
+		err = rows.Scan(&id, &n)
+		if err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
 }
 
-func CreatePage(p *Page) (int, error) {
+// setSlug renames a page or post and records its old slug as a redirect, all
+// in one transaction. table is never user input.
+func (s *PgStore) setSlug(table, kind string, id int, slug string) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old string
+	err = tx.QueryRow("SELECT slug FROM "+table+" WHERE id = $1 FOR UPDATE", id).Scan(&old)
+	if err != nil {
+		return notFound(err)
+	}
+	_, err = tx.Exec("UPDATE "+table+" SET slug = $1 WHERE id = $2", slug, id)
+	if err != nil {
+		return slugConflict(err)
+	}
+	_, err = tx.Exec("DELETE FROM slug_redirects WHERE kind = $1 AND slug = $2", kind, slug)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO slug_redirects(kind, slug, item_id) VALUES($1, $2, $3)
+		ON CONFLICT (kind, slug) DO UPDATE SET item_id = EXCLUDED.item_id`, kind, old, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PgStore) CreateImage(img *Image) (int, error) {
+	if img.DateCreated.IsZero() {
+		img.DateCreated = now()
+	}
 	var id int
-	err := store.DB.QueryRow("INSERT INTO pages(title, content) VALUES($1, $2) RETURNING id", p.Title, p.Content).Scan(&id)
+	err := s.DB.QueryRow(`INSERT INTO images(filename, mime_type, width, height, size, alt, uploader, date_created)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		img.Filename, img.MIMEType, img.Width, img.Height, img.Size, img.Alt, img.Uploader, img.DateCreated).Scan(&id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return 0, ErrFilenameTaken
+	}
 	return id, err
 }
+
+// imageColumns are the columns scanned by scanImage, in order
+const imageColumns = "id, filename, mime_type, width, height, size, alt, uploader, date_created"
+
+// scanImage scans a row selected with imageColumns.
+func scanImage(row interface{ Scan(...interface{}) error }) (*Image, error) {
+	var img Image
+	err := row.Scan(&img.ID, &img.Filename, &img.MIMEType, &img.Width, &img.Height, &img.Size, &img.Alt, &img.Uploader, &img.DateCreated)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &img, nil
+}
+
+func (s *PgStore) GetImage(id int) (*Image, error) {
+	return scanImage(s.DB.QueryRow("SELECT "+imageColumns+" FROM images WHERE id = $1", id))
+}
+
+func (s *PgStore) GetImageByName(filename string) (*Image, error) {
+	return scanImage(s.DB.QueryRow("SELECT "+imageColumns+" FROM images WHERE filename = $1", filename))
+}
+
+func (s *PgStore) GetImages() ([]*Image, error) {
+	rows, err := s.DB.Query("SELECT " + imageColumns + " FROM images ORDER BY date_created DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []*Image{}
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, rows.Err()
+}
+
+func (s *PgStore) UpdateImage(img *Image) error {
+	res, err := s.DB.Exec("UPDATE images SET alt = $1 WHERE id = $2", img.Alt, img.ID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+// DeleteImage relies on the foreign key to clear the featured images.
+func (s *PgStore) DeleteImage(id int) error {
+	res, err := s.DB.Exec("DELETE FROM images WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *PgStore) SetTranslation(t *Translation) (int, error) {
+	var id int
+	err := s.DB.QueryRow(`INSERT INTO translations(kind, item_id, lang, title, content, date_updated)
+		VALUES($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (kind, item_id, lang) DO UPDATE SET title = EXCLUDED.title, content = EXCLUDED.content, date_updated = EXCLUDED.date_updated
+		RETURNING id`,
+		t.Kind, t.ItemID, t.Lang, t.Title, t.Content, t.DateUpdated).Scan(&id)
+	return id, err
+}
+
+func (s *PgStore) GetTranslations(kind string, itemIDs []int) (map[int][]*Translation, error) {
+	rows, err := s.DB.Query(`SELECT id, kind, item_id, lang, title, content, date_updated FROM translations
+		WHERE kind = $1 AND item_id = ANY($2) ORDER BY lang`, kind, pq.Array(itemIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := map[int][]*Translation{}
+	for rows.Next() {
+		var t Translation
+		err := rows.Scan(&t.ID, &t.Kind, &t.ItemID, &t.Lang, &t.Title, &t.Content, &t.DateUpdated)
+		if err != nil {
+			return nil, err
+		}
+		translations[t.ItemID] = append(translations[t.ItemID], &t)
+	}
+	return translations, rows.Err()
+}
+
+func (s *PgStore) DeleteTranslation(kind string, itemID int, lang string) error {
+	res, err := s.DB.Exec("DELETE FROM translations WHERE kind = $1 AND item_id = $2 AND lang = $3", kind, itemID, lang)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+// slugConflict maps a unique constraint violation, which for pages and posts
+// can only be the slug, onto ErrSlugTaken.
+func slugConflict(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrSlugTaken
+	}
+	return err
+}
+
+// checkAffected turns an UPDATE or DELETE that matched nothing into
+// ErrNotFound, so callers can treat it like a failed lookup.
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// notFound maps sql.ErrNoRows onto ErrNotFound and leaves other errors alone.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
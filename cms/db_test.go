@@ -1,36 +1,150 @@
 package cms
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"testing"
 )
 
-var p *Page
-
-func Test_CreatePage(t *testing.T) {
-	p = &Page{
-		Title:   "test",
-		Content: "test",
+// testStores returns a fresh instance of every backend. Postgres is only
+// included when CMS_TEST_DSN points at a database with the cms schema.
+func testStores(t *testing.T) map[string]Store {
+	dir, err := ioutil.TempDir("", "cms")
+	if err != nil {
+		t.Fatal(err)
 	}
-	id, err := CreatePage(p)
+	bolt, err := NewBoltStore(filepath.Join(dir, "cms.db"))
 	if err != nil {
-		t.Errorf("Failed to create page: %s\n", err.Error())
+		t.Fatal(err)
 	}
-	p.ID = id
-}
+	t.Cleanup(func() {
+		bolt.Close()
+		os.RemoveAll(dir)
+	})
 
-func Test_GetPage(t *testing.T) {
-	page, err := GetPage(strconv.Itoa(p.ID))
-	if err != nil {
-		t.Errorf("Failed to get page: %s\n", err.Error())
+	stores := map[string]Store{
+		"memory": NewMemStore(),
+		"bolt":   bolt,
 	}
-	if page.ID != p.ID {
-		t.Errorf("Page IDs do not match: %d\n vs %d\n", page.ID, p.ID)
+	if dsn := os.Getenv("CMS_TEST_DSN"); dsn != "" {
+		pg, err := NewPgStore(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { pg.Close() })
+		stores["postgres"] = pg
 	}
-	// DeepEqual will compare each fields in the struct
-	if reflect.DeepEqual(page, p) != true {
-		// %+v will display all the fields and values in the struct
-		t.Errorf("Pages do not match: %+v\n vs %+v\n", page, p)
+	return stores
+}
+
+// forEachStore runs fn once per backend, with the package level functions
+// pointed at that backend.
+func forEachStore(t *testing.T, fn func(t *testing.T)) {
+	old := DefaultSite.store
+	defer SetStore(old)
+
+	for name, s := range testStores(t) {
+		SetStore(s)
+		t.Run(name, fn)
 	}
 }
+
+func Test_Pages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		p := &Page{
+			Title:   "test",
+			Content: "test",
+		}
+		id, err := CreatePage(p)
+		if err != nil {
+			t.Fatalf("Failed to create page: %s\n", err.Error())
+		}
+		p.ID = id
+
+		page, err := GetPage(strconv.Itoa(p.ID))
+		if err != nil {
+			t.Fatalf("Failed to get page: %s\n", err.Error())
+		}
+		if page.ID != p.ID {
+			t.Errorf("Page IDs do not match: %d\n vs %d\n", page.ID, p.ID)
+		}
+		// DeepEqual will compare each fields in the struct
+		if reflect.DeepEqual(page, p) != true {
+			// %+v will display all the fields and values in the struct
+			t.Errorf("Pages do not match: %+v\n vs %+v\n", page, p)
+		}
+
+		_, err = GetPage("not-a-number")
+		if err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound, got %v\n", err)
+		}
+	})
+}
+
+func Test_Posts(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		post := &Post{
+			Title:   "test post",
+			Content: "test post content",
+		}
+		id, err := CreatePost(post)
+		if err != nil {
+			t.Fatalf("Failed to create post: %s\n", err.Error())
+		}
+		post.ID = id
+
+		got, err := GetPost(strconv.Itoa(post.ID))
+		if err != nil {
+			t.Fatalf("Failed to get post: %s\n", err.Error())
+		}
+		if got.ID != post.ID || got.Title != post.Title || got.Content != post.Content {
+			t.Errorf("Posts do not match: %+v\n vs %+v\n", got, post)
+		}
+
+		post.Title = "updated"
+		err = UpdatePost(post)
+		if err != nil {
+			t.Fatalf("Failed to update post: %s\n", err.Error())
+		}
+		posts, err := GetPosts("", 1)
+		if err != nil {
+			t.Fatalf("Failed to get posts: %s\n", err.Error())
+		}
+		if len(posts) != 1 || posts[0].Title != "updated" {
+			t.Errorf("Unexpected posts: %+v\n", posts)
+		}
+
+		c := &Comment{
+			PostID:  post.ID,
+			Author:  "tester",
+			Comment: "test comment",
+		}
+		cid, err := CreateComment(c)
+		if err != nil {
+			t.Fatalf("Failed to create comment: %s\n", err.Error())
+		}
+		comments, err := GetComments(post.ID)
+		if err != nil {
+			t.Fatalf("Failed to get comments: %s\n", err.Error())
+		}
+		if len(comments) != 1 || comments[0].ID != cid || comments[0].Comment != c.Comment {
+			t.Errorf("Unexpected comments: %+v\n", comments)
+		}
+
+		err = DeletePost(post.ID)
+		if err != nil {
+			t.Fatalf("Failed to delete post: %s\n", err.Error())
+		}
+		_, err = GetPost(strconv.Itoa(post.ID))
+		if err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound after delete, got %v\n", err)
+		}
+		comments, err = GetComments(post.ID)
+		if err != nil || len(comments) != 0 {
+			t.Errorf("Comments survived their post: %+v, %v\n", comments, err)
+		}
+	})
+}
@@ -0,0 +1,315 @@
+package cms
+
+import (
+	"html/template"
+	"io"
+	"time"
+)
+
+// The functions in this file work on the DefaultSite, for programs that only
+// serve one site. Each is documented on the Site method of the same name.
+
+// Markdown is DefaultSite.Markdown.
+func Markdown(src string) template.HTML {
+	return DefaultSite.Markdown(src)
+}
+
+// PurgeCache is DefaultSite.PurgeCache.
+func PurgeCache() {
+	DefaultSite.PurgeCache()
+}
+
+// SubmitComment is DefaultSite.SubmitComment.
+func SubmitComment(c *Comment, honeypot string) (int, error) {
+	return DefaultSite.SubmitComment(c, honeypot)
+}
+
+// GetCommentQueue is DefaultSite.GetCommentQueue.
+func GetCommentQueue(status string) ([]*Comment, error) {
+	return DefaultSite.GetCommentQueue(status)
+}
+
+// SetCommentStatus is DefaultSite.SetCommentStatus.
+func SetCommentStatus(id int, status string) error {
+	return DefaultSite.SetCommentStatus(id, status)
+}
+
+// Export is DefaultSite.Export.
+func Export(dir, imageDir string) (*ExportStats, error) {
+	return DefaultSite.Export(dir, imageDir)
+}
+
+// Import is DefaultSite.Import.
+func Import(items []*ImportItem, uploads string, dryRun bool) (*ImportReport, error) {
+	return DefaultSite.Import(items, uploads, dryRun)
+}
+
+// SaveImage is DefaultSite.SaveImage.
+func SaveImage(filename string, r io.Reader, alt, uploader string) (*Image, error) {
+	return DefaultSite.SaveImage(filename, r, alt, uploader)
+}
+
+// GetImages is DefaultSite.GetImages.
+func GetImages() ([]*Image, error) {
+	return DefaultSite.GetImages()
+}
+
+// GetImage is DefaultSite.GetImage.
+func GetImage(id int) (*Image, error) {
+	return DefaultSite.GetImage(id)
+}
+
+// SetImageAlt is DefaultSite.SetImageAlt.
+func SetImageAlt(id int, alt string) error {
+	return DefaultSite.SetImageAlt(id, alt)
+}
+
+// DeleteImage is DefaultSite.DeleteImage.
+func DeleteImage(id int) error {
+	return DefaultSite.DeleteImage(id)
+}
+
+// OrphanedImages is DefaultSite.OrphanedImages.
+func OrphanedImages() ([]*Image, error) {
+	return DefaultSite.OrphanedImages()
+}
+
+// LoadFeaturedImages is DefaultSite.LoadFeaturedImages.
+func LoadFeaturedImages(posts ...*Post) error {
+	return DefaultSite.LoadFeaturedImages(posts...)
+}
+
+// ListPages is DefaultSite.ListPages.
+func ListPages(q PageQuery) (*PageList, error) {
+	return DefaultSite.ListPages(q)
+}
+
+// GetRevisions is DefaultSite.GetRevisions.
+func GetRevisions(kind string, itemID int) ([]*Revision, error) {
+	return DefaultSite.GetRevisions(kind, itemID)
+}
+
+// GetRevision is DefaultSite.GetRevision.
+func GetRevision(kind string, itemID, number int) (*Revision, error) {
+	return DefaultSite.GetRevision(kind, itemID, number)
+}
+
+// Rollback is DefaultSite.Rollback.
+func Rollback(kind string, itemID, number int, user string) error {
+	return DefaultSite.Rollback(kind, itemID, number, user)
+}
+
+// Search is DefaultSite.Search.
+func Search(query, status string) ([]*SearchResult, error) {
+	return DefaultSite.Search(query, status)
+}
+
+// ResolvePage is DefaultSite.ResolvePage.
+func ResolvePage(ref string) (p *Page, canonical bool, err error) {
+	return DefaultSite.ResolvePage(ref)
+}
+
+// ResolvePost is DefaultSite.ResolvePost.
+func ResolvePost(ref string) (p *Post, canonical bool, err error) {
+	return DefaultSite.ResolvePost(ref)
+}
+
+// SetPageSlug is DefaultSite.SetPageSlug.
+func SetPageSlug(id int, slug string) error {
+	return DefaultSite.SetPageSlug(id, slug)
+}
+
+// SetPostSlug is DefaultSite.SetPostSlug.
+func SetPostSlug(id int, slug string) error {
+	return DefaultSite.SetPostSlug(id, slug)
+}
+
+// GetPage is DefaultSite.GetPage.
+func GetPage(id string) (*Page, error) {
+	return DefaultSite.GetPage(id)
+}
+
+// GetPages is DefaultSite.GetPages.
+func GetPages() ([]*Page, error) {
+	return DefaultSite.GetPages()
+}
+
+// CreatePage is DefaultSite.CreatePage.
+func CreatePage(p *Page) (int, error) {
+	return DefaultSite.CreatePage(p)
+}
+
+// UpdatePage is DefaultSite.UpdatePage.
+func UpdatePage(p *Page) error {
+	return DefaultSite.UpdatePage(p)
+}
+
+// GetPost is DefaultSite.GetPost.
+func GetPost(id string) (*Post, error) {
+	return DefaultSite.GetPost(id)
+}
+
+// GetPosts is DefaultSite.GetPosts.
+func GetPosts(status string, limit int) ([]*Post, error) {
+	return DefaultSite.GetPosts(status, limit)
+}
+
+// CreatePost is DefaultSite.CreatePost.
+func CreatePost(p *Post) (int, error) {
+	return DefaultSite.CreatePost(p)
+}
+
+// UpdatePost is DefaultSite.UpdatePost.
+func UpdatePost(p *Post) error {
+	return DefaultSite.UpdatePost(p)
+}
+
+// DeletePost is DefaultSite.DeletePost.
+func DeletePost(id int) error {
+	return DefaultSite.DeletePost(id)
+}
+
+// GetComments is DefaultSite.GetComments.
+func GetComments(postID int) ([]*Comment, error) {
+	return DefaultSite.GetComments(postID)
+}
+
+// CreateComment is DefaultSite.CreateComment.
+func CreateComment(c *Comment) (int, error) {
+	return DefaultSite.CreateComment(c)
+}
+
+// DeleteComment is DefaultSite.DeleteComment.
+func DeleteComment(id int) error {
+	return DefaultSite.DeleteComment(id)
+}
+
+// GetTags is DefaultSite.GetTags.
+func GetTags() ([]*Term, error) {
+	return DefaultSite.GetTags()
+}
+
+// GetCategories is DefaultSite.GetCategories.
+func GetCategories() ([]*Term, error) {
+	return DefaultSite.GetCategories()
+}
+
+// GetTag is DefaultSite.GetTag.
+func GetTag(slug string) (*Term, error) {
+	return DefaultSite.GetTag(slug)
+}
+
+// GetCategory is DefaultSite.GetCategory.
+func GetCategory(path string) (*Term, error) {
+	return DefaultSite.GetCategory(path)
+}
+
+// TagCloud is DefaultSite.TagCloud.
+func TagCloud(status string) ([]*Term, error) {
+	return DefaultSite.TagCloud(status)
+}
+
+// GetTermContent is DefaultSite.GetTermContent.
+func GetTermContent(t *Term, status string) ([]*Page, []*Post, error) {
+	return DefaultSite.GetTermContent(t, status)
+}
+
+// SetTags is DefaultSite.SetTags.
+func SetTags(kind string, id int, names []string) error {
+	return DefaultSite.SetTags(kind, id, names)
+}
+
+// SetCategories is DefaultSite.SetCategories.
+func SetCategories(kind string, id int, paths []string) error {
+	return DefaultSite.SetCategories(kind, id, paths)
+}
+
+// SetTerms is DefaultSite.SetTerms.
+func SetTerms(kind string, id int, tags, categories []string) error {
+	return DefaultSite.SetTerms(kind, id, tags, categories)
+}
+
+// LoadPageTerms is DefaultSite.LoadPageTerms.
+func LoadPageTerms(pages ...*Page) error {
+	return DefaultSite.LoadPageTerms(pages...)
+}
+
+// LoadPostTerms is DefaultSite.LoadPostTerms.
+func LoadPostTerms(posts ...*Post) error {
+	return DefaultSite.LoadPostTerms(posts...)
+}
+
+// LoadTemplates is DefaultSite.LoadTemplates.
+func LoadTemplates() error {
+	return DefaultSite.LoadTemplates()
+}
+
+// Templates is DefaultSite.Templates.
+func Templates() (*template.Template, error) {
+	return DefaultSite.Templates()
+}
+
+// DeletePage is DefaultSite.DeletePage.
+func DeletePage(id int) error {
+	return DefaultSite.DeletePage(id)
+}
+
+// GetTrash is DefaultSite.GetTrash.
+func GetTrash() ([]*Page, error) {
+	return DefaultSite.GetTrash()
+}
+
+// RestorePage is DefaultSite.RestorePage.
+func RestorePage(id int) (*Page, error) {
+	return DefaultSite.RestorePage(id)
+}
+
+// PurgePage is DefaultSite.PurgePage.
+func PurgePage(id int) error {
+	return DefaultSite.PurgePage(id)
+}
+
+// SetPageStatus is DefaultSite.SetPageStatus.
+func SetPageStatus(id int, status string) error {
+	return DefaultSite.SetPageStatus(id, status)
+}
+
+// SetPostStatus is DefaultSite.SetPostStatus.
+func SetPostStatus(id int, status string, publishAt time.Time, user string) error {
+	return DefaultSite.SetPostStatus(id, status, publishAt, user)
+}
+
+// PublishDuePosts is DefaultSite.PublishDuePosts.
+func PublishDuePosts() ([]*Post, error) {
+	return DefaultSite.PublishDuePosts()
+}
+
+// TranslatePages is DefaultSite.TranslatePages.
+func TranslatePages(lang string, pages ...*Page) error {
+	return DefaultSite.TranslatePages(lang, pages...)
+}
+
+// TranslatePosts is DefaultSite.TranslatePosts.
+func TranslatePosts(lang string, posts ...*Post) error {
+	return DefaultSite.TranslatePosts(lang, posts...)
+}
+
+// GetTranslations is DefaultSite.GetTranslations.
+func GetTranslations(kind string, itemID int) ([]*Translation, error) {
+	return DefaultSite.GetTranslations(kind, itemID)
+}
+
+// SetTranslation is DefaultSite.SetTranslation.
+func SetTranslation(t *Translation) error {
+	return DefaultSite.SetTranslation(t)
+}
+
+// GetTranslationStatus is DefaultSite.GetTranslationStatus.
+func GetTranslationStatus() ([]*TranslationStatus, error) {
+	return DefaultSite.GetTranslationStatus()
+}
+
+// PreviewURL is DefaultSite.PreviewURL.
+func PreviewURL(kind string, id int) string {
+	return DefaultSite.PreviewURL(kind, id)
+}
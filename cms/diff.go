@@ -0,0 +1,86 @@
+package cms
+
+import "strings"
+
+// The kinds of line in a diff.
+const (
+	DiffSame   = " "
+	DiffAdd    = "+"
+	DiffDelete = "-"
+)
+
+// DiffLine is a single line of a line-level diff.
+type DiffLine struct {
+	Op   string
+	Text string
+}
+
+// Class is the CSS class used to colour the line.
+func (l DiffLine) Class() string {
+	switch l.Op {
+	case DiffAdd:
+		return "add"
+	case DiffDelete:
+		return "del"
+	}
+	return "same"
+}
+
+// Diff compares two texts line by line, using their longest common
+// subsequence, and returns every line of both marked as kept, added or
+// deleted.
+func Diff(a, b string) []DiffLine {
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []DiffLine{}
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, DiffLine{DiffSame, x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, DiffLine{DiffDelete, x[i]})
+			i++
+		default:
+			lines = append(lines, DiffLine{DiffAdd, y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		lines = append(lines, DiffLine{DiffDelete, x[i]})
+	}
+	for ; j < len(y); j++ {
+		lines = append(lines, DiffLine{DiffAdd, y[j]})
+	}
+	return lines
+}
+
+// splitLines splits text into lines, ignoring the difference between Unix
+// and Windows line endings. Empty text has no lines.
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,316 @@
+package cms
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// exportKey marks the requests Export renders the site with
+type exportKey struct{}
+
+// Exporting reports whether the request is rendering the static site, so
+// handlers can leave out what only works on a live server, like forms.
+func Exporting(r *http.Request) bool {
+	exporting, _ := r.Context().Value(exportKey{}).(bool)
+	return exporting
+}
+
+// setReplyURLs links each comment in the thread to the reply form on its
+// post, unless the site is being exported and can't take comments.
+func setReplyURLs(r *http.Request, slug string, thread []*Comment) {
+	if Exporting(r) {
+		return
+	}
+	for _, c := range thread {
+		c.ReplyURL = "/post/" + slug + "?reply=" + strconv.Itoa(c.ID) + "#comments"
+		setReplyURLs(r, slug, c.Replies)
+	}
+}
+
+// ExportStats counts the files an export wrote, and those it left alone
+// because they hadn't changed.
+type ExportStats struct {
+	Written   int
+	Unchanged int
+}
+
+// exportPrefixes are the parts of the site a static export crawls, besides
+// the home page and its feeds. Search, history and the admin pages need the
+// live server, so links to them are left as they are.
+var exportPrefixes = []string{"/page/", "/post/", "/tag/", "/category/", "/theme/", "/lang/"}
+
+// linkAttr finds the site-relative links in rendered HTML
+var linkAttr = regexp.MustCompile(`(href|src)="(/[^"]*)"`)
+
+// Export renders the published site into dir as static files, through the
+// same handlers and templates that serve it, as an anonymous reader would see
+// it. Every page, post, listing, tag, category and feed is written, along
+// with the theme's assets the pages use. Links between them are made relative
+// so the site works from any directory, and the uploaded images in imageDir
+// are copied in. Files that haven't changed since the last export aren't
+// rewritten.
+func (site *Site) Export(dir, imageDir string) (*ExportStats, error) {
+	e := &exporter{
+		dir:       dir,
+		handler:   site.exportHandler(),
+		pages:     map[string][]byte{},
+		redirects: map[string]string{},
+	}
+
+	seeds := []string{"/", "/feed.rss", "/feed.atom", "/page/", "/tag/", "/category/"}
+	// Only the latest posts are linked from the home page, so every post is
+	// crawled from the start
+	posts, err := site.GetPosts(StatusPublished, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		seeds = append(seeds, "/post/"+p.Slug)
+	}
+
+	err = e.crawl(seeds)
+	if err != nil {
+		return nil, err
+	}
+	images, err := e.copyImages(imageDir)
+	if err != nil {
+		return nil, err
+	}
+	for link, body := range e.pages {
+		file := exportFile(link)
+		if strings.HasSuffix(file, ".html") {
+			body = e.rewriteLinks(file, body, images)
+		}
+		err = e.write(file, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &e.stats, nil
+}
+
+// exporter holds what's been crawled so far
+type exporter struct {
+	dir     string
+	handler http.Handler
+	stats   ExportStats
+	// pages are the bodies of everything rendered, by link
+	pages map[string][]byte
+	// redirects map old links to where they redirect
+	redirects map[string]string
+}
+
+// exportHandler routes the parts of the site that are exported, serving them
+// from the site whatever host they're asked for at.
+func (site *Site) exportHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		ServeIndex(w, r)
+	})
+	mux.HandleFunc("/feed.rss", ServeFeed)
+	mux.HandleFunc("/feed.atom", ServeFeed)
+	mux.HandleFunc("/page/", ServePage)
+	mux.HandleFunc("/post/", ServePost)
+	mux.HandleFunc("/tag/", ServeTag)
+	mux.HandleFunc("/category/", ServeCategory)
+	mux.HandleFunc("/theme/", ServeTheme)
+	// Translations are found through the hreflang links of what they
+	// translate
+	mux.Handle("/lang/", LangPrefix(mux))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, withSite(r, site))
+	})
+}
+
+// exportable reports whether a link is to a part of the site that's
+// exported. Of the links with a query, only pages of the page listing are.
+func exportable(u *url.URL) bool {
+	if u.RawQuery != "" {
+		return u.Path == "/page/"
+	}
+	if u.Path == "/" || u.Path == "/feed.rss" || u.Path == "/feed.atom" {
+		return true
+	}
+	for _, prefix := range exportPrefixes {
+		if strings.HasPrefix(u.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// crawl renders the seeds and everything they link to.
+func (e *exporter) crawl(seeds []string) error {
+	queue := seeds
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		link := queue[0]
+		queue = queue[1:]
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+
+		r := httptest.NewRequest("GET", link, nil)
+		r = r.WithContext(context.WithValue(r.Context(), exportKey{}, true))
+		w := httptest.NewRecorder()
+		e.handler.ServeHTTP(w, r)
+
+		switch w.Code {
+		case http.StatusOK:
+			body := w.Body.Bytes()
+			e.pages[link] = body
+			if !strings.HasSuffix(exportFile(link), ".html") {
+				continue
+			}
+			for _, m := range linkAttr.FindAllSubmatch(body, -1) {
+				u, err := url.Parse(html.UnescapeString(string(m[2])))
+				if err == nil && exportable(u) {
+					u.Fragment = ""
+					queue = append(queue, u.String())
+				}
+			}
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			target := w.Header().Get("Location")
+			e.redirects[link] = target
+			queue = append(queue, target)
+		case http.StatusNotFound:
+			// A link to something unpublished, which isn't exported
+		default:
+			return fmt.Errorf("cms: exporting %s: %d %s", link, w.Code, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	return nil
+}
+
+// exportFile is the file a link is exported to, relative to the export
+// directory. Paths become directories with an index.html, so that file
+// servers map them back to the same URL, and pages of the listing get a name
+// from their query.
+func exportFile(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	p := strings.Trim(u.Path, "/")
+	if u.RawQuery != "" {
+		sum := sha1.Sum([]byte(u.RawQuery))
+		return path.Join(p, "index-"+hex.EncodeToString(sum[:6])+".html")
+	}
+	if path.Ext(p) != "" {
+		return p
+	}
+	return path.Join(p, "index.html")
+}
+
+// rewriteLinks makes the links in a page that was exported from file point
+// at the exported files, relative to it. Links to anything that wasn't
+// exported are left alone.
+func (e *exporter) rewriteLinks(file string, body []byte, images map[string]bool) []byte {
+	return linkAttr.ReplaceAllFunc(body, func(m []byte) []byte {
+		parts := linkAttr.FindSubmatch(m)
+		u, err := url.Parse(html.UnescapeString(string(parts[2])))
+		if err != nil {
+			return m
+		}
+		fragment := u.Fragment
+		u.Fragment = ""
+
+		target := ""
+		if strings.HasPrefix(u.Path, "/image/") && images[u.Path] {
+			target = strings.TrimPrefix(u.Path, "/")
+		} else {
+			link := u.String()
+			// Follow redirects to where they end up, giving up on loops
+			for i := 0; i < 10 && e.redirects[link] != ""; i++ {
+				link = e.redirects[link]
+			}
+			if _, ok := e.pages[link]; ok {
+				target = exportFile(link)
+			}
+		}
+		if target == "" {
+			return m
+		}
+
+		rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(file)), filepath.FromSlash(target))
+		if err != nil {
+			return m
+		}
+		rel = filepath.ToSlash(rel)
+		if fragment != "" {
+			rel += "#" + fragment
+		}
+		return []byte(string(parts[1]) + `="` + html.EscapeString(rel) + `"`)
+	})
+}
+
+// copyImages copies the uploaded images into image/ in the export, returning
+// the links they're served at. A missing image directory has nothing to copy.
+func (e *exporter) copyImages(imageDir string) (map[string]bool, error) {
+	images := map[string]bool{}
+	if imageDir == "" {
+		return images, nil
+	}
+	files, err := ioutil.ReadDir(imageDir)
+	if os.IsNotExist(err) {
+		return images, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(imageDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		err = e.write(path.Join("image", f.Name()), body)
+		if err != nil {
+			return nil, err
+		}
+		images["/image/"+f.Name()] = true
+	}
+	return images, nil
+}
+
+// write saves a file in the export, unless it already has that content.
+func (e *exporter) write(file string, body []byte) error {
+	name := filepath.Join(e.dir, filepath.FromSlash(file))
+	old, err := ioutil.ReadFile(name)
+	if err == nil && bytes.Equal(old, body) {
+		e.stats.Unchanged++
+		return nil
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(name, body, 0644)
+	if err != nil {
+		return err
+	}
+	e.stats.Written++
+	return nil
+}
@@ -0,0 +1,90 @@
+package cms
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_Export(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "cms-export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		images := filepath.Join(dir, "uploads")
+		out := filepath.Join(dir, "public")
+		err = os.Mkdir(images, 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(images, "cat.jpg"), []byte("meow"), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		postID, err := CreatePost(&Post{Title: "Exported post", Content: "![cat](/image/cat.jpg)", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetTags(KindPost, postID, []string{"Static"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreatePost(&Post{Title: "Unfinished draft", Content: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreatePage(&Page{Title: "Exported page", Content: "about", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := Export(out, images)
+		if err != nil {
+			t.Fatalf("Failed to export: %s\n", err)
+		}
+		if stats.Written == 0 || stats.Unchanged != 0 {
+			t.Errorf("Expected a first export to write everything, got %+v\n", stats)
+		}
+
+		read := func(file string) string {
+			body, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(file)))
+			if err != nil {
+				t.Errorf("Expected %s to be exported: %s\n", file, err)
+			}
+			return string(body)
+		}
+		post := read("post/exported-post/index.html")
+		if !strings.Contains(post, `src="../../image/cat.jpg"`) || !strings.Contains(post, `href="../../tag/static/index.html"`) {
+			t.Errorf("Expected relative links in the post, got %s\n", post)
+		}
+		if strings.Contains(post, `action="/comments"`) {
+			t.Errorf("Expected no comment form in the export\n")
+		}
+		if read("image/cat.jpg") != "meow" {
+			t.Errorf("Expected the image to be copied\n")
+		}
+		read("index.html")
+		read("page/exported-page/index.html")
+		read("page/index.html")
+		read("tag/index.html")
+		read("tag/static/feed.atom")
+		if !strings.Contains(read("feed.rss"), "Exported post") {
+			t.Errorf("Expected the post in the feed\n")
+		}
+		if _, err := os.Stat(filepath.Join(out, "post", "unfinished-draft")); !os.IsNotExist(err) {
+			t.Errorf("Expected the draft not to be exported, got %v\n", err)
+		}
+
+		again, err := Export(out, images)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Written != 0 || again.Unchanged != stats.Written {
+			t.Errorf("Expected an unchanged site not to be rewritten, got %+v after %+v\n", again, stats)
+		}
+	})
+}
@@ -0,0 +1,309 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedLimit is how many of the latest posts a feed carries
+const feedLimit = 20
+
+// The feed formats, which are also the extensions they're served with.
+const (
+	FormatRSS  = "rss"
+	FormatAtom = "atom"
+)
+
+var (
+	// BaseURL is where the cms is served from, without a trailing slash.
+	// Feeds need absolute links, so they're built from it.
+	BaseURL = "http://localhost:3000"
+
+	// SiteTitle names the site in feeds and on the home page.
+	SiteTitle = "Go Projects CMS"
+)
+
+// Feed is a list of posts to syndicate. Paths are relative to the BaseURL of
+// the site, which is the DefaultSite unless the feed is served for another.
+type Feed struct {
+	Title string
+	// Link is the page the feed's posts are listed on
+	Link string
+	// Self is where the feed itself is served, without the extension
+	Self  string
+	Posts []*Post
+	site  *Site
+}
+
+// feedSite is the site the feed is for.
+func (f *Feed) feedSite() *Site {
+	if f.site == nil {
+		return DefaultSite
+	}
+	return f.site
+}
+
+// absURL makes a path absolute with the site's BaseURL.
+func (f *Feed) absURL(path string) string {
+	return strings.TrimRight(f.feedSite().baseURL(), "/") + path
+}
+
+// rootRelative finds the links and images in rendered content whose URLs are
+// relative to the site's root, like /image/cat.png, but not ones like
+// //example.com/cat.png that only leave out the scheme.
+var rootRelative = regexp.MustCompile(`\b(href|src)="(/|/[^/"][^"]*)"`)
+
+// content renders a post's content for the feed. Feed readers show it away
+// from the site, so links within the site are made absolute.
+func (f *Feed) content(p *Post) string {
+	html := string(f.feedSite().Markdown(p.Content))
+	base := strings.Replace(strings.TrimRight(f.feedSite().baseURL(), "/"), "$", "$$", -1)
+	return rootRelative.ReplaceAllString(html, `$1="`+base+`$2"`)
+}
+
+// Updated is when the newest post in the feed was published.
+func (f *Feed) Updated() time.Time {
+	var updated time.Time
+	for _, p := range f.Posts {
+		if p.DatePublished.After(updated) {
+			updated = p.DatePublished
+		}
+	}
+	return updated
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Categories  []string `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSS encodes the feed as RSS 2.0.
+func (f *Feed) RSS() ([]byte, error) {
+	channel := rssChannel{
+		Title:       f.Title,
+		Link:        f.absURL(f.Link),
+		Description: f.Title,
+		Self:        atomLink{Href: f.absURL(f.Self + ".rss"), Rel: "self", Type: "application/rss+xml"},
+		Items:       []rssItem{},
+	}
+	if updated := f.Updated(); !updated.IsZero() {
+		channel.LastBuildDate = updated.Format(time.RFC1123Z)
+	}
+	for _, p := range f.Posts {
+		item := rssItem{
+			Title:       p.Title,
+			Link:        f.absURL("/post/" + p.Slug),
+			Description: f.content(p),
+			GUID:        rssGUID{Value: f.postGUID(p)},
+			PubDate:     p.DatePublished.Format(time.RFC1123Z),
+		}
+		for _, t := range append(p.Categories, p.Tags...) {
+			item.Categories = append(item.Categories, t.Name)
+		}
+		channel.Items = append(channel.Items, item)
+	}
+	return encodeFeed(rss{Version: "2.0", AtomNS: "http://www.w3.org/2005/Atom", Channel: channel})
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Link       atomLink       `xml:"link"`
+	Content    atomContent    `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// Atom encodes the feed as Atom 1.0.
+func (f *Feed) Atom() ([]byte, error) {
+	updated := f.Updated()
+	if updated.IsZero() {
+		// Atom requires a date, and an empty feed has never changed
+		updated = time.Unix(0, 0)
+	}
+	feed := atomFeed{
+		Title:   f.Title,
+		ID:      f.absURL(f.Self + ".atom"),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: f.feedSite().title()},
+		Links: []atomLink{
+			{Href: f.absURL(f.Link), Rel: "alternate", Type: "text/html"},
+			{Href: f.absURL(f.Self + ".atom"), Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: []atomEntry{},
+	}
+	for _, p := range f.Posts {
+		date := p.DatePublished.UTC().Format(time.RFC3339)
+		entry := atomEntry{
+			Title:     p.Title,
+			ID:        f.postGUID(p),
+			Published: date,
+			Updated:   date,
+			Link:      atomLink{Href: f.absURL("/post/" + p.Slug), Rel: "alternate", Type: "text/html"},
+			Content:   atomContent{Type: "html", Value: f.content(p)},
+		}
+		for _, t := range append(p.Categories, p.Tags...) {
+			entry.Categories = append(entry.Categories, atomCategory{Term: t.Path, Label: t.Name})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return encodeFeed(feed)
+}
+
+// postGUID identifies a post for good, even if its slug changes. Links by ID
+// redirect to the current slug.
+func (f *Feed) postGUID(p *Post) string {
+	return f.absURL("/post/" + strconv.Itoa(p.ID))
+}
+
+func encodeFeed(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// feedFormat splits a feed's extension off a path, returning the rest of the
+// path and the format, or "" if the path isn't a feed.
+func feedFormat(path string) (string, string) {
+	for _, format := range []string{FormatRSS, FormatAtom} {
+		if path == "feed."+format || strings.HasSuffix(path, "/feed."+format) {
+			return strings.TrimSuffix(path, "feed."+format), format
+		}
+	}
+	return path, ""
+}
+
+// ServeFeed serves the latest published posts at /feed.rss and /feed.atom.
+func ServeFeed(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	_, format := feedFormat(r.URL.Path)
+	if format == "" {
+		http.NotFound(w, r)
+		return
+	}
+	posts, err := site.GetPosts(StatusPublished, feedLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	serveFeed(w, r, format, &Feed{Title: site.title(), Link: "/", Self: "/feed", Posts: posts, site: site})
+}
+
+// serveTermFeed serves the latest published posts with a tag or category.
+func serveTermFeed(w http.ResponseWriter, r *http.Request, format string, t *Term) {
+	site := SiteOf(r)
+	_, posts, err := site.GetTermContent(t, StatusPublished)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(posts) > feedLimit {
+		posts = posts[:feedLimit]
+	}
+	serveFeed(w, r, format, &Feed{
+		Title: site.title() + ": " + t.Name,
+		Link:  t.URL(),
+		Self:  t.URL() + "/feed",
+		Posts: posts,
+		site:  site,
+	})
+}
+
+// serveFeed encodes the feed and serves it. Feed readers poll, so the feed
+// has a Last-Modified date and an ETag, and unchanged feeds get a 304.
+func serveFeed(w http.ResponseWriter, r *http.Request, format string, f *Feed) {
+	site := SiteOf(r)
+	err := site.LoadPostTerms(f.Posts...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var body []byte
+	contentType := "application/rss+xml; charset=utf-8"
+	if format == FormatAtom {
+		contentType = "application/atom+xml; charset=utf-8"
+		body, err = f.Atom()
+	} else {
+		body, err = f.RSS()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha1.Sum(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	http.ServeContent(w, r, "", f.Updated(), bytes.NewReader(body))
+}
@@ -0,0 +1,133 @@
+package cms
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Feeds(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		date := time.Date(2019, 3, 14, 15, 9, 26, 0, time.UTC)
+		id, err := CreatePost(&Post{Title: "Pi day", Slug: "pi-day", Content: "*Happy* pi day", Status: StatusPublished, DatePublished: date})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetTags(KindPost, id, []string{"Maths"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreatePost(&Post{Title: "Unfinished", Content: "draft"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		get := func(path string, header http.Header) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			mux := http.NewServeMux()
+			mux.HandleFunc("/feed.rss", ServeFeed)
+			mux.HandleFunc("/feed.atom", ServeFeed)
+			mux.HandleFunc("/tag/", ServeTag)
+			r := httptest.NewRequest("GET", path, nil)
+			for k, v := range header {
+				r.Header[k] = v
+			}
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		w := get("/feed.rss", nil)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/rss+xml") {
+			t.Fatalf("Expected an RSS feed, got %d %s\n", w.Code, w.Header().Get("Content-Type"))
+		}
+		var feed rss
+		err = xml.Unmarshal(w.Body.Bytes(), &feed)
+		if err != nil {
+			t.Fatalf("Invalid RSS: %s\n%s\n", err, w.Body.String())
+		}
+		if len(feed.Channel.Items) != 1 {
+			t.Fatalf("Expected only the published post, got %+v\n", feed.Channel.Items)
+		}
+		item := feed.Channel.Items[0]
+		if item.Link != BaseURL+"/post/pi-day" || item.PubDate != "Thu, 14 Mar 2019 15:09:26 +0000" {
+			t.Errorf("Unexpected link or date: %s, %s\n", item.Link, item.PubDate)
+		}
+		if !strings.Contains(item.Description, "<em>Happy</em>") {
+			t.Errorf("Expected rendered content, got %s\n", item.Description)
+		}
+		if len(item.Categories) != 1 || item.Categories[0] != "Maths" {
+			t.Errorf("Expected the tag as a category, got %v\n", item.Categories)
+		}
+
+		w = get("/feed.atom", nil)
+		if !strings.Contains(w.Body.String(), "<published>2019-03-14T15:09:26Z</published>") {
+			t.Errorf("Expected an Atom feed with the publish date, got %s\n", w.Body.String())
+		}
+
+		w = get("/tag/maths/feed.rss", nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Pi day") {
+			t.Errorf("Expected the tag feed, got %d %s\n", w.Code, w.Body.String())
+		}
+		if w = get("/tag/missing/feed.rss", nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for a missing tag's feed, got %d\n", w.Code)
+		}
+
+		w = get("/feed.rss", nil)
+		etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+		if etag == "" || modified != "Thu, 14 Mar 2019 15:09:26 GMT" {
+			t.Fatalf("Expected an ETag and Last-Modified, got %q and %q\n", etag, modified)
+		}
+		if w = get("/feed.rss", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for a matching ETag, got %d\n", w.Code)
+		}
+		if w = get("/feed.rss", http.Header{"If-Modified-Since": {modified}}); w.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for an unchanged feed, got %d\n", w.Code)
+		}
+		if w = get("/feed.rss", http.Header{"If-None-Match": {`"stale"`}}); w.Code != http.StatusOK {
+			t.Errorf("Expected 200 for a stale ETag, got %d\n", w.Code)
+		}
+	})
+}
+
+func Test_FeedLinks(t *testing.T) {
+	f := &Feed{Title: "Links", Link: "/", Self: "/feed", Posts: []*Post{{
+		Slug:    "links",
+		Title:   "Links",
+		Content: "![cat](/image/cat.png) [home](/) [a](/post/a) [out](https://example.com/x) [cdn](//cdn.example.com/y)",
+	}}}
+	rssData, err := f.RSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomData, err := f.Atom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed rss
+	err = xml.Unmarshal(rssData, &feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var atom atomFeed
+	err = xml.Unmarshal(atomData, &atom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"RSS": feed.Channel.Items[0].Description, "Atom": atom.Entries[0].Content.Value} {
+		for _, want := range []string{
+			`src="` + BaseURL + `/image/cat.png"`,
+			`href="` + BaseURL + `/"`,
+			`href="` + BaseURL + `/post/a"`,
+			`href="https://example.com/x"`,
+			`href="//cdn.example.com/y"`,
+		} {
+			if !strings.Contains(content, want) {
+				t.Errorf("Expected the %s content to have %s, got %s\n", name, want, content)
+			}
+		}
+	}
+}
@@ -1,122 +1,1085 @@
 package cms
 
 import (
+	"html/template"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"time"
 )
 
-// HandleNew handles preview NewCatalogService
+// indexPostLimit is how many recent posts are shown on the home page
+const indexPostLimit = 10
+
+// HandleNew is the editor for new pages and posts at /new, with a preview of
+// what's being written beside it. Posting the form with an action of preview
+// renders the page or post with the templates readers see, without saving
+// anything; otherwise it's saved and shown the same way.
 func HandleNew(w http.ResponseWriter, req *http.Request) {
+	site := SiteOf(req)
 	switch req.Method {
 	case "GET":
-		Tmpl.ExecuteTemplate(w, "new", nil)
+		images, err := site.GetImages()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		site.render(w, "new", struct {
+			Images    []*Image
+			CSRFField template.HTML
+		}{images, CSRFField(req)})
 
 	case "POST":
 		title := req.FormValue("title")
+		slug := req.FormValue("slug")
 		content := req.FormValue("content")
 		contentType := req.FormValue("contentType")
+		status := req.FormValue("status")
+		tags := ParseTerms(req.FormValue("tags"))
+		categories := ParseTerms(req.FormValue("categories"))
+		publishAt, err := parsePublishAt(req.FormValue("publish_at"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		featured := 0
+		if req.FormValue("featured_image") != "" {
+			featured, err = strconv.Atoi(req.FormValue("featured_image"))
+			if err != nil {
+				http.Error(w, "featured_image must be an image ID", http.StatusBadRequest)
+				return
+			}
+		}
 		req.ParseForm()
+		preview := req.FormValue("action") == "preview"
+		if !preview && (!CanPublish(req, status, "") || !CanSchedule(req, publishAt)) {
+			forbidden(w, RoleEditor)
+			return
+		}
 
 		if contentType == "page" {
 			p := &Page{
+				Slug:    slug,
 				Title:   title,
 				Content: content,
+				Status:  status,
+				Author:  CurrentUser(req),
+			}
+			if preview {
+				p.Tags, p.Categories = previewTerms(TaxonomyTag, tags), previewTerms(TaxonomyCategory, categories)
+				site.previewPage(w, p)
+				return
+			}
+			id, err := site.CreatePage(p)
+			if err == nil {
+				err = site.SetTerms(KindPage, id, tags, categories)
+			}
+			if err == nil {
+				p.ID = id
+				err = site.LoadPageTerms(p)
 			}
-			_, err := CreatePage(p)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				saveError(w, err)
 				// return, otherwise the func will continue to execute
 				return
 			}
-			Tmpl.ExecuteTemplate(w, "page", p)
+			site.render(w, "page", p)
 			return
 		}
 
 		if contentType == "post" {
-			Tmpl.ExecuteTemplate(w, "post", &Post{
-				Title:   title,
-				Content: content,
-			})
+			p := &Post{
+				Slug:            slug,
+				Title:           title,
+				Content:         content,
+				Status:          status,
+				PublishAt:       publishAt,
+				Author:          CurrentUser(req),
+				Editor:          CurrentUser(req),
+				FeaturedImageID: featured,
+			}
+			if preview {
+				p.Tags, p.Categories = previewTerms(TaxonomyTag, tags), previewTerms(TaxonomyCategory, categories)
+				site.previewPost(w, p)
+				return
+			}
+			id, err := site.CreatePost(p)
+			if err == nil {
+				err = site.SetTerms(KindPost, id, tags, categories)
+			}
+			if err == nil {
+				p.ID = id
+				err = site.LoadPostTerms(p)
+			}
+			if err == nil {
+				err = site.LoadFeaturedImages(p)
+			}
+			if err != nil {
+				saveError(w, err)
+				return
+			}
+			site.render(w, "post_page", &postPage{Post: p, Static: true})
 			return
 		}
+
+		http.Error(w, "Unknown content type: "+contentType, http.StatusBadRequest)
 	default:
 		http.Error(w, "Method not supported: "+req.Method, http.StatusMethodNotAllowed)
 	}
 }
 
 // ServePage serves a page based on the route matched. This will match any URL
-// beginning with /page
+// beginning with /page. Pages are found by slug; numeric IDs and old slugs
+// redirect to the current slug. Unpublished pages are only served to
+// logged-in users, and anonymous readers are served from the cache.
 func ServePage(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimLeft(r.URL.Path, "/page/")
+	site := SiteOf(r)
+	path := strings.TrimPrefix(r.URL.Path, "/page/")
 
 	if path == "" {
-		pages, err := GetPages()
+		servePages(w, r)
+		return
+	}
+
+	miss, served := serveCached(w, r)
+	if served {
+		return
+	}
+	page, canonical, err := site.ResolvePage(path)
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	if !CanSee(r, page.Status) {
+		http.NotFound(w, r)
+		return
+	}
+	if !canonical {
+		http.Redirect(w, r, localPath(r, "/page/"+page.Slug), http.StatusMovedPermanently)
+		return
+	}
+	err = site.LoadPageTerms(page)
+	if err == nil {
+		err = site.TranslatePages(LangOf(r), page)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deps := []string{pageDep(page.ID)}
+	for _, id := range shortcodeImageIDs(page.Content) {
+		deps = append(deps, imageDep(id))
+	}
+	miss.render(w, "page", page, deps...)
+}
+
+// servePages serves the page listing, with the paging, sorting and filtering
+// from the query string.
+func servePages(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	q, err := ParsePageQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if anonymous(r) {
+		q.Status = StatusPublished
+	}
+	list, err := site.ListPages(q)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == ErrBadCursor {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	site.render(w, "pages", struct {
+		*PageList
+		Query   PageQuery
+		NextURL string
+		PrevURL string
+	}{
+		PageList: list,
+		Query:    q,
+		NextURL:  q.Link("/page/", "after", list.Next),
+		PrevURL:  q.Link("/page/", "before", list.Prev),
+	})
+}
+
+// ServePost serves a post and its comments. Like pages, posts are found by
+// slug and everything else redirects, and unpublished posts are only served
+// to logged-in users. Anonymous readers are served from the cache.
+func ServePost(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	path := strings.TrimPrefix(r.URL.Path, "/post/")
+
+	if path == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	miss, served := serveCached(w, r)
+	if served {
+		return
+	}
+	p, canonical, err := site.ResolvePost(path)
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	if !CanSee(r, p.Status) {
+		http.NotFound(w, r)
+		return
+	}
+	if !canonical {
+		target := localPath(r, "/post/"+p.Slug)
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	p.Comments, err = site.GetComments(p.ID)
+	if err == nil {
+		err = site.LoadPostTerms(p)
+	}
+	if err == nil {
+		err = site.LoadFeaturedImages(p)
+	}
+	if err == nil {
+		err = site.TranslatePosts(LangOf(r), p)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setReplyURLs(r, p.Slug, p.Comments)
+
+	// ?reply= picks the comment the form replies to. A static export has no
+	// form at all.
+	replyTo, _ := strconv.Atoi(r.FormValue("reply"))
+	deps := []string{postDep(p.ID), depComments}
+	if p.FeaturedImageID != 0 {
+		deps = append(deps, imageDep(p.FeaturedImageID))
+	}
+	for _, id := range shortcodeImageIDs(p.Content) {
+		deps = append(deps, imageDep(id))
+	}
+	miss.render(w, "post_page", &postPage{p, miss.CSRFField(), Spam.Honeypot, r.FormValue("comment") == "pending", replyTo, Exporting(r)}, deps...)
+}
+
+// postPage is the view of a post's page.
+type postPage struct {
+	Post      *Post
+	CSRFField template.HTML
+	Honeypot  string
+	// Pending thanks the reader for a comment awaiting moderation
+	Pending bool
+	// ReplyTo is the comment the form replies to
+	ReplyTo int
+	// Static leaves out the comment form, for pages that can't take
+	// comments
+	Static bool
+}
+
+// HandleComment takes a reader's comment on a post, or reply to another
+// comment with parent_id, from the form on the post's page. Comments wait in
+// the moderation queue, so the reader is sent back to the post with a note
+// saying so.
+func HandleComment(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	if r.Method != "POST" {
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := parseID(r.FormValue("post_id"))
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	p, err := site.GetPost(strconv.Itoa(id))
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	if !CanSee(r, p.Status) {
+		http.NotFound(w, r)
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	parentID := 0
+	if parent := r.FormValue("parent_id"); parent != "" && parent != "0" {
+		parentID, err = strconv.Atoi(parent)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, ErrBadParent.Error(), http.StatusBadRequest)
 			return
 		}
-		Tmpl.ExecuteTemplate(w, "pages", pages)
+	}
+	c := &Comment{
+		PostID:   p.ID,
+		ParentID: parentID,
+		Author:   r.FormValue("author"),
+		Comment:  r.FormValue("comment"),
+		IP:       ip,
+	}
+	_, err = site.SubmitComment(c, r.FormValue(Spam.Honeypot))
+	switch err {
+	case nil:
+	case ErrEmptyComment, ErrBadParent:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case ErrCommentRate:
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	default:
+		lookupError(w, err)
 		return
 	}
+	http.Redirect(w, r, "/post/"+p.Slug+"?comment=pending#comments", http.StatusSeeOther)
+}
 
-	page, err := GetPage(path)
+// ServeIndex serves the home page with the most recent published posts,
+// from the cache for anonymous readers.
+func ServeIndex(w http.ResponseWriter, req *http.Request) {
+	site := SiteOf(req)
+	miss, served := serveCached(w, req)
+	if served {
+		return
+	}
+	lang := LangOf(req)
+	posts, err := site.GetPosts(StatusPublished, indexPostLimit)
+	if err == nil {
+		err = site.LoadPostTerms(posts...)
+	}
+	if err == nil {
+		err = site.LoadFeaturedImages(posts...)
+	}
+	if err == nil {
+		err = site.TranslatePosts(lang, posts...)
+	}
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, post := range posts {
+		post.Comments, err = site.GetComments(post.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setReplyURLs(req, post.Slug, post.Comments)
+	}
+
+	p := &Page{
+		Title:      site.title(),
+		Content:    "Welcome to our home page!",
+		Lang:       lang,
+		Alternates: alternates("/", site.languages()),
+		Posts:      posts,
+	}
+
+	miss.render(w, "page", p, depPosts, depComments)
+}
+
+// lookupError responds to a failed lookup: 404 if nothing matched, 500 if the
+// store failed.
+func lookupError(w http.ResponseWriter, err error) {
+	if err == ErrNotFound {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
 
-	Tmpl.ExecuteTemplate(w, "page", page)
+// saveError responds to a failed save: 400 if what was submitted is invalid,
+// 403 if it needs a more trusted role, 413 if it's too big, 409 if it
+// conflicts with a save made since, and otherwise like lookupError.
+func saveError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrBadStatus, ErrSlugTaken, ErrNoImage, ErrNotImage, ErrBadFilename, ErrBadLang:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case ErrCantSchedule:
+		forbidden(w, RoleEditor)
+	case ErrImageTooBig:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case ErrConflict:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		lookupError(w, err)
+	}
 }
 
-// ServePost serves a post
-func ServePost(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimLeft(r.URL.Path, "/post/")
+// ServeSearch serves the results of searching pages and posts for ?q=.
+// Anonymous readers only find published content.
+func ServeSearch(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	query := r.URL.Query().Get("q")
+	results, err := site.Search(query, VisibleStatus(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	site.render(w, "search", struct {
+		Query   string
+		Results []*SearchResult
+	}{query, results})
+}
+
+// ServeHistory serves the revision history of pages and posts:
+//
+//	/history/{kind}/{id}                     lists the revisions
+//	/history/{kind}/{id}/diff?from=1&to=2    compares two revisions
+//	/history/{kind}/{id}/rollback            restores the revision in rev (POST)
+func ServeHistory(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/history/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	kind := parts[0]
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || (kind != KindPage && kind != KindPost) {
+		http.NotFound(w, r)
+		return
+	}
+
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	switch action {
+	case "":
+		revs, err := site.GetRevisions(kind, id)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		if len(revs) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		site.render(w, "history", struct {
+			Kind      string
+			ID        int
+			Revisions []*Revision
+			CSRFField template.HTML
+		}{kind, id, revs, CSRFField(r)})
+
+	case "diff":
+		from, err1 := strconv.Atoi(r.FormValue("from"))
+		to, err2 := strconv.Atoi(r.FormValue("to"))
+		if err1 != nil || err2 != nil {
+			http.Error(w, "from and to must be revision numbers", http.StatusBadRequest)
+			return
+		}
+		a, err := site.GetRevision(kind, id, from)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		b, err := site.GetRevision(kind, id, to)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		site.render(w, "diff", struct {
+			Kind     string
+			ID       int
+			From, To *Revision
+			Lines    []DiffLine
+		}{kind, id, a, b, Diff(a.Content, b.Content)})
+
+	case "rollback":
+		if r.Method != "POST" {
+			http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		number, err := strconv.Atoi(r.FormValue("rev"))
+		if err != nil {
+			http.Error(w, "rev must be a revision number", http.StatusBadRequest)
+			return
+		}
+		err = site.Rollback(kind, id, number, CurrentUser(r))
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/history/"+kind+"/"+strconv.Itoa(id), http.StatusSeeOther)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
 
+// ServeAdminPosts lists posts by ?status=, and moves a post through the
+// workflow when its form is posted back with an id, status and publish_at.
+// Only editors may publish a post.
+func ServeAdminPosts(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	switch r.Method {
+	case "GET":
+		status := r.FormValue("status")
+		if status != "" && checkStatus(&status) != nil {
+			http.Error(w, ErrBadStatus.Error(), http.StatusBadRequest)
+			return
+		}
+		posts, err := site.GetPosts(status, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Unpublished posts can be shared with a preview link
+		previews := map[int]string{}
+		for _, p := range posts {
+			if !p.Published() {
+				previews[p.ID] = site.PreviewURL(KindPost, p.ID)
+			}
+		}
+		site.render(w, "admin_posts", struct {
+			Statuses  []string
+			Posts     []*Post
+			Previews  map[int]string
+			CSRFField template.HTML
+		}{Statuses, posts, previews, CSRFField(r)})
+
+	case "POST":
+		id, err := parseID(r.FormValue("id"))
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		publishAt, err := parsePublishAt(r.FormValue("publish_at"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := site.store.GetPost(id)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		if !CanPublish(r, r.FormValue("status"), statusOf(p.Status)) || !CanSchedule(r, publishAt) {
+			forbidden(w, RoleEditor)
+			return
+		}
+		err = site.SetPostStatus(id, r.FormValue("status"), publishAt, CurrentUser(r))
+		if err != nil {
+			saveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/posts", http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeAdminComments lists the comments with ?status=, pending by default, and
+// approves, rejects or marks a comment as spam when its form is posted back
+// with an id and status. Only editors may moderate.
+func ServeAdminComments(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	switch r.Method {
+	case "GET":
+		status := r.FormValue("status")
+		if status == "" {
+			status = CommentPending
+		}
+		comments, err := site.GetCommentQueue(status)
+		if err != nil {
+			saveError(w, err)
+			return
+		}
+		site.render(w, "admin_comments", struct {
+			Status    string
+			Statuses  []string
+			Comments  []*Comment
+			CSRFField template.HTML
+		}{status, CommentStatuses, comments, CSRFField(r)})
+
+	case "POST":
+		if !HasRole(r, RoleEditor) {
+			forbidden(w, RoleEditor)
+			return
+		}
+		id, err := parseID(r.FormValue("id"))
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		status := r.FormValue("status")
+		err = site.SetCommentStatus(id, status)
+		if err != nil {
+			saveError(w, err)
+			return
+		}
+		// Back to the queue the comment was moderated from
+		next := "/admin/comments"
+		if from := r.FormValue("from"); contains(CommentStatuses, from) {
+			next += "?status=" + from
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+// pageForm is the view of the form editing a page.
+type pageForm struct {
+	Page       *Page
+	Tags       string
+	Categories string
+	Statuses   []string
+	CSRFField  template.HTML
+	// PreviewURL shares the page until it's published
+	PreviewURL string
+	// Conflict is set when the page was saved by someone else while the form
+	// was open. Page then holds what was submitted, with the version saved
+	// since, so submitting again overwrites it.
+	Conflict bool
+}
+
+// joinTerms turns terms back into the comma separated list typed into forms.
+func joinTerms(terms []*Term, path bool) string {
+	names := []string{}
+	for _, t := range terms {
+		if path {
+			names = append(names, t.Path)
+		} else {
+			names = append(names, t.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// ServeAdminPages edits and deletes pages:
+//
+//	/admin/pages/{id}/edit     the form editing the page, saved when posted
+//	/admin/pages/{id}/delete   asks to confirm, and moves the page to the
+//	                           trash when posted
+//
+// Edits carry the version of the page they started from, and are refused if
+// the page has been saved since. Only editors may publish a page, and only
+// admins may delete one.
+func ServeAdminPages(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/pages/"), "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	p, err := site.GetPage(parts[0])
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+
+	switch {
+	case parts[1] == "edit" && r.Method == "GET":
+		err = site.LoadPageTerms(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		form := &pageForm{
+			Page:       p,
+			Tags:       joinTerms(p.Tags, false),
+			Categories: joinTerms(p.Categories, true),
+			Statuses:   Statuses,
+			CSRFField:  CSRFField(r),
+		}
+		if !p.Published() {
+			form.PreviewURL = site.PreviewURL(KindPage, p.ID)
+		}
+		site.render(w, "edit_page", form)
+
+	case parts[1] == "edit" && r.Method == "POST":
+		version, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil || version < 1 {
+			http.Error(w, "Missing the version being edited", http.StatusBadRequest)
+			return
+		}
+		edit := &Page{
+			ID:      p.ID,
+			Slug:    r.FormValue("slug"),
+			Title:   r.FormValue("title"),
+			Content: r.FormValue("content"),
+			Status:  r.FormValue("status"),
+			Version: version,
+			Editor:  CurrentUser(r),
+		}
+		if !CanPublish(r, edit.Status, statusOf(p.Status)) {
+			forbidden(w, RoleEditor)
+			return
+		}
+		err = site.UpdatePage(edit)
+		if err == ErrConflict {
+			edit.Version = p.Version
+			site.renderStatus(w, http.StatusConflict, "edit_page", &pageForm{
+				Page:       edit,
+				Tags:       r.FormValue("tags"),
+				Categories: r.FormValue("categories"),
+				Statuses:   Statuses,
+				CSRFField:  CSRFField(r),
+				Conflict:   true,
+			})
+			return
+		}
+		if err == nil {
+			err = site.SetTerms(KindPage, p.ID, ParseTerms(r.FormValue("tags")), ParseTerms(r.FormValue("categories")))
+		}
+		if err == nil {
+			p, err = site.store.GetPage(p.ID)
+		}
+		if err != nil {
+			saveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/page/"+p.Slug, http.StatusSeeOther)
+
+	case parts[1] == "delete" && !HasRole(r, RoleAdmin):
+		forbidden(w, RoleAdmin)
+
+	case parts[1] == "delete" && r.Method == "GET":
+		site.render(w, "delete_page", struct {
+			Page      *Page
+			CSRFField template.HTML
+		}{p, CSRFField(r)})
+
+	case parts[1] == "delete" && r.Method == "POST":
+		err = site.DeletePage(p.ID)
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+
+	case parts[1] == "edit" || parts[1] == "delete":
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// ServeTrash lists the pages in the trash, and restores or purges one when
+// its form is posted back with an id and an action of restore or purge. The
+// trash is only for admins.
+func ServeTrash(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	if !HasRole(r, RoleAdmin) {
+		forbidden(w, RoleAdmin)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		pages, err := site.GetTrash()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		site.render(w, "trash", struct {
+			Pages     []*Page
+			CSRFField template.HTML
+		}{pages, CSRFField(r)})
+
+	case "POST":
+		id, err := parseID(r.FormValue("id"))
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		switch r.FormValue("action") {
+		case "restore":
+			p, err := site.RestorePage(id)
+			if err != nil {
+				saveError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/page/"+p.Slug, http.StatusSeeOther)
+		case "purge":
+			err = site.PurgePage(id)
+			if err != nil {
+				lookupError(w, err)
+				return
+			}
+			http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+		default:
+			http.Error(w, "Unknown action: "+r.FormValue("action"), http.StatusBadRequest)
+		}
+
+	default:
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeMedia is the media library at /admin/media. It lists the images, or
+// with ?orphans=1 only those nothing uses, with the Markdown for putting each
+// one in a page or post. Posting it back with an action uploads an image with
+// its alt text, changes the alt text of the image with the id, or deletes it.
+// Only admins may delete images.
+func ServeMedia(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	switch r.Method {
+	case "GET":
+		orphans := r.FormValue("orphans") != ""
+		list := site.GetImages
+		if orphans {
+			list = site.OrphanedImages
+		}
+		images, err := list()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		site.render(w, "media", struct {
+			Images    []*Image
+			Orphans   bool
+			CanDelete bool
+			CSRFField template.HTML
+		}{images, orphans, HasRole(r, RoleAdmin), CSRFField(r)})
+
+	case "POST":
+		// The form is read no further than an upload could need
+		r.Body = http.MaxBytesReader(w, r.Body, MaxImageBytes+1<<20)
+		switch r.FormValue("action") {
+		case "upload":
+			file, header, err := r.FormFile("image")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			_, err = site.SaveImage(header.Filename, file, r.FormValue("alt"), CurrentUser(r))
+			if err != nil {
+				saveError(w, err)
+				return
+			}
+		case "alt":
+			id, err := parseID(r.FormValue("id"))
+			if err == nil {
+				err = site.SetImageAlt(id, r.FormValue("alt"))
+			}
+			if err != nil {
+				lookupError(w, err)
+				return
+			}
+		case "delete":
+			if !HasRole(r, RoleAdmin) {
+				forbidden(w, RoleAdmin)
+				return
+			}
+			id, err := parseID(r.FormValue("id"))
+			if err == nil {
+				err = site.DeleteImage(id)
+			}
+			if err != nil {
+				lookupError(w, err)
+				return
+			}
+		default:
+			http.Error(w, "Unknown action: "+r.FormValue("action"), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/media", http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeTranslations shows how far the pages and posts have been translated
+// into the site's languages, and translates them:
+//
+//	/admin/translations                     every page and post, with the
+//	                                        languages it's translated into and
+//	                                        those it's missing, or only those
+//	                                        missing the language in ?missing=
+//	/admin/translations/{kind}/{id}/{lang}  the form translating a page or
+//	                                        post, saved when posted
+//
+// Saving a translation with neither a title nor content deletes it.
+func ServeTranslations(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/translations"), "/")
 	if path == "" {
+		if r.Method != "GET" {
+			http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		list, err := site.GetTranslationStatus()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		missing := r.FormValue("missing")
+		if missing != "" {
+			var filtered []*TranslationStatus
+			for _, item := range list {
+				if contains(item.Missing, missing) {
+					filtered = append(filtered, item)
+				}
+			}
+			list = filtered
+		}
+		site.render(w, "translations", struct {
+			Languages []string
+			Missing   string
+			Items     []*TranslationStatus
+		}{site.languages()[1:], missing, list})
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 3 || !contains(site.languages()[1:], parts[2]) {
 		http.NotFound(w, r)
 		return
 	}
+	kind, lang := parts[0], parts[2]
+	id, err := parseID(parts[1])
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		title, content, err := site.untranslated(kind, id)
+		if err == ErrBadKind {
+			err = ErrNotFound
+		}
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		translations, err := site.GetTranslations(kind, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		translation := &Translation{Kind: kind, ItemID: id, Lang: lang}
+		for _, t := range translations {
+			if t.Lang == lang {
+				translation = t
+			}
+		}
+		site.render(w, "edit_translation", struct {
+			Title       string
+			Content     string
+			Translation *Translation
+			CSRFField   template.HTML
+		}{title, content, translation, CSRFField(r)})
+
+	case "POST":
+		err = site.SetTranslation(&Translation{
+			Kind:    kind,
+			ItemID:  id,
+			Lang:    lang,
+			Title:   r.FormValue("title"),
+			Content: r.FormValue("content"),
+		})
+		if err == ErrBadKind {
+			err = ErrNotFound
+		}
+		if err != nil {
+			saveError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/translations", http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not supported: "+r.Method, http.StatusMethodNotAllowed)
+	}
+}
+
+// ServeTag serves the tag cloud at /tag/, and lists the pages and posts with
+// a tag at /tag/{slug}. Anonymous readers only see published content. The
+// tag's posts are also syndicated at /tag/{slug}/feed.rss and feed.atom.
+func ServeTag(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	path, format := feedFormat(strings.TrimPrefix(r.URL.Path, "/tag/"))
+	slug := strings.Trim(path, "/")
+	if slug == "" {
+		cloud, err := site.TagCloud(VisibleStatus(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		site.render(w, "tags", cloud)
+		return
+	}
 
-	p := &Post{
-		Title:   strings.ToTitle(path),
-		Content: "Here is my page",
-		Comments: []*Comment{
-			&Comment{
-				Author:        "Roy Wei",
-				Comment:       "Looks great!",
-				DatePublished: time.Now(),
-			},
-		},
+	tag, err := site.GetTag(slug)
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	if format != "" {
+		serveTermFeed(w, r, format, tag)
+		return
+	}
+	serveTerm(w, r, "tag", tag, nil)
+}
+
+// ServeCategory serves the category tree at /category/, and lists the pages
+// and posts in a category, or nested inside it, at /category/{path}. Like
+// tags, categories have feeds at /category/{path}/feed.rss and feed.atom.
+func ServeCategory(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	path, format := feedFormat(strings.TrimPrefix(r.URL.Path, "/category/"))
+	path = strings.Trim(path, "/")
+	categories, err := site.GetCategories()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if path == "" {
+		site.render(w, "categories", categories)
+		return
 	}
 
-	Tmpl.ExecuteTemplate(w, "post", p)
+	category, err := site.GetCategory(path)
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	if format != "" {
+		serveTermFeed(w, r, format, category)
+		return
+	}
+	subcategories := []*Term{}
+	for _, c := range categories {
+		if c.ParentID == category.ID {
+			subcategories = append(subcategories, c)
+		}
+	}
+	serveTerm(w, r, "category", category, subcategories)
 }
 
-func ServeIndex(w http.ResponseWriter, req *http.Request) {
-	p := &Page{
-		Title:   "Go Projects CMS",
-		Content: "Welcome to our home page!",
-		Posts: []*Post{
-			&Post{
-				Title:         "Hello, World!",
-				Content:       "Hello world! Thanks for coming to the site.",
-				DatePublished: time.Now(),
-			},
-			&Post{
-				Title:         "A Post with Comments",
-				Content:       "Here's a controversial post. It's sure to attract comments.",
-				DatePublished: time.Now().Add(-time.Hour),
-				Comments: []*Comment{
-					&Comment{
-						Author:        "Roy Wei",
-						Comment:       "Nevermind, I guess I just commented on my own post...",
-						DatePublished: time.Now().Add(-time.Hour / 2),
-					},
-				},
-			},
-		},
-	}
-
-	Tmpl.ExecuteTemplate(w, "page", p)
+// serveTerm lists the content of a tag or category with the named template.
+func serveTerm(w http.ResponseWriter, r *http.Request, name string, t *Term, children []*Term) {
+	site := SiteOf(r)
+	pages, posts, err := site.GetTermContent(t, VisibleStatus(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	site.render(w, name, struct {
+		Term     *Term
+		Children []*Term
+		Pages    []*Page
+		Posts    []*Post
+	}{t, children, pages, posts})
 }
@@ -0,0 +1,302 @@
+package cms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ImageSizes are the named widths made of every upload, which can be asked
+// for with /image/{filename}?size={name}.
+var ImageSizes = map[string]int{
+	"thumb":  150,
+	"medium": 600,
+}
+
+// MaxImageWidth is the widest an image can be resized to with ?w=.
+const MaxImageWidth = 2000
+
+// widthSteps are the widths ?w= is rounded up to, besides the ImageSizes, so
+// only a few sizes of each image are ever made and cached.
+var widthSteps = []int{320, 800, 1200, 1600, MaxImageWidth}
+
+// MaxImageBytes is the largest upload that's saved, in bytes.
+var MaxImageBytes int64 = 20 << 20
+
+// MaxImagePixels is the most pixels an image can have to be decoded, which
+// keeps a small file claiming to be huge from using up the memory.
+var MaxImagePixels = 50 * 1000 * 1000
+
+// jpegQuality is the quality resized JPEGs are encoded at
+const jpegQuality = 85
+
+// snapWidth rounds a width up to the nearest of the ImageSizes and
+// widthSteps.
+func snapWidth(width int) int {
+	snapped := MaxImageWidth
+	for _, w := range ImageSizes {
+		if w >= width && w < snapped {
+			snapped = w
+		}
+	}
+	for _, w := range widthSteps {
+		if w >= width && w < snapped {
+			snapped = w
+		}
+	}
+	return snapped
+}
+
+// decodeImage decodes an upload, turning JPEGs the right way up by their EXIF
+// orientation. It returns ErrImageTooBig, without decoding it, for an image
+// of more than MaxImagePixels.
+func decodeImage(data []byte) (image.Image, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrNotImage
+	}
+	if config.Width < 0 || config.Height < 0 || int64(config.Width)*int64(config.Height) > int64(MaxImagePixels) {
+		return nil, "", ErrImageTooBig
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrNotImage
+	}
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	return img, format, nil
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, from 1 to 8, or
+// returns 1 if it doesn't have one.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	// Walk the segments up to the image data, looking for APP1 with Exif
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag in the first IFD of a TIFF
+// structure, as found in a JPEG's Exif segment.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// The orientation is a SHORT, stored in the first bytes of the value
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			o := int(order.Uint16(tiff[entry+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+	return 1
+}
+
+// orient turns an image with an EXIF orientation the right way up.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5 to 8 swap the width and the height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// Where the pixel at x, y comes from in the stored image
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			out.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return out
+}
+
+// resize scales an image down to width, keeping its aspect ratio. Each pixel
+// is the average of the pixels it covers, which keeps thumbnails smooth.
+func resize(img image.Image, width int) *image.NRGBA {
+	b := img.Bounds()
+	height := (b.Dy()*width + b.Dx()/2) / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			// Premultiplied colors average without dark fringes at
+			// transparent edges
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			out.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return out
+}
+
+// encodeImage writes a resized image. JPEGs stay JPEGs, and everything else
+// becomes a PNG, which is lossless.
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	if format == "jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	return png.Encode(w, img)
+}
+
+// imageCacheDir holds the resized images, in a directory for each width.
+// Its name starts with a dot, so it can't clash with an upload.
+func (site *Site) imageCacheDir() string {
+	return filepath.Join(site.imageDir(), ".cache")
+}
+
+// derivativePath is where the image is cached at the width.
+func (site *Site) derivativePath(filename string, width int) string {
+	return filepath.Join(site.imageCacheDir(), strconv.Itoa(width), filename)
+}
+
+// makeDerivative resizes a decoded image and caches it on disk. It returns
+// false, and writes nothing, if the image isn't wider than width.
+func (site *Site) makeDerivative(filename string, img image.Image, format string, width int) (bool, error) {
+	if img.Bounds().Dx() <= width {
+		return false, nil
+	}
+	var buf bytes.Buffer
+	err := encodeImage(&buf, resize(img, width), format)
+	if err != nil {
+		return false, err
+	}
+	path := site.derivativePath(filename, width)
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return false, err
+	}
+	// Write to a temporary file and rename it, so a request resizing the
+	// same image at the same time never serves half a file
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return false, err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return false, err
+	}
+	return true, nil
+}
+
+// derivative returns the cached file of an upload resized to width, making it
+// if it isn't cached yet. It returns "" if the upload isn't wider than width,
+// or can't be decoded, so the original should be served.
+func (site *Site) derivative(filename string, width int) (string, error) {
+	path := site.derivativePath(filename, width)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(site.imageDir(), filename))
+	if err != nil {
+		return "", err
+	}
+	img, format, err := decodeImage(data)
+	if err == ErrNotImage || err == ErrImageTooBig {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	made, err := site.makeDerivative(filename, img, format, width)
+	if err != nil || !made {
+		return "", err
+	}
+	return path, nil
+}
+
+// removeDerivatives deletes every cached size of an upload.
+func (site *Site) removeDerivatives(filename string) {
+	dirs, _ := ioutil.ReadDir(site.imageCacheDir())
+	for _, dir := range dirs {
+		os.Remove(filepath.Join(site.imageCacheDir(), dir.Name(), filename))
+	}
+}
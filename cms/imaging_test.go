@@ -0,0 +1,161 @@
+package cms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// withOrientation adds an Exif segment with the orientation to a JPEG.
+func withOrientation(data []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	// One entry: tag, type SHORT, count 1, then the value padded to 4 bytes
+	binary.Write(&tiff, binary.BigEndian, []uint16{1, 0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(data[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(data[2:])
+	return out.Bytes()
+}
+
+func Test_Orientation(t *testing.T) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 40, 20)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := jpegOrientation(buf.Bytes()); o != 1 {
+		t.Errorf("Expected no orientation, got %d\n", o)
+	}
+	rotated := withOrientation(buf.Bytes(), 6)
+	if o := jpegOrientation(rotated); o != 6 {
+		t.Errorf("Expected orientation 6, got %d\n", o)
+	}
+	img, format, err := decodeImage(rotated)
+	if err != nil || format != "jpeg" {
+		t.Fatalf("Failed to decode: %s %v\n", format, err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Errorf("Expected the image turned to 20x40, got %dx%d\n", b.Dx(), b.Dy())
+	}
+
+	// A red pixel at the left, turned clockwise, ends up at the top
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	src.Set(1, 0, color.NRGBA{0, 0, 255, 255})
+	tests := []struct {
+		orientation int
+		x, y        int
+	}{
+		{2, 1, 0}, {3, 1, 0}, {4, 0, 0}, {5, 0, 0}, {6, 0, 0}, {7, 0, 1}, {8, 0, 1},
+	}
+	for _, test := range tests {
+		out := orient(src, test.orientation)
+		if r, _, _, _ := out.At(test.x, test.y).RGBA(); r != 0xFFFF {
+			t.Errorf("Expected red at %d,%d for orientation %d\n", test.x, test.y, test.orientation)
+		}
+	}
+}
+
+func Test_Resize(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x += 2 {
+		src.SetGray(x, 0, color.Gray{255})
+		src.SetGray(x, 1, color.Gray{255})
+	}
+	out := resize(src, 2)
+	if b := out.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("Expected 2x1, got %dx%d\n", b.Dx(), b.Dy())
+	}
+	if c := out.NRGBAAt(0, 0); c.R < 126 || c.R > 128 {
+		t.Errorf("Expected black and white to average to grey, got %v\n", c)
+	}
+}
+
+func Test_SnapWidth(t *testing.T) {
+	for width, want := range map[int]int{1: 150, 150: 150, 151: 320, 600: 600, 999: 1200, 1999: 2000, 2000: 2000} {
+		if got := snapWidth(width); got != want {
+			t.Errorf("Expected %d to be rounded up to %d, got %d\n", width, want, got)
+		}
+	}
+}
+
+func Test_ImageDerivatives(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+
+		if _, err := SaveImage("fake.gif", bytes.NewReader([]byte("GIF89a\x01\x00\x01\x00\x00\x00\x00 not really")), "", ""); err != ErrNotImage {
+			t.Errorf("Expected a broken image to be refused, got %v\n", err)
+		}
+
+		img, err := SaveImage("wide.png", bytes.NewReader(testPNG(t, 300, 100)), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(DefaultSite.derivativePath(img.Filename, ImageSizes["thumb"])); err != nil {
+			t.Errorf("Expected a thumbnail to be made: %s\n", err)
+		}
+		if _, err = os.Stat(DefaultSite.derivativePath(img.Filename, ImageSizes["medium"])); !os.IsNotExist(err) {
+			t.Errorf("Expected no medium size wider than the image, got %v\n", err)
+		}
+
+		get := func(query string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			ServeImage(w, httptest.NewRequest("GET", "/image/wide.png"+query, nil))
+			return w
+		}
+		w := get("?w=100")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("Expected a resized PNG, got %d %q\n", w.Code, w.Header().Get("Content-Type"))
+		}
+		resized, err := png.DecodeConfig(w.Body)
+		if err != nil || resized.Width != 150 || resized.Height != 50 {
+			t.Errorf("Expected ?w=100 to be rounded up to 150x50, got %+v, %v\n", resized, err)
+		}
+		if _, err = os.Stat(DefaultSite.derivativePath(img.Filename, 100)); !os.IsNotExist(err) {
+			t.Errorf("Expected no size cached but the rounded one, got %v\n", err)
+		}
+		w = get("?w=250")
+		if resized, err = png.DecodeConfig(w.Body); err != nil || resized.Width != 300 {
+			t.Errorf("Expected the original when the rounded width is wider, got %+v, %v\n", resized, err)
+		}
+		if dirs, _ := ioutil.ReadDir(DefaultSite.imageCacheDir()); len(dirs) != 1 || dirs[0].Name() != "150" {
+			t.Errorf("Expected only the thumbnail size cached, got %v\n", dirs)
+		}
+
+		w = get("?size=medium")
+		if original, err := png.DecodeConfig(w.Body); err != nil || original.Width != 300 {
+			t.Errorf("Expected the original when it's narrower, got %+v, %v\n", original, err)
+		}
+		for _, query := range []string{"?w=0", "?w=abc", "?w=99999", "?size=huge"} {
+			if w = get(query); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s, got %d\n", query, w.Code)
+			}
+		}
+
+		err = DeleteImage(img.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(DefaultSite.derivativePath(img.Filename, 150)); !os.IsNotExist(err) {
+			t.Errorf("Expected the cached sizes to be deleted, got %v\n", err)
+		}
+	})
+}
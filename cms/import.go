@@ -0,0 +1,738 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ImportItem is a page or post read from a WordPress export or a Markdown
+// file, ready to be imported with Import.
+type ImportItem struct {
+	Kind string
+	// Source says where the item came from, for the report
+	Source  string
+	Title   string
+	Slug    string
+	Content string
+	Status  string
+	Author  string
+	// Date is when a page was created or a post published
+	Date time.Time
+	// PublishAt schedules a post that isn't published yet
+	PublishAt time.Time
+	Tags      []string
+	// Categories are paths, like "recipes/desserts"
+	Categories []string
+	// FeaturedImage is the URL or path of a post's featured image
+	FeaturedImage string
+	Comments      []*ImportComment
+	// Dir is the directory relative image paths in the content are in
+	Dir string
+}
+
+// ImportComment is a comment on an ImportItem.
+type ImportComment struct {
+	// Key and ParentKey identify the comment and the one it replies to in
+	// the source
+	Key       string
+	ParentKey string
+	Author    string
+	Comment   string
+	Status    string
+	IP        string
+	Date      time.Time
+}
+
+// ImportResult is what Import did, or would do, with an item.
+type ImportResult struct {
+	Kind   string
+	Slug   string
+	Source string
+	// Exists is true for an item that was already imported, and was left
+	// alone but for comments it didn't have yet
+	Exists bool
+}
+
+// ImportReport sums up an import.
+type ImportReport struct {
+	DryRun bool
+	Items  []ImportResult
+	// Comments and Images count what was added; the Existing counts what
+	// had been imported before
+	Comments         int
+	ExistingComments int
+	Images           int
+	ExistingImages   int
+	// Missing are the images referred to whose files weren't found. The
+	// references are left as they were.
+	Missing []string
+	// Authors are who the items are credited to. Each needs a user to log
+	// in as.
+	Authors []string
+}
+
+// Print writes the report for people to read.
+func (r *ImportReport) Print(w io.Writer) {
+	verb := map[bool]string{false: "import", true: "exists"}
+	if r.DryRun {
+		fmt.Fprintln(w, "Dry run, nothing was imported.")
+	}
+	created := 0
+	for _, item := range r.Items {
+		fmt.Fprintf(w, "%-6s %-4s %-40s %s\n", verb[item.Exists], item.Kind, item.Slug, item.Source)
+		if !item.Exists {
+			created++
+		}
+	}
+	done := map[bool]string{false: "imported", true: "to import"}[r.DryRun]
+	fmt.Fprintf(w, "%d pages and posts %s, %d already there.\n", created, done, len(r.Items)-created)
+	fmt.Fprintf(w, "%d comments %s, %d already there.\n", r.Comments, done, r.ExistingComments)
+	fmt.Fprintf(w, "%d images %s, %d already uploaded.\n", r.Images, done, r.ExistingImages)
+	for _, missing := range r.Missing {
+		fmt.Fprintf(w, "Missing image: %s\n", missing)
+	}
+	if len(r.Authors) > 0 {
+		fmt.Fprintf(w, "Authors: %s\n", strings.Join(r.Authors, ", "))
+	}
+}
+
+// imageRef finds the images in Markdown and HTML content: the URL is the
+// first or second submatch
+var imageRef = regexp.MustCompile(`!\[[^\]]*\]\(([^)\s]+)|(?:src|href)=["']([^"']+)["']`)
+
+// imageExts are the file extensions of references that are taken to be
+// images
+var imageExts = map[string]bool{".gif": true, ".jpg": true, ".jpeg": true, ".png": true}
+
+// wpUploads is where a WordPress site keeps its uploads
+const wpUploads = "/wp-content/uploads/"
+
+// Import adds the items to the site, along with their terms, comments and
+// the images their content refers to, which are uploaded to the media library
+// and linked to there instead. uploads is a copy of the WordPress site's
+// wp-content/uploads directory, for the images in a WordPress export.
+//
+// Running it again is safe: an item is matched to what's stored by its slug,
+// and only its missing comments are imported, and an image already in the
+// media library with the same content is used rather than uploaded again.
+// With dryRun nothing is changed, and the report says what would be.
+func (site *Site) Import(items []*ImportItem, uploads string, dryRun bool) (*ImportReport, error) {
+	im := &importer{
+		site:    site,
+		report:  &ImportReport{DryRun: dryRun},
+		uploads: uploads,
+		images:  map[string]*Image{},
+	}
+	authors := map[string]bool{}
+	for _, item := range items {
+		err := im.importItem(item)
+		if err != nil {
+			return im.report, fmt.Errorf("cms: importing %s: %s", item.Source, err)
+		}
+		if item.Author != "" && !authors[item.Author] {
+			authors[item.Author] = true
+			im.report.Authors = append(im.report.Authors, item.Author)
+		}
+	}
+	sort.Strings(im.report.Authors)
+	return im.report, nil
+}
+
+// importer is an Import in progress.
+type importer struct {
+	site    *Site
+	report  *ImportReport
+	uploads string
+	// images are the uploads by their path, so each is only uploaded once.
+	// Dry runs remember nil.
+	images map[string]*Image
+	// library is the media library, read when it's first needed, and sums
+	// are the SHA-256 sums of its files, by file name
+	library []*Image
+	sums    map[string][]byte
+}
+
+func (im *importer) importItem(item *ImportItem) error {
+	if item.Kind != KindPage && item.Kind != KindPost {
+		return ErrBadKind
+	}
+	slug := baseSlug(item.Kind, item.Slug, item.Title)
+	id, err := im.existing(item.Kind, slug)
+	if err != nil {
+		return err
+	}
+	im.report.Items = append(im.report.Items, ImportResult{
+		Kind:   item.Kind,
+		Slug:   slug,
+		Source: item.Source,
+		Exists: id != 0,
+	})
+	if id != 0 {
+		if item.Kind == KindPost {
+			return im.importComments(id, item.Comments)
+		}
+		return nil
+	}
+
+	content, err := im.rewriteImages(item, item.Content)
+	if err != nil {
+		return err
+	}
+	featured, err := im.image(item, item.FeaturedImage)
+	if err != nil {
+		return err
+	}
+	if im.report.DryRun {
+		im.report.Comments += len(item.Comments)
+		return nil
+	}
+
+	if item.Kind == KindPage {
+		id, err = im.site.CreatePage(&Page{
+			Slug:        slug,
+			Title:       item.Title,
+			Content:     content,
+			Status:      item.Status,
+			Author:      item.Author,
+			DateCreated: item.Date,
+		})
+	} else {
+		p := &Post{
+			Slug:          slug,
+			Title:         item.Title,
+			Content:       content,
+			Status:        item.Status,
+			Author:        item.Author,
+			DatePublished: item.Date,
+			PublishAt:     item.PublishAt,
+		}
+		if featured != nil {
+			p.FeaturedImageID = featured.ID
+		}
+		id, err = im.site.CreatePost(p)
+	}
+	if err == nil && (len(item.Tags) > 0 || len(item.Categories) > 0) {
+		err = im.site.SetTerms(item.Kind, id, item.Tags, item.Categories)
+	}
+	if err == nil && item.Kind == KindPost {
+		err = im.importComments(id, item.Comments)
+	}
+	return err
+}
+
+// existing returns the ID of the page or post with the slug, or 0 if there
+// isn't one.
+func (im *importer) existing(kind, slug string) (int, error) {
+	var id int
+	var err error
+	if kind == KindPage {
+		var p *Page
+		if p, err = im.site.store.GetPageBySlug(slug); err == nil {
+			id = p.ID
+		}
+	} else {
+		var p *Post
+		if p, err = im.site.store.GetPostBySlug(slug); err == nil {
+			id = p.ID
+		}
+	}
+	if err == ErrNotFound {
+		return 0, nil
+	}
+	return id, err
+}
+
+// importComments adds the comments a post doesn't have yet, which are those
+// without a comment by the same author at the same time saying the same.
+// Replies are added after what they reply to.
+func (im *importer) importComments(postID int, comments []*ImportComment) error {
+	stored, err := im.site.store.GetComments(postID, "")
+	if err != nil {
+		return err
+	}
+	sorted := append([]*ImportComment{}, comments...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Date.Before(sorted[j].Date) })
+
+	ids := map[string]int{}
+	for _, c := range sorted {
+		date := c.Date.UTC().Truncate(time.Microsecond)
+		found := 0
+		for _, s := range stored {
+			if s.Author == c.Author && s.Comment == c.Comment && s.DatePublished.Equal(date) {
+				found = s.ID
+				break
+			}
+		}
+		if found != 0 {
+			ids[c.Key] = found
+			im.report.ExistingComments++
+			continue
+		}
+		im.report.Comments++
+		if im.report.DryRun {
+			continue
+		}
+		id, err := im.site.CreateComment(&Comment{
+			PostID: postID,
+			// A reply to a comment that wasn't imported starts a thread
+			ParentID:      ids[c.ParentKey],
+			Author:        c.Author,
+			Comment:       c.Comment,
+			Status:        c.Status,
+			IP:            c.IP,
+			DatePublished: date,
+		})
+		if err != nil {
+			return err
+		}
+		ids[c.Key] = id
+	}
+	return nil
+}
+
+// rewriteImages uploads the images the content refers to, and links to them
+// in the media library instead.
+func (im *importer) rewriteImages(item *ImportItem, content string) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, m := range imageRef.FindAllStringSubmatchIndex(content, -1) {
+		start, end := m[2], m[3]
+		if start < 0 {
+			start, end = m[4], m[5]
+		}
+		img, err := im.image(item, content[start:end])
+		if err != nil {
+			return "", err
+		}
+		if img != nil {
+			b.WriteString(content[last:start])
+			b.WriteString(img.URL())
+			last = end
+		}
+	}
+	b.WriteString(content[last:])
+	return b.String(), nil
+}
+
+// image uploads the image at ref, unless it's already in the media library.
+// It returns nil for a reference to something other than a local image, or
+// to one that's missing, and on dry runs.
+func (im *importer) image(item *ImportItem, ref string) (*Image, error) {
+	path := im.localPath(item, ref)
+	if path == "" {
+		return nil, nil
+	}
+	if img, ok := im.images[path]; ok {
+		return img, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		im.report.Missing = append(im.report.Missing, ref)
+		im.images[path] = nil
+		return nil, nil
+	}
+
+	img, err := im.uploaded(path, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if img != nil {
+		im.report.ExistingImages++
+		im.images[path] = img
+		return img, nil
+	}
+	im.report.Images++
+	if im.report.DryRun {
+		im.images[path] = nil
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err = im.site.SaveImage(filepath.Base(path), f, "", item.Author)
+	if err != nil {
+		return nil, err
+	}
+	im.images[path] = img
+	im.library = append(im.library, img)
+	return img, nil
+}
+
+// uploaded returns the image in the media library with the same content as
+// the file at path, or nil if there isn't one. It's found by its SHA-256 sum
+// rather than its name, since SaveImage numbers a name that's taken.
+func (im *importer) uploaded(path string, size int64) (*Image, error) {
+	if im.sums == nil {
+		images, err := im.site.store.GetImages()
+		if err != nil {
+			return nil, err
+		}
+		im.library = images
+		im.sums = map[string][]byte{}
+	}
+	var sum []byte
+	for _, img := range im.library {
+		if img.Size != size {
+			continue
+		}
+		if sum == nil {
+			var err error
+			if sum, err = fileSum(path); err != nil {
+				return nil, err
+			}
+		}
+		stored, ok := im.sums[img.Filename]
+		if !ok {
+			// A file that's gone can't match
+			stored, _ = fileSum(filepath.Join(im.site.imageDir(), img.Filename))
+			im.sums[img.Filename] = stored
+		}
+		if bytes.Equal(stored, sum) {
+			return img, nil
+		}
+	}
+	return nil, nil
+}
+
+// fileSum returns the SHA-256 sum of a file.
+func fileSum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// localPath finds the file an image reference is to: in the uploads
+// directory for a WordPress upload, or next to the item for a relative path.
+// It returns "" for anything else, including paths that lead out of those
+// directories.
+func (im *importer) localPath(item *ImportItem, ref string) string {
+	ref = strings.SplitN(strings.SplitN(ref, "?", 2)[0], "#", 2)[0]
+	if !imageExts[strings.ToLower(filepath.Ext(ref))] {
+		return ""
+	}
+	if i := strings.Index(ref, wpUploads); i >= 0 && im.uploads != "" {
+		return within(im.uploads, ref[i+len(wpUploads):])
+	}
+	if item.Dir == "" || strings.Contains(ref, ":") || strings.HasPrefix(ref, "/") {
+		return ""
+	}
+	return within(item.Dir, ref)
+}
+
+// within joins a slash separated path onto dir, returning "" if it leads out
+// of dir.
+func within(dir, ref string) string {
+	path := filepath.Join(dir, filepath.FromSlash(ref))
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return path
+}
+
+// wxr is the part of a WordPress export that's imported. Elements are matched
+// by their local name, since the wp namespace changes with the version.
+type wxr struct {
+	Categories []struct {
+		Slug   string `xml:"category_nicename"`
+		Parent string `xml:"category_parent"`
+		Name   string `xml:"cat_name"`
+	} `xml:"channel>category"`
+	Items []wxrItem `xml:"channel>item"`
+}
+
+type wxrItem struct {
+	Title   string `xml:"title"`
+	Creator string `xml:"creator"`
+	Content string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	ID      string `xml:"post_id"`
+	DateGMT string `xml:"post_date_gmt"`
+	Date    string `xml:"post_date"`
+	Slug    string `xml:"post_name"`
+	Status  string `xml:"status"`
+	Type    string `xml:"post_type"`
+	// AttachmentURL is where an attachment was uploaded to
+	AttachmentURL string `xml:"attachment_url"`
+	Terms         []struct {
+		Domain string `xml:"domain,attr"`
+		Slug   string `xml:"nicename,attr"`
+		Name   string `xml:",chardata"`
+	} `xml:"category"`
+	Meta []struct {
+		Key   string `xml:"meta_key"`
+		Value string `xml:"meta_value"`
+	} `xml:"postmeta"`
+	Comments []struct {
+		ID       string `xml:"comment_id"`
+		Author   string `xml:"comment_author"`
+		IP       string `xml:"comment_author_IP"`
+		DateGMT  string `xml:"comment_date_gmt"`
+		Date     string `xml:"comment_date"`
+		Content  string `xml:"comment_content"`
+		Approved string `xml:"comment_approved"`
+		Type     string `xml:"comment_type"`
+		Parent   string `xml:"comment_parent"`
+	} `xml:"comment"`
+}
+
+// wxrStatuses maps WordPress statuses onto ours. Posts scheduled for the
+// future are published with a PublishAt, which keeps them in review. Items
+// with any other status, like auto-drafts and the trash, aren't imported.
+var wxrStatuses = map[string]string{
+	"publish": StatusPublished,
+	"future":  StatusPublished,
+	"draft":   StatusDraft,
+	"private": StatusDraft,
+	"pending": StatusReview,
+}
+
+// wxrCommentStatuses maps the comment_approved of WordPress comments onto
+// comment statuses.
+var wxrCommentStatuses = map[string]string{
+	"1":     CommentApproved,
+	"0":     CommentPending,
+	"spam":  CommentSpam,
+	"trash": CommentRejected,
+}
+
+// wxrTime reads a WordPress date, preferring the one in GMT. Drafts have
+// zeros for dates, which are returned as the zero time.
+func wxrTime(gmt, local string) time.Time {
+	for _, s := range []string{gmt, local} {
+		t, err := time.Parse("2006-01-02 15:04:05", strings.TrimSpace(s))
+		if err == nil && t.Year() > 1 {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ReadWXR reads the posts and pages in a WordPress export, with their
+// comments, tags, categories and featured images. Authors are their
+// WordPress user names. Attachments, menus and everything else are left out,
+// though the images in the content are imported along with it.
+func ReadWXR(r io.Reader) ([]*ImportItem, error) {
+	var doc wxr
+	d := xml.NewDecoder(r)
+	// Exports are UTF-8 in practice, whatever their header says
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	err := d.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Categories are imported by their path of names
+	names, parents := map[string]string{}, map[string]string{}
+	for _, c := range doc.Categories {
+		names[c.Slug], parents[c.Slug] = c.Name, c.Parent
+	}
+	categoryPath := func(slug, name string) string {
+		path := name
+		for seen := map[string]bool{slug: true}; parents[slug] != "" && !seen[parents[slug]]; {
+			slug = parents[slug]
+			seen[slug] = true
+			path = names[slug] + "/" + path
+		}
+		return path
+	}
+	attachments := map[string]string{}
+	for _, it := range doc.Items {
+		if it.Type == "attachment" {
+			attachments[it.ID] = it.AttachmentURL
+		}
+	}
+
+	items := []*ImportItem{}
+	for _, it := range doc.Items {
+		status, ok := wxrStatuses[it.Status]
+		if !ok || (it.Type != KindPage && it.Type != KindPost) {
+			continue
+		}
+		item := &ImportItem{
+			Kind:    it.Type,
+			Source:  "wordpress " + it.Type + " " + it.ID,
+			Title:   it.Title,
+			Slug:    it.Slug,
+			Content: it.Content,
+			Status:  status,
+			Author:  it.Creator,
+			Date:    wxrTime(it.DateGMT, it.Date),
+		}
+		if it.Status == "future" {
+			item.PublishAt = item.Date
+		}
+		for _, t := range it.Terms {
+			switch t.Domain {
+			case "post_tag":
+				item.Tags = append(item.Tags, t.Name)
+			case "category":
+				item.Categories = append(item.Categories, categoryPath(t.Slug, t.Name))
+			}
+		}
+		for _, m := range it.Meta {
+			if m.Key == "_thumbnail_id" {
+				item.FeaturedImage = attachments[m.Value]
+			}
+		}
+		for _, c := range it.Comments {
+			status, ok := wxrCommentStatuses[c.Approved]
+			// Pingbacks and trackbacks aren't comments readers left
+			if !ok || (c.Type != "" && c.Type != "comment") {
+				continue
+			}
+			parent := c.Parent
+			if parent == "0" {
+				parent = ""
+			}
+			item.Comments = append(item.Comments, &ImportComment{
+				Key:       c.ID,
+				ParentKey: parent,
+				Author:    c.Author,
+				Comment:   c.Content,
+				Status:    status,
+				IP:        c.IP,
+				Date:      wxrTime(c.DateGMT, c.Date),
+			})
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// frontMatter is the YAML at the top of a Markdown file.
+type frontMatter struct {
+	Title string
+	Slug  string
+	// Type is "page" or "post", the default
+	Type       string
+	Status     string
+	Date       string
+	Author     string
+	Tags       []string
+	Categories []string
+	// Image is the path of a post's featured image
+	Image string
+}
+
+// frontMatterDates are the layouts front matter dates can be in
+var frontMatterDates = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// ReadMarkdownDir reads every Markdown file in dir and the directories in it.
+// Each file is a post, or a page if its front matter says so. The front
+// matter is YAML between lines of "---" at the top, and has the title, slug,
+// type, status, date, author, tags and categories, and the image featured
+// with a post. The slug defaults to the file's name, and the status to
+// published.
+func ReadMarkdownDir(dir string) ([]*ImportItem, error) {
+	items := []*ImportItem{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if info.IsDir() || (ext != ".md" && ext != ".markdown") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		item, err := readMarkdown(path, data)
+		if err != nil {
+			return fmt.Errorf("cms: reading %s: %s", path, err)
+		}
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// readMarkdown reads a Markdown file with front matter.
+func readMarkdown(path string, data []byte) (*ImportItem, error) {
+	var fm frontMatter
+	content := bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	if bytes.HasPrefix(content, []byte("---\n")) {
+		end := bytes.Index(content[4:], []byte("\n---"))
+		if end < 0 {
+			return nil, fmt.Errorf("front matter isn't closed with ---")
+		}
+		err := yaml.Unmarshal(content[4:4+end], &fm)
+		if err != nil {
+			return nil, err
+		}
+		content = content[4+end+4:]
+		// The rest of the closing line
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			content = content[i+1:]
+		} else {
+			content = nil
+		}
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	item := &ImportItem{
+		Kind:          KindPost,
+		Source:        path,
+		Title:         fm.Title,
+		Slug:          fm.Slug,
+		Content:       strings.TrimSpace(string(content)),
+		Status:        fm.Status,
+		Author:        fm.Author,
+		Tags:          fm.Tags,
+		Categories:    fm.Categories,
+		FeaturedImage: fm.Image,
+		Dir:           filepath.Dir(path),
+	}
+	if fm.Type != "" {
+		item.Kind = fm.Type
+	}
+	if item.Kind != KindPage && item.Kind != KindPost {
+		return nil, fmt.Errorf("unknown type %q", fm.Type)
+	}
+	if item.Title == "" {
+		item.Title = name
+	}
+	if item.Slug == "" {
+		item.Slug = name
+	}
+	if item.Status == "" {
+		item.Status = StatusPublished
+	}
+	if fm.Date != "" {
+		var err error
+		for _, layout := range frontMatterDates {
+			item.Date, err = time.Parse(layout, fm.Date)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unknown date format %q", fm.Date)
+		}
+		item.Date = item.Date.UTC()
+	}
+	return item, nil
+}
@@ -0,0 +1,343 @@
+package cms
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testWXR = `<?xml version="1.0" encoding="UTF-8" ?>
+<rss version="2.0"
+	xmlns:content="http://purl.org/rss/1.0/modules/content/"
+	xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns:wp="http://wordpress.org/export/1.2/">
+<channel>
+	<wp:category><wp:category_nicename>food</wp:category_nicename><wp:category_parent></wp:category_parent><wp:cat_name><![CDATA[Food]]></wp:cat_name></wp:category>
+	<wp:category><wp:category_nicename>desserts</wp:category_nicename><wp:category_parent>food</wp:category_parent><wp:cat_name><![CDATA[Desserts]]></wp:cat_name></wp:category>
+	<item>
+		<title>Cake</title>
+		<dc:creator><![CDATA[ann]]></dc:creator>
+		<content:encoded><![CDATA[Bake it.
+
+<img src="http://old.example.com/wp-content/uploads/2019/04/cake.png" alt="Cake"> <img src="http://old.example.com/wp-content/uploads/gone.png">]]></content:encoded>
+		<wp:post_id>10</wp:post_id>
+		<wp:post_date_gmt>2019-04-01 12:30:00</wp:post_date_gmt>
+		<wp:post_name>cake</wp:post_name>
+		<wp:status>publish</wp:status>
+		<wp:post_type>post</wp:post_type>
+		<category domain="post_tag" nicename="baking"><![CDATA[Baking]]></category>
+		<category domain="category" nicename="desserts"><![CDATA[Desserts]]></category>
+		<wp:postmeta><wp:meta_key>_thumbnail_id</wp:meta_key><wp:meta_value>11</wp:meta_value></wp:postmeta>
+		<wp:comment>
+			<wp:comment_id>1</wp:comment_id>
+			<wp:comment_author><![CDATA[Reader]]></wp:comment_author>
+			<wp:comment_date_gmt>2019-04-02 08:00:00</wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Yum]]></wp:comment_content>
+			<wp:comment_approved>1</wp:comment_approved>
+			<wp:comment_type></wp:comment_type>
+			<wp:comment_parent>0</wp:comment_parent>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>2</wp:comment_id>
+			<wp:comment_author><![CDATA[ann]]></wp:comment_author>
+			<wp:comment_date_gmt>2019-04-02 09:00:00</wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Thanks!]]></wp:comment_content>
+			<wp:comment_approved>1</wp:comment_approved>
+			<wp:comment_type>comment</wp:comment_type>
+			<wp:comment_parent>1</wp:comment_parent>
+		</wp:comment>
+		<wp:comment>
+			<wp:comment_id>3</wp:comment_id>
+			<wp:comment_author><![CDATA[Some blog]]></wp:comment_author>
+			<wp:comment_date_gmt>2019-04-03 09:00:00</wp:comment_date_gmt>
+			<wp:comment_content><![CDATA[Linked]]></wp:comment_content>
+			<wp:comment_approved>1</wp:comment_approved>
+			<wp:comment_type>pingback</wp:comment_type>
+			<wp:comment_parent>0</wp:comment_parent>
+		</wp:comment>
+	</item>
+	<item>
+		<title>cake</title>
+		<wp:post_id>11</wp:post_id>
+		<wp:post_type>attachment</wp:post_type>
+		<wp:status>inherit</wp:status>
+		<wp:attachment_url>http://old.example.com/wp-content/uploads/2019/04/cake.png</wp:attachment_url>
+	</item>
+	<item>
+		<title>About</title>
+		<dc:creator><![CDATA[bob]]></dc:creator>
+		<content:encoded><![CDATA[About us]]></content:encoded>
+		<wp:post_id>12</wp:post_id>
+		<wp:post_date_gmt>0000-00-00 00:00:00</wp:post_date_gmt>
+		<wp:post_date>2019-03-01 10:00:00</wp:post_date>
+		<wp:post_name>about</wp:post_name>
+		<wp:status>draft</wp:status>
+		<wp:post_type>page</wp:post_type>
+	</item>
+	<item>
+		<title>Deleted</title>
+		<wp:post_id>13</wp:post_id>
+		<wp:status>trash</wp:status>
+		<wp:post_type>post</wp:post_type>
+	</item>
+</channel>
+</rss>`
+
+func Test_ReadWXR(t *testing.T) {
+	items, err := ReadWXR(strings.NewReader(testWXR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected the post and the page, got %d items\n", len(items))
+	}
+	post, page := items[0], items[1]
+	if post.Kind != KindPost || post.Slug != "cake" || post.Author != "ann" || post.Status != StatusPublished {
+		t.Errorf("Expected the post, got %+v\n", post)
+	}
+	if !post.Date.Equal(time.Date(2019, 4, 1, 12, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected the post's date, got %s\n", post.Date)
+	}
+	if len(post.Tags) != 1 || post.Tags[0] != "Baking" || len(post.Categories) != 1 || post.Categories[0] != "Food/Desserts" {
+		t.Errorf("Expected the tag and the category's path, got %v %v\n", post.Tags, post.Categories)
+	}
+	if !strings.HasSuffix(post.FeaturedImage, "/2019/04/cake.png") {
+		t.Errorf("Expected the featured image's URL, got %q\n", post.FeaturedImage)
+	}
+	if len(post.Comments) != 2 || post.Comments[1].ParentKey != "1" || post.Comments[1].Status != CommentApproved {
+		t.Errorf("Expected the comment and the reply but not the pingback, got %+v\n", post.Comments)
+	}
+	if page.Kind != KindPage || page.Status != StatusDraft || page.Date.Year() != 2019 {
+		t.Errorf("Expected the draft page dated by its local time, got %+v\n", page)
+	}
+}
+
+func Test_ImportWXR(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+		uploads, err := ioutil.TempDir("", "cms-uploads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(uploads)
+		err = os.MkdirAll(filepath.Join(uploads, "2019", "04"), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(uploads, "2019", "04", "cake.png"), testPNG(t, 4, 4), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		items, err := ReadWXR(strings.NewReader(testWXR))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := Import(items, uploads, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Items) != 2 || report.Comments != 2 || report.Images != 1 || len(report.Missing) != 1 {
+			t.Errorf("Expected the dry run to count everything, got %+v\n", report)
+		}
+		if _, _, err = ResolvePost("cake"); err != ErrNotFound {
+			t.Fatalf("Expected a dry run to import nothing, got %v\n", err)
+		}
+
+		report, err = Import(items, uploads, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		post, _, err := ResolvePost("cake")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(post.Content, `src="/image/cake.png"`) || !strings.Contains(post.Content, "uploads/gone.png") {
+			t.Errorf("Expected the image linked to the media library, got %q\n", post.Content)
+		}
+		img, err := DefaultSite.store.GetImageByName("cake.png")
+		if err != nil || post.FeaturedImageID != img.ID || img.Uploader != "ann" {
+			t.Errorf("Expected the uploaded image featured, got %d, %+v, %v\n", post.FeaturedImageID, img, err)
+		}
+		if !post.DatePublished.Equal(items[0].Date) || post.Author != "ann" {
+			t.Errorf("Expected the post's date and author kept, got %+v\n", post)
+		}
+		if err = LoadPostTerms(post); err != nil || len(post.Tags) != 1 || len(post.Categories) != 1 || post.Categories[0].Path != "food/desserts" {
+			t.Errorf("Expected the post's terms, got %+v %+v, %v\n", post.Tags, post.Categories, err)
+		}
+		comments, err := GetComments(post.ID)
+		if err != nil || len(comments) != 1 || len(comments[0].Replies) != 1 {
+			t.Errorf("Expected the comment with its reply, got %+v, %v\n", comments, err)
+		}
+		if page, _, err := ResolvePage("about"); err != nil || page.Status != StatusDraft || page.Author != "bob" {
+			t.Errorf("Expected the draft page, got %+v, %v\n", page, err)
+		}
+		if len(report.Authors) != 2 || report.Authors[0] != "ann" {
+			t.Errorf("Expected both authors reported, got %v\n", report.Authors)
+		}
+
+		report, err = Import(items, uploads, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Items[0].Exists || !report.Items[1].Exists || report.Comments != 0 || report.ExistingComments != 2 {
+			t.Errorf("Expected importing again to change nothing, got %+v\n", report)
+		}
+		posts, err := GetPosts("", 0)
+		if err != nil || len(posts) != 1 {
+			t.Errorf("Expected one post after importing twice, got %d, %v\n", len(posts), err)
+		}
+		if images, err := GetImages(); err != nil || len(images) != 1 {
+			t.Errorf("Expected the image uploaded once, got %+v, %v\n", images, err)
+		}
+		var out bytes.Buffer
+		report.Print(&out)
+		if !strings.Contains(out.String(), "0 pages and posts imported, 2 already there") {
+			t.Errorf("Expected the report to say nothing was imported, got %s\n", out.String())
+		}
+	})
+}
+
+func Test_ImportMarkdown(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+		dir, err := ioutil.TempDir("", "cms-notes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		files := map[string]string{
+			"first-note.md":  "---\r\ntitle: First note\r\ndate: 2018-06-01\r\ntags: [go, notes]\r\nauthor: ann\r\n---\r\nSee ![a diagram](img/diagram.png).\r\n",
+			"sub/about.md":   "---\ntype: page\nslug: about-me\nstatus: draft\n---\n\nAll about me",
+			"bare.markdown":  "No front matter",
+			"img/readme.txt": "not Markdown",
+		}
+		for name, content := range files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, "img", "diagram.png"), testPNG(t, 2, 2), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		items, err := ReadMarkdownDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 3 {
+			t.Fatalf("Expected the three Markdown files, got %d\n", len(items))
+		}
+		_, err = Import(items, "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		note, _, err := ResolvePost("first-note")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if note.Title != "First note" || note.Content != "See ![a diagram](/image/diagram.png)." || note.DatePublished.Year() != 2018 {
+			t.Errorf("Expected the note from its front matter, got %+v\n", note)
+		}
+		if err = LoadPostTerms(note); err != nil || len(note.Tags) != 2 {
+			t.Errorf("Expected the note's tags, got %+v, %v\n", note.Tags, err)
+		}
+		if page, _, err := ResolvePage("about-me"); err != nil || page.Content != "All about me" || page.Status != StatusDraft {
+			t.Errorf("Expected the page, got %+v, %v\n", page, err)
+		}
+		if bare, _, err := ResolvePost("bare"); err != nil || bare.Title != "bare" || !bare.Published() {
+			t.Errorf("Expected a published post named after the file, got %+v, %v\n", bare, err)
+		}
+
+		report, err := Import(items, "", false)
+		if err != nil || report.Images != 0 {
+			t.Fatalf("Expected nothing to import again, got %+v, %v\n", report, err)
+		}
+		for _, item := range report.Items {
+			if !item.Exists {
+				t.Errorf("Expected %s to exist already\n", item.Source)
+			}
+		}
+		if _, err := readMarkdown("bad.md", []byte("---\ntype: widget\n---\n")); err == nil {
+			t.Errorf("Expected an unknown type to be refused\n")
+		}
+		if _, err := readMarkdown("bad.md", []byte("---\ntitle: Open\n")); err == nil {
+			t.Errorf("Expected unclosed front matter to be refused\n")
+		}
+	})
+}
+
+func Test_ImportImages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+		root, err := ioutil.TempDir("", "cms-import")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		files := map[string][]byte{
+			"uploads/2019/01/photo.png": testPNG(t, 3, 3),
+			"uploads/2020/05/photo.png": testPNG(t, 5, 5),
+			"notes/a.png":               testPNG(t, 6, 6),
+			"notes/banana.png":          testPNG(t, 7, 7),
+			"secret.png":                testPNG(t, 8, 8),
+		}
+		for name, data := range files {
+			path := filepath.Join(root, filepath.FromSlash(name))
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err = ioutil.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		item := func(slug string) *ImportItem {
+			return &ImportItem{
+				Kind: KindPost,
+				Slug: slug,
+				Content: `<img src="http://old.example.com/wp-content/uploads/2019/01/photo.png"> ` +
+					`<img src="http://old.example.com/wp-content/uploads/2020/05/photo.png"> ` +
+					`<img src="http://old.example.com/wp-content/uploads/../secret.png"> ` +
+					"![A](banana.png) ![B](a.png) ![C](../secret.png)",
+				Status: StatusPublished,
+				Dir:    filepath.Join(root, "notes"),
+			}
+		}
+		uploads := filepath.Join(root, "uploads")
+
+		_, err = Import([]*ImportItem{item("first")}, uploads, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		post, _, err := ResolvePost("first")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `<img src="/image/photo.png"> <img src="/image/photo-2.png"> ` +
+			`<img src="http://old.example.com/wp-content/uploads/../secret.png"> ` +
+			"![A](/image/banana.png) ![B](/image/a.png) ![C](../secret.png)"
+		if post.Content != want {
+			t.Errorf("Expected the images linked to the media library, got %q\n", post.Content)
+		}
+
+		// Another post with the same images uses the uploads already there
+		report, err := Import([]*ImportItem{item("first"), item("second")}, uploads, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Images != 0 || report.ExistingImages != 4 {
+			t.Errorf("Expected the images found in the media library, got %+v\n", report)
+		}
+		if images, err := GetImages(); err != nil || len(images) != 4 {
+			t.Errorf("Expected four images uploaded once each, got %+v, %v\n", images, err)
+		}
+		if post, _, err = ResolvePost("second"); err != nil || post.Content != want {
+			t.Errorf("Expected the second post to link to the same images, got %+v, %v\n", post, err)
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package cms
+
+import (
+	"html/template"
+
+	"github.com/russross/blackfriday"
+)
+
+// Markdown renders page and post content, written in Markdown, to HTML that
+// is safe to put straight into a template. HTML written by hand is sanitized
+// like the rest, and shortcodes are expanded, leaving out any that fail.
+func (site *Site) Markdown(src string) template.HTML {
+	return site.markdown(src, false)
+}
+
+// markdown is the markdown template func. Passing it true, as in
+// {{ markdown .Content .Preview }}, shows what's wrong with the shortcodes
+// that can't be expanded rather than leaving them out.
+func (site *Site) markdown(src string, preview ...bool) template.HTML {
+	src, expanded := site.expandShortcodes(src, len(preview) > 0 && preview[0])
+	out := blackfriday.Run([]byte(src), blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	return template.HTML(expanded.Replace(Sanitize(string(out))))
+}
@@ -0,0 +1,48 @@
+package cms
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Markdown(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"# Title", "<h1>Title</h1>"},
+		{"- one\n- two", "<li>one</li>"},
+		{"```go\nfmt.Println()\n```", `<code class="language-go">`},
+		{"[link](https://example.com)", `<a href="https://example.com" rel="nofollow">link</a>`},
+		{"![alt](/image/a.jpg)", `<img src="/image/a.jpg" alt="alt">`},
+	}
+	for _, tt := range tests {
+		got := string(Markdown(tt.in))
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("Markdown(%q) = %q, expected it to contain %q\n", tt.in, got, tt.want)
+		}
+	}
+}
+
+func Test_Sanitize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`<script>alert(1)</script>hi`, "hi"},
+		{`<p onclick="alert(1)">hi</p>`, "<p>hi</p>"},
+		{`<a href="javascript:alert(1)">hi</a>`, `<a rel="nofollow">hi</a>`},
+		{`<img src="data:text/html;base64,AAAA" alt="x">`, `<img alt="x">`},
+		{`<code class="x onload">hi</code>`, "<code>hi</code>"},
+		{`<div><em>hi</div>`, "<em>hi</em>"},
+		{`<em>unclosed`, "<em>unclosed</em>"},
+		{`</p>stray`, "stray"},
+		{`&lt;script&gt;`, "&lt;script&gt;"},
+	}
+	for _, tt := range tests {
+		got := Sanitize(tt.in)
+		if got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, expected %q\n", tt.in, got, tt.want)
+		}
+	}
+}
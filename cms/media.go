@@ -0,0 +1,341 @@
+package cms
+
+import (
+	"errors"
+	// Register the formats uploads can be in
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImageDir is where uploaded images are kept, for sites without an ImageDir
+// of their own. They're served at /image/{filename}.
+var ImageDir = "images"
+
+// ErrNotImage is returned for an upload that isn't a GIF, JPEG or PNG image.
+var ErrNotImage = errors.New("cms: only images can be uploaded")
+
+// ErrBadFilename is returned for an upload without a usable file name.
+var ErrBadFilename = errors.New("cms: invalid file name")
+
+// ErrImageTooBig is returned for an upload of more than MaxImageBytes, or an
+// image of more than MaxImagePixels.
+var ErrImageTooBig = errors.New("cms: the image is too big")
+
+// ErrFilenameTaken is returned when an image is saved with the file name of
+// another.
+var ErrFilenameTaken = errors.New("cms: the file name is taken")
+
+// ErrNoImage is returned for a featured image that isn't in the media
+// library.
+var ErrNoImage = errors.New("cms: no such image")
+
+// Image is an uploaded image in the media library.
+type Image struct {
+	ID       int
+	Filename string
+	MIMEType string
+	// Width and Height are in pixels, the right way up
+	Width  int
+	Height int
+	Size   int64
+	// Alt describes the image for readers who can't see it
+	Alt         string
+	Uploader    string
+	DateCreated time.Time
+}
+
+// URL is the link the image is served at.
+func (img *Image) URL() string {
+	return "/image/" + img.Filename
+}
+
+// SizeURL is the link to the image at one of the ImageSizes.
+func (img *Image) SizeURL(size string) string {
+	return img.URL() + "?size=" + size
+}
+
+// MediaStore stores what's known about the uploaded images. The images
+// themselves are files in ImageDir.
+type MediaStore interface {
+	// CreateImage saves a new image, whose file name must be unused.
+	CreateImage(img *Image) (int, error)
+	GetImage(id int) (*Image, error)
+	GetImageByName(filename string) (*Image, error)
+	// GetImages returns every image, newest first.
+	GetImages() ([]*Image, error)
+	// UpdateImage overwrites the alt text of an image.
+	UpdateImage(img *Image) error
+	// DeleteImage deletes an image, leaving the posts it was the featured
+	// image of without one.
+	DeleteImage(id int) error
+}
+
+// SaveImage adds an upload to the media library, writing it to the site's
+// ImageDir along with its ImageSizes. The file name is made unique by
+// numbering it if it's already taken.
+func (site *Site) SaveImage(filename string, r io.Reader, alt, uploader string) (*Image, error) {
+	filename = filepath.Base(filepath.Clean("/" + filename))
+	if filename == "/" || filename == "." || strings.HasPrefix(filename, ".") {
+		return nil, ErrBadFilename
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > MaxImageBytes {
+		return nil, ErrImageTooBig
+	}
+	// Decoding the whole image makes sure it really is one
+	decoded, format, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{
+		MIMEType: "image/" + format,
+		Width:    decoded.Bounds().Dx(),
+		Height:   decoded.Bounds().Dy(),
+		Size:     int64(len(data)),
+		Alt:      alt,
+		Uploader: uploader,
+	}
+
+	err = os.MkdirAll(site.imageDir(), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, name, err := site.createUnique(filename)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		img.Filename = name
+		img.ID, err = site.store.CreateImage(img)
+	}
+	if err != nil {
+		os.Remove(filepath.Join(site.imageDir(), name))
+		return nil, err
+	}
+	for _, width := range ImageSizes {
+		_, err = site.makeDerivative(name, decoded, format, width)
+		if err != nil {
+			// ServeImage makes it again when it's first asked for
+			log.Printf("cms: resizing %s to %d: %s", name, width, err)
+		}
+	}
+	return img, nil
+}
+
+// createUnique creates a new file in the site's ImageDir, numbering the name
+// until it finds one that isn't taken.
+func (site *Site) createUnique(filename string) (*os.File, string, error) {
+	ext := filepath.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	name := filename
+	for i := 2; ; i++ {
+		f, err := os.OpenFile(filepath.Join(site.imageDir(), name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return f, name, err
+		}
+		name = base + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+// GetImages returns the media library, newest first.
+func (site *Site) GetImages() ([]*Image, error) {
+	return site.store.GetImages()
+}
+
+// GetImage returns an image by its ID.
+func (site *Site) GetImage(id int) (*Image, error) {
+	return site.store.GetImage(id)
+}
+
+// SetImageAlt changes the alt text of an image.
+func (site *Site) SetImageAlt(id int, alt string) error {
+	img, err := site.store.GetImage(id)
+	if err != nil {
+		return err
+	}
+	img.Alt = alt
+	return site.store.UpdateImage(img)
+}
+
+// DeleteImage removes an image from the media library and deletes its file.
+func (site *Site) DeleteImage(id int) error {
+	img, err := site.store.GetImage(id)
+	if err != nil {
+		return err
+	}
+	err = site.store.DeleteImage(id)
+	if err != nil {
+		return err
+	}
+	site.removeDerivatives(img.Filename)
+	err = os.Remove(filepath.Join(site.imageDir(), img.Filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// OrphanedImages returns the images that no page or post links to or shows
+// with a shortcode, and that aren't the featured image of a post. Pages in
+// the trash still count, since they can be restored.
+func (site *Site) OrphanedImages() ([]*Image, error) {
+	images, err := site.store.GetImages()
+	if err != nil {
+		return nil, err
+	}
+	pages, err := site.store.GetPages()
+	if err != nil {
+		return nil, err
+	}
+	trash, err := site.store.GetTrash()
+	if err != nil {
+		return nil, err
+	}
+	posts, err := site.store.GetPosts("", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var content []string
+	used := map[int]bool{}
+	for _, p := range append(pages, trash...) {
+		content = append(content, p.Content)
+	}
+	for _, p := range posts {
+		content = append(content, p.Content)
+		used[p.FeaturedImageID] = true
+	}
+	all := strings.Join(content, "\n")
+	for _, id := range shortcodeImageIDs(all) {
+		used[id] = true
+	}
+
+	orphans := []*Image{}
+	for _, img := range images {
+		if !used[img.ID] && !linksTo(all, img.URL()) {
+			orphans = append(orphans, img)
+		}
+	}
+	return orphans, nil
+}
+
+// linksTo reports whether content links to url, and not just to a longer URL
+// starting with it.
+func linksTo(content, url string) bool {
+	for {
+		i := strings.Index(content, url)
+		if i < 0 {
+			return false
+		}
+		content = content[i+len(url):]
+		if content == "" || strings.IndexByte(")\"' <>?#\n\t", content[0]) >= 0 {
+			return true
+		}
+	}
+}
+
+// checkFeaturedImage makes sure a post's featured image is in the media
+// library, when it has one.
+func (site *Site) checkFeaturedImage(p *Post) error {
+	if p.FeaturedImageID == 0 {
+		return nil
+	}
+	_, err := site.store.GetImage(p.FeaturedImageID)
+	if err == ErrNotFound {
+		return ErrNoImage
+	}
+	return err
+}
+
+// LoadFeaturedImages fills in the FeaturedImage of posts that have one.
+func (site *Site) LoadFeaturedImages(posts ...*Post) error {
+	for _, p := range posts {
+		if p.FeaturedImageID == 0 {
+			continue
+		}
+		img, err := site.store.GetImage(p.FeaturedImageID)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		p.FeaturedImage = img
+	}
+	return nil
+}
+
+// ServeImage serves an uploaded image from the site's ImageDir at
+// /image/{filename}. It's resized to one of the ImageSizes with ?size={name},
+// or to a width in pixels with ?w=, up to MaxImageWidth. That's rounded up to
+// one of a few widths, so there's only ever a few sizes of an image cached.
+// Images are never made wider than they are, and resized images are cached in
+// the ImageDir.
+func ServeImage(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	name := strings.TrimPrefix(r.URL.Path, "/image/")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	width := 0
+	if size := r.FormValue("size"); size != "" {
+		width = ImageSizes[size]
+		if width == 0 {
+			http.Error(w, "Unknown size: "+size, http.StatusBadRequest)
+			return
+		}
+	} else if r.FormValue("w") != "" {
+		var err error
+		width, err = strconv.Atoi(r.FormValue("w"))
+		if err != nil || width < 1 || width > MaxImageWidth {
+			http.Error(w, "w must be a width from 1 to "+strconv.Itoa(MaxImageWidth), http.StatusBadRequest)
+			return
+		}
+		width = snapWidth(width)
+	}
+
+	path := filepath.Join(site.imageDir(), name)
+	if width != 0 {
+		resized, err := site.derivative(name, width)
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if resized != "" {
+			path = resized
+		}
+	}
+
+	// The type is sniffed from the file itself, rather than trusted from
+	// its name
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	http.ServeFile(w, r, path)
+}
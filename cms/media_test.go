@@ -0,0 +1,229 @@
+package cms
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// useImageDir keeps uploads in a temporary directory, and returns a func
+// removing it.
+func useImageDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "cms-images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := ImageDir
+	ImageDir = dir
+	return func() {
+		ImageDir = old
+		os.RemoveAll(dir)
+	}
+}
+
+// testPNG encodes a blank PNG of the size.
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_SaveImage(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+
+		img, err := SaveImage("../cat.png", bytes.NewReader(testPNG(t, 40, 30)), "A cat", "ann")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Filename != "cat.png" || img.MIMEType != "image/png" || img.Width != 40 || img.Height != 30 || img.Uploader != "ann" {
+			t.Errorf("Expected the image's metadata, got %+v\n", img)
+		}
+		again, err := SaveImage("cat.png", bytes.NewReader(testPNG(t, 1, 1)), "", "")
+		if err != nil || again.Filename != "cat-2.png" {
+			t.Errorf("Expected a taken name to be numbered, got %+v, %v\n", again, err)
+		}
+		if _, err = SaveImage("notes.txt", bytes.NewReader([]byte("hello")), "", ""); err != ErrNotImage {
+			t.Errorf("Expected ErrNotImage, got %v\n", err)
+		}
+		pixels, size := MaxImagePixels, MaxImageBytes
+		MaxImagePixels = 100
+		if _, err = SaveImage("big.png", bytes.NewReader(testPNG(t, 20, 20)), "", ""); err != ErrImageTooBig {
+			t.Errorf("Expected too many pixels to be refused, got %v\n", err)
+		}
+		MaxImagePixels, MaxImageBytes = pixels, 10
+		if _, err = SaveImage("big.png", bytes.NewReader(testPNG(t, 1, 1)), "", ""); err != ErrImageTooBig {
+			t.Errorf("Expected too many bytes to be refused, got %v\n", err)
+		}
+		MaxImageBytes = size
+
+		stored, err := DefaultSite.store.GetImageByName("cat.png")
+		if err != nil || stored.ID != img.ID || stored.Alt != "A cat" {
+			t.Errorf("Expected the image in the library, got %+v, %v\n", stored, err)
+		}
+		images, err := GetImages()
+		if err != nil || len(images) != 2 || images[0].ID != again.ID {
+			t.Errorf("Expected both images, newest first, got %+v, %v\n", images, err)
+		}
+
+		w := httptest.NewRecorder()
+		ServeImage(w, httptest.NewRequest("GET", "/image/cat.png", nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("Expected the image served, got %d %q\n", w.Code, w.Header().Get("Content-Type"))
+		}
+
+		err = DeleteImage(img.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = GetImage(img.ID); err != ErrNotFound {
+			t.Errorf("Expected the image to be deleted, got %v\n", err)
+		}
+		w = httptest.NewRecorder()
+		ServeImage(w, httptest.NewRequest("GET", "/image/cat.png", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected the file to be deleted, got %d\n", w.Code)
+		}
+	})
+}
+
+func Test_FeaturedImages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+
+		_, err := CreatePost(&Post{Title: "Missing", Content: "text", FeaturedImageID: 999})
+		if err != ErrNoImage {
+			t.Errorf("Expected ErrNoImage, got %v\n", err)
+		}
+
+		img, err := SaveImage("hero.png", bytes.NewReader(testPNG(t, 2, 2)), "Hero", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := CreatePost(&Post{Title: "Featuring", Content: "text", Status: StatusPublished, FeaturedImageID: img.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		post, err := GetPost(strconv.Itoa(id))
+		if err == nil {
+			err = LoadFeaturedImages(post)
+		}
+		if err != nil || post.FeaturedImage == nil || post.FeaturedImage.Alt != "Hero" {
+			t.Fatalf("Expected the featured image, got %+v, %v\n", post, err)
+		}
+
+		err = DeleteImage(img.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		post, err = GetPost(strconv.Itoa(id))
+		if err != nil || post.FeaturedImageID != 0 {
+			t.Errorf("Expected the post to lose its featured image, got %+v, %v\n", post, err)
+		}
+	})
+}
+
+func Test_OrphanedImages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+
+		save := func(name string) *Image {
+			img, err := SaveImage(name, bytes.NewReader(testPNG(t, 1, 1)), "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return img
+		}
+		inPost, featured, inTrash := save("a.png"), save("b.png"), save("c.png")
+		orphan, prefix := save("a.png.png"), save("d.png")
+
+		_, err := CreatePost(&Post{Title: "Linked", Content: "![a](/image/a.png) and /image/d.png-old", FeaturedImageID: featured.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageID, err := CreatePage(&Page{Title: "Trashed", Content: `<img src="/image/c.png">`})
+		if err == nil {
+			err = DeletePage(pageID)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		orphans, err := OrphanedImages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := map[int]bool{}
+		for _, img := range orphans {
+			found[img.ID] = true
+		}
+		if len(orphans) != 2 || !found[orphan.ID] || !found[prefix.ID] {
+			t.Errorf("Expected only %s and %s to be orphans, got %+v\n", orphan.Filename, prefix.Filename, orphans)
+		}
+		for _, used := range []*Image{inPost, featured, inTrash} {
+			if found[used.ID] {
+				t.Errorf("Expected %s to be in use\n", used.Filename)
+			}
+		}
+	})
+}
+
+func Test_MediaHandler(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("action", "upload")
+		mw.WriteField("alt", "Uploaded")
+		part, err := mw.CreateFormFile("image", "up.png")
+		if err == nil {
+			_, err = part.Write(testPNG(t, 3, 3))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		mw.Close()
+		r := httptest.NewRequest("POST", "/admin/media", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		ServeMedia(w, r)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected a redirect after uploading, got %d %s\n", w.Code, w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		ServeMedia(w, httptest.NewRequest("GET", "/admin/media?orphans=1", nil))
+		if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("![Uploaded](/image/up.png)")) {
+			t.Errorf("Expected the unused upload listed with its Markdown, got %d %s\n", w.Code, w.Body.String())
+		}
+
+		img, err := DefaultSite.store.GetImageByName("up.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer loginAs("ed", RoleEditor)()
+		w = postForm(ServeMedia, "/admin/media", url.Values{"action": {"delete"}, "id": {strconv.Itoa(img.ID)}})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected an editor not to delete images, got %d\n", w.Code)
+		}
+		w = postForm(ServeMedia, "/admin/media", url.Values{"action": {"alt"}, "id": {strconv.Itoa(img.ID)}, "alt": {"Renamed"}})
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("Expected a redirect after changing the alt text, got %d\n", w.Code)
+		}
+		if img, err = GetImage(img.ID); err != nil || img.Alt != "Renamed" {
+			t.Errorf("Expected the new alt text, got %+v, %v\n", img, err)
+		}
+	})
+}
@@ -0,0 +1,895 @@
+package cms
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemStore is a Store that keeps everything in memory. It's the default store,
+// and is handy for tests and for trying the cms out without a database.
+// Everything is lost when the process exits.
+type MemStore struct {
+	mu       sync.RWMutex
+	lastID   int
+	pages    map[int]Page
+	trash    map[int]Page
+	posts    map[int]Post
+	comments map[int]Comment
+	// redirects maps kind/slug to the ID that used to have the slug
+	redirects map[string]int
+	index     *searchIndex
+	revisions map[int]Revision
+	terms     map[int]Term
+	termItems map[termItem]bool
+	images    map[int]Image
+	// translations are keyed by kind, item ID and language
+	translations map[translationKey]Translation
+}
+
+// NewMemStore creates an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{
+		pages:        map[int]Page{},
+		trash:        map[int]Page{},
+		posts:        map[int]Post{},
+		comments:     map[int]Comment{},
+		redirects:    map[string]int{},
+		index:        newSearchIndex(),
+		revisions:    map[int]Revision{},
+		terms:        map[int]Term{},
+		termItems:    map[termItem]bool{},
+		images:       map[int]Image{},
+		translations: map[translationKey]Translation{},
+	}
+}
+
+// Close is a no-op, there is nothing to release.
+func (s *MemStore) Close() error {
+	return nil
+}
+
+// nextID hands out IDs. They're shared between every kind of record, which is
+// fine since nobody relies on them being sequential. The caller must hold the
+// write lock.
+func (s *MemStore) nextID() int {
+	s.lastID++
+	return s.lastID
+}
+
+func (s *MemStore) GetPage(id int) (*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.pages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (s *MemStore) GetPageBySlug(slug string) (*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, p := range s.pages {
+		if p.Slug == slug {
+			return &p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemStore) GetPages() ([]*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := []*Page{}
+	for _, p := range s.pages {
+		p := p
+		pages = append(pages, &p)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].ID < pages[j].ID })
+	return pages, nil
+}
+
+func (s *MemStore) ListPages(q PageQuery) (*PageList, error) {
+	pages, err := s.GetPages()
+	if err != nil {
+		return nil, err
+	}
+	if q.Tag != "" {
+		s.mu.RLock()
+		tagged := []*Page{}
+		for _, p := range pages {
+			if s.hasTag(KindPage, p.ID, q.Tag) {
+				tagged = append(tagged, p)
+			}
+		}
+		s.mu.RUnlock()
+		pages = tagged
+	}
+	return listPages(pages, q)
+}
+
+// hasTag reports whether the page or post has the tag with the slug. The
+// caller must hold the lock.
+func (s *MemStore) hasTag(kind string, id int, slug string) bool {
+	for item := range s.termItems {
+		t := s.terms[item.TermID]
+		if item.Kind == kind && item.ItemID == id && t.Taxonomy == TaxonomyTag && t.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemStore) CreatePage(p *Page) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.DateCreated.IsZero() {
+		p.DateCreated = now()
+	}
+	if s.pageSlugTaken(p.Slug, 0) {
+		return 0, ErrSlugTaken
+	}
+	stored := *p
+	stored.ID = s.nextID()
+	stored.Version = 1
+	stored.Tags, stored.Categories = nil, nil
+	stored.Posts, stored.Lang, stored.Alternates = nil, "", nil
+	p.Version = stored.Version
+	s.pages[stored.ID] = stored
+	delete(s.redirects, KindPage+"/"+stored.Slug)
+	s.index.add(KindPage, stored.ID, stored.Slug, stored.Title, stored.Content, stored.Status)
+	return stored.ID, nil
+}
+
+func (s *MemStore) UpdatePage(p *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.pages[p.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrConflict
+	}
+	stored.Title = p.Title
+	stored.Content = p.Content
+	stored.Status = p.Status
+	stored.Version++
+	p.Version = stored.Version
+	s.pages[p.ID] = stored
+	s.index.add(KindPage, stored.ID, stored.Slug, stored.Title, stored.Content, stored.Status)
+	return nil
+}
+
+func (s *MemStore) TrashPage(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	p.DeletedAt = now()
+	s.trash[id] = p
+	delete(s.pages, id)
+	s.index.remove(KindPage, id)
+	return nil
+}
+
+func (s *MemStore) GetTrash() ([]*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages := []*Page{}
+	for _, p := range s.trash {
+		p := p
+		pages = append(pages, &p)
+	}
+	sortTrash(pages)
+	return pages, nil
+}
+
+func (s *MemStore) RestorePage(id int, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.trash[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.pageSlugTaken(slug, id) {
+		return ErrSlugTaken
+	}
+	p.Slug = slug
+	p.DeletedAt = time.Time{}
+	s.pages[id] = p
+	delete(s.trash, id)
+	delete(s.redirects, KindPage+"/"+slug)
+	s.index.add(KindPage, p.ID, p.Slug, p.Title, p.Content, p.Status)
+	return nil
+}
+
+func (s *MemStore) PurgePage(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.trash[id]; !ok {
+		return ErrNotFound
+	}
+	for key, rid := range s.redirects {
+		if rid == id && strings.HasPrefix(key, KindPage+"/") {
+			delete(s.redirects, key)
+		}
+	}
+	for item := range s.termItems {
+		if item.Kind == KindPage && item.ItemID == id {
+			delete(s.termItems, item)
+		}
+	}
+	s.deleteTranslations(KindPage, id)
+	delete(s.trash, id)
+	return nil
+}
+
+func (s *MemStore) SetPageSlug(id int, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pages[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.pageSlugTaken(slug, id) {
+		return ErrSlugTaken
+	}
+	s.redirects[KindPage+"/"+p.Slug] = id
+	delete(s.redirects, KindPage+"/"+slug)
+	p.Slug = slug
+	s.pages[id] = p
+	s.index.add(KindPage, id, p.Slug, p.Title, p.Content, p.Status)
+	return nil
+}
+
+// pageSlugTaken reports whether a page other than id has the slug. The caller
+// must hold the lock.
+func (s *MemStore) pageSlugTaken(slug string, id int) bool {
+	for _, p := range s.pages {
+		if p.Slug == slug && p.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemStore) GetPost(id int) (*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.posts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (s *MemStore) GetPostBySlug(slug string) (*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, p := range s.posts {
+		if p.Slug == slug {
+			return &p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemStore) GetPosts(status string, limit int) ([]*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	posts := []*Post{}
+	for _, p := range s.posts {
+		if matchStatus(p.Status, status) {
+			p := p
+			posts = append(posts, &p)
+		}
+	}
+	return recentPosts(posts, limit), nil
+}
+
+func (s *MemStore) CreatePost(p *Post) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.DatePublished.IsZero() {
+		p.DatePublished = now()
+	}
+	if s.postSlugTaken(p.Slug, 0) {
+		return 0, ErrSlugTaken
+	}
+	stored := *p
+	stored.ID = s.nextID()
+	stored.Tags, stored.Categories = nil, nil
+	stored.Comments, stored.FeaturedImage = nil, nil
+	stored.Lang, stored.Alternates = "", nil
+	s.posts[stored.ID] = stored
+	delete(s.redirects, KindPost+"/"+stored.Slug)
+	s.index.add(KindPost, stored.ID, stored.Slug, stored.Title, stored.Content, stored.Status)
+	return stored.ID, nil
+}
+
+func (s *MemStore) SetPostSlug(id int, slug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.postSlugTaken(slug, id) {
+		return ErrSlugTaken
+	}
+	s.redirects[KindPost+"/"+p.Slug] = id
+	delete(s.redirects, KindPost+"/"+slug)
+	p.Slug = slug
+	s.posts[id] = p
+	s.index.add(KindPost, id, p.Slug, p.Title, p.Content, p.Status)
+	return nil
+}
+
+// postSlugTaken reports whether a post other than id has the slug. The caller
+// must hold the lock.
+func (s *MemStore) postSlugTaken(slug string, id int) bool {
+	for _, p := range s.posts {
+		if p.Slug == slug && p.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemStore) UpdatePost(p *Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.posts[p.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Title = p.Title
+	stored.Content = p.Content
+	stored.Status = p.Status
+	stored.PublishAt = p.PublishAt
+	stored.FeaturedImageID = p.FeaturedImageID
+	if !p.DatePublished.IsZero() {
+		stored.DatePublished = p.DatePublished
+	}
+	s.posts[p.ID] = stored
+	s.index.add(KindPost, stored.ID, stored.Slug, stored.Title, stored.Content, stored.Status)
+	return nil
+}
+
+func (s *MemStore) PublishDuePosts(now time.Time) ([]*Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	posts := []*Post{}
+	for id, p := range s.posts {
+		if !duePost(&p, now) {
+			continue
+		}
+		p.Status = StatusPublished
+		p.DatePublished = p.PublishAt
+		s.posts[id] = p
+		s.index.add(KindPost, id, p.Slug, p.Title, p.Content, p.Status)
+		p := p
+		posts = append(posts, &p)
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID < posts[j].ID })
+	return posts, nil
+}
+
+func (s *MemStore) DeletePost(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.posts[id]; !ok {
+		return ErrNotFound
+	}
+	for cid, c := range s.comments {
+		if c.PostID == id {
+			delete(s.comments, cid)
+		}
+	}
+	for key, rid := range s.redirects {
+		if rid == id && strings.HasPrefix(key, KindPost+"/") {
+			delete(s.redirects, key)
+		}
+	}
+	for item := range s.termItems {
+		if item.Kind == KindPost && item.ItemID == id {
+			delete(s.termItems, item)
+		}
+	}
+	s.deleteTranslations(KindPost, id)
+	delete(s.posts, id)
+	s.index.remove(KindPost, id)
+	return nil
+}
+
+func (s *MemStore) GetComments(postID int, status string) ([]*Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comments := []*Comment{}
+	for _, c := range s.comments {
+		if c.PostID == postID && matchCommentStatus(c.Status, status) {
+			c := c
+			comments = append(comments, &c)
+		}
+	}
+	sortComments(comments)
+	return comments, nil
+}
+
+func (s *MemStore) GetCommentsByStatus(status string) ([]*Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comments := []*Comment{}
+	for _, c := range s.comments {
+		if matchCommentStatus(c.Status, status) {
+			c := c
+			comments = append(comments, &c)
+		}
+	}
+	sortComments(comments)
+	return comments, nil
+}
+
+func (s *MemStore) SetCommentStatus(id int, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return ErrNotFound
+	}
+	c.Status = status
+	s.comments[id] = c
+	return nil
+}
+
+func (s *MemStore) CreateComment(c *Comment) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.posts[c.PostID]; !ok {
+		return 0, ErrNotFound
+	}
+	if c.DatePublished.IsZero() {
+		c.DatePublished = now()
+	}
+	stored := *c
+	stored.ID = s.nextID()
+	stored.Depth, stored.Replies, stored.ReplyURL = 0, nil, ""
+	s.comments[stored.ID] = stored
+	return stored.ID, nil
+}
+
+func (s *MemStore) DeleteComment(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.comments[id]; !ok {
+		return ErrNotFound
+	}
+	// Replies go with the comment, and their replies with them
+	ids := []int{id}
+	for len(ids) > 0 {
+		parent := ids[0]
+		ids = ids[1:]
+		delete(s.comments, parent)
+		for cid, c := range s.comments {
+			if c.ParentID == parent {
+				ids = append(ids, cid)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) GetSlugRedirect(kind, slug string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.redirects[kind+"/"+slug]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return id, nil
+}
+
+func (s *MemStore) Search(query, status string, limit int) ([]*SearchResult, error) {
+	return s.index.search(query, status, limit), nil
+}
+
+func (s *MemStore) CreateRevision(r *Revision) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *r
+	stored.ID = s.nextID()
+	stored.Number = 1
+	for _, rev := range s.revisions {
+		if rev.Kind == r.Kind && rev.ItemID == r.ItemID && rev.Number >= stored.Number {
+			stored.Number = rev.Number + 1
+		}
+	}
+	if stored.DateCreated.IsZero() {
+		stored.DateCreated = now()
+	}
+	s.revisions[stored.ID] = stored
+	return stored.ID, nil
+}
+
+func (s *MemStore) GetRevisions(kind string, itemID int) ([]*Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	revs := []*Revision{}
+	for _, r := range s.revisions {
+		if r.Kind == kind && r.ItemID == itemID {
+			r := r
+			revs = append(revs, &r)
+		}
+	}
+	sortRevisions(revs)
+	return revs, nil
+}
+
+func (s *MemStore) GetRevision(kind string, itemID, number int) (*Revision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.revisions {
+		if r.Kind == kind && r.ItemID == itemID && r.Number == number {
+			return &r, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemStore) GetTerms(taxonomy string) ([]*Term, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := []*Term{}
+	for _, t := range s.terms {
+		if t.Taxonomy == taxonomy {
+			t := t
+			terms = append(terms, &t)
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool { return terms[i].ID < terms[j].ID })
+	return terms, nil
+}
+
+func (s *MemStore) CreateTerm(t *Term) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.terms {
+		if other.Taxonomy == t.Taxonomy && other.ParentID == t.ParentID && other.Slug == t.Slug {
+			return 0, ErrSlugTaken
+		}
+	}
+	stored := *t
+	stored.ID = s.nextID()
+	stored.Path, stored.Count = "", 0
+	s.terms[stored.ID] = stored
+	return stored.ID, nil
+}
+
+func (s *MemStore) SetItemTerms(taxonomy, kind string, itemID int, termIDs []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for item := range s.termItems {
+		if item.Kind == kind && item.ItemID == itemID && s.terms[item.TermID].Taxonomy == taxonomy {
+			delete(s.termItems, item)
+		}
+	}
+	for _, id := range termIDs {
+		if _, ok := s.terms[id]; !ok {
+			return ErrNotFound
+		}
+		s.termItems[termItem{id, kind, itemID}] = true
+	}
+	return nil
+}
+
+func (s *MemStore) GetItemTerms(kind string, itemIDs []int) (map[int][]*Term, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := map[int][]*Term{}
+	for _, id := range itemIDs {
+		for item := range s.termItems {
+			if item.Kind == kind && item.ItemID == id {
+				t := s.terms[item.TermID]
+				terms[id] = append(terms[id], &t)
+			}
+		}
+		sortTerms(terms[id])
+	}
+	return terms, nil
+}
+
+func (s *MemStore) GetTermContent(termIDs []int, status string) ([]*Page, []*Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pages, posts := []*Page{}, []*Post{}
+	for _, p := range s.pages {
+		if matchStatus(p.Status, status) && s.hasTerm(KindPage, p.ID, termIDs) {
+			p := p
+			pages = append(pages, &p)
+		}
+	}
+	for _, p := range s.posts {
+		if matchStatus(p.Status, status) && s.hasTerm(KindPost, p.ID, termIDs) {
+			p := p
+			posts = append(posts, &p)
+		}
+	}
+	sortPagesByTitle(pages)
+	return pages, recentPosts(posts, 0), nil
+}
+
+// hasTerm reports whether the page or post has any of the terms. The caller
+// must hold the lock.
+func (s *MemStore) hasTerm(kind string, id int, termIDs []int) bool {
+	for _, termID := range termIDs {
+		if s.termItems[termItem{termID, kind, id}] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemStore) CountTermItems(taxonomy, status string) (map[int]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[int]int{}
+	for item := range s.termItems {
+		if s.terms[item.TermID].Taxonomy != taxonomy {
+			continue
+		}
+		var itemStatus string
+		switch item.Kind {
+		case KindPage:
+			itemStatus = s.pages[item.ItemID].Status
+		case KindPost:
+			itemStatus = s.posts[item.ItemID].Status
+		}
+		if matchStatus(itemStatus, status) {
+			counts[item.TermID]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *MemStore) CreateImage(img *Image) (int, error) {
+	if img.DateCreated.IsZero() {
+		img.DateCreated = now()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, other := range s.images {
+		if other.Filename == img.Filename {
+			return 0, ErrFilenameTaken
+		}
+	}
+	stored := *img
+	stored.ID = s.nextID()
+	s.images[stored.ID] = stored
+	return stored.ID, nil
+}
+
+func (s *MemStore) GetImage(id int) (*Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	img, ok := s.images[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &img, nil
+}
+
+func (s *MemStore) GetImageByName(filename string) (*Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, img := range s.images {
+		if img.Filename == filename {
+			return &img, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemStore) GetImages() ([]*Image, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	images := []*Image{}
+	for _, img := range s.images {
+		img := img
+		images = append(images, &img)
+	}
+	sortImages(images)
+	return images, nil
+}
+
+func (s *MemStore) UpdateImage(img *Image) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.images[img.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	stored.Alt = img.Alt
+	s.images[img.ID] = stored
+	return nil
+}
+
+func (s *MemStore) DeleteImage(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.images[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.images, id)
+	for postID, p := range s.posts {
+		if p.FeaturedImageID == id {
+			p.FeaturedImageID = 0
+			s.posts[postID] = p
+		}
+	}
+	return nil
+}
+
+// translationKey identifies a translation in a MemStore
+type translationKey struct {
+	Kind   string
+	ItemID int
+	Lang   string
+}
+
+func (s *MemStore) SetTranslation(t *Translation) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := translationKey{t.Kind, t.ItemID, t.Lang}
+	stored := *t
+	if old, ok := s.translations[key]; ok {
+		stored.ID = old.ID
+	} else {
+		stored.ID = s.nextID()
+	}
+	s.translations[key] = stored
+	return stored.ID, nil
+}
+
+func (s *MemStore) GetTranslations(kind string, itemIDs []int) (map[int][]*Translation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	translations := map[int][]*Translation{}
+	for _, id := range itemIDs {
+		for key, t := range s.translations {
+			if key.Kind == kind && key.ItemID == id {
+				t := t
+				translations[id] = append(translations[id], &t)
+			}
+		}
+		sortTranslations(translations[id])
+	}
+	return translations, nil
+}
+
+func (s *MemStore) DeleteTranslation(kind string, itemID int, lang string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := translationKey{kind, itemID, lang}
+	if _, ok := s.translations[key]; !ok {
+		return ErrNotFound
+	}
+	delete(s.translations, key)
+	return nil
+}
+
+// deleteTranslations deletes every translation of a page or post. The caller
+// must hold the write lock.
+func (s *MemStore) deleteTranslations(kind string, itemID int) {
+	for key := range s.translations {
+		if key.Kind == kind && key.ItemID == itemID {
+			delete(s.translations, key)
+		}
+	}
+}
+
+// recentPosts sorts posts newest first, the same order Postgres uses, and
+// trims them to limit. It's shared by the stores that can't sort in a query.
+func recentPosts(posts []*Post, limit int) []*Post {
+	sort.Slice(posts, func(i, j int) bool {
+		a, b := posts[i], posts[j]
+		if !a.DatePublished.Equal(b.DatePublished) {
+			return a.DatePublished.After(b.DatePublished)
+		}
+		return a.ID > b.ID
+	})
+	if limit > 0 && len(posts) > limit {
+		posts = posts[:limit]
+	}
+	return posts
+}
+
+// sortTrash puts the pages in the trash most recently deleted first.
+func sortTrash(pages []*Page) {
+	sort.Slice(pages, func(i, j int) bool {
+		a, b := pages[i], pages[j]
+		if !a.DeletedAt.Equal(b.DeletedAt) {
+			return a.DeletedAt.After(b.DeletedAt)
+		}
+		return a.ID > b.ID
+	})
+}
+
+// sortComments puts comments oldest first.
+func sortComments(comments []*Comment) {
+	sort.Slice(comments, func(i, j int) bool {
+		a, b := comments[i], comments[j]
+		if !a.DatePublished.Equal(b.DatePublished) {
+			return a.DatePublished.Before(b.DatePublished)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// sortRevisions puts revisions newest first.
+func sortRevisions(revs []*Revision) {
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Number > revs[j].Number })
+}
+
+// sortTranslations orders translations by language.
+func sortTranslations(translations []*Translation) {
+	sort.Slice(translations, func(i, j int) bool { return translations[i].Lang < translations[j].Lang })
+}
+
+// sortImages orders images newest first, and by ID when they were uploaded
+// at the same time.
+func sortImages(images []*Image) {
+	sort.Slice(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if !a.DateCreated.Equal(b.DateCreated) {
+			return a.DateCreated.After(b.DateCreated)
+		}
+		return a.ID > b.ID
+	})
+}
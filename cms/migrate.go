@@ -0,0 +1,177 @@
+package cms
+
+import (
+	"time"
+)
+
+// Migration is a single versioned change to the Postgres schema. Up applies
+// it and Down reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied, and when.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by stores with a schema that needs migrating.
+// Only PgStore has one: the memory and bolt stores need no setup.
+type Migrator interface {
+	MigrateUp() ([]Migration, error)
+	MigrateDown(steps int) ([]Migration, error)
+	MigrationStatus() ([]MigrationStatus, error)
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations(
+  version        INT       PRIMARY KEY,
+  name           TEXT      NOT NULL,
+  applied_at     TIMESTAMP NOT NULL
+);`
+
+// MigrateUp applies every pending migration in order, and returns the ones it
+// applied.
+func (s *PgStore) MigrateUp() ([]Migration, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, m := range Migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		ok, err := s.runMigration(m, true)
+		if err != nil {
+			return done, err
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the most recently applied migrations, at most steps of
+// them, and returns the ones it reverted.
+func (s *PgStore) MigrateDown(steps int) ([]Migration, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for i := len(Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := Migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		ok, err := s.runMigration(m, false)
+		if err != nil {
+			return done, err
+		}
+		if ok {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrationStatus lists every known migration and whether it's been applied.
+func (s *PgStore) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := []MigrationStatus{}
+	for _, m := range Migrations {
+		at, ok := applied[m.Version]
+		status = append(status, MigrationStatus{
+			Migration: m,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+	return status, nil
+}
+
+// appliedMigrations returns when each applied version was applied, creating
+// the tracking table on first use.
+func (s *PgStore) appliedMigrations() (map[int]time.Time, error) {
+	_, err := s.DB.Exec(createMigrationsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration applies or reverts a single migration in a transaction, along
+// with its row in schema_migrations. The tracking table is locked first, and
+// the version checked again, so two deploys migrating at once can't both run
+// the same migration; the loser reports false.
+func (s *PgStore) runMigration(m Migration, up bool) (bool, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	_, err = tx.Exec("LOCK TABLE schema_migrations IN ACCESS EXCLUSIVE MODE")
+	if err != nil {
+		return false, err
+	}
+
+	var n int
+	err = tx.QueryRow("SELECT count(*) FROM schema_migrations WHERE version = $1", m.Version).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	if (n == 0) != up {
+		return false, nil
+	}
+
+	if up {
+		_, err = tx.Exec(m.Up)
+		if err == nil {
+			_, err = tx.Exec("INSERT INTO schema_migrations(version, name, applied_at) VALUES($1, $2, $3)",
+				m.Version, m.Name, time.Now())
+		}
+	} else {
+		_, err = tx.Exec(m.Down)
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", m.Version)
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+var _ Migrator = (*PgStore)(nil)
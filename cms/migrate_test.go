@@ -0,0 +1,48 @@
+package cms
+
+import (
+	"os"
+	"testing"
+)
+
+func Test_MigrationsAreSequential(t *testing.T) {
+	for i, m := range Migrations {
+		if m.Version != i+1 {
+			t.Errorf("Migration %q has version %d, expected %d\n", m.Name, m.Version, i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			t.Errorf("Migration %d needs both an up and a down\n", m.Version)
+		}
+	}
+}
+
+func Test_MigrateRoundTrip(t *testing.T) {
+	dsn := os.Getenv("CMS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("CMS_TEST_DSN not set")
+	}
+	s, err := NewPgStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	_, err = s.MigrateUp()
+	if err != nil {
+		t.Fatalf("Failed to migrate up: %s\n", err.Error())
+	}
+	down, err := s.MigrateDown(len(Migrations))
+	if err != nil {
+		t.Fatalf("Failed to migrate down: %s\n", err.Error())
+	}
+	if len(down) != len(Migrations) {
+		t.Errorf("Reverted %d migrations, expected %d\n", len(down), len(Migrations))
+	}
+	up, err := s.MigrateUp()
+	if err != nil {
+		t.Fatalf("Failed to migrate back up: %s\n", err.Error())
+	}
+	if len(up) != len(Migrations) {
+		t.Errorf("Applied %d migrations, expected %d\n", len(up), len(Migrations))
+	}
+}
@@ -0,0 +1,310 @@
+package cms
+
+// Migrations is every schema change for the Postgres store, oldest first.
+// Versions must be sequential. Once a migration has been released, never edit
+// it: add a new one instead.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create pages, posts and comments",
+		// IF NOT EXISTS lets databases created with the old init.sql adopt
+		// this migration without losing anything
+		Up: `
+CREATE TABLE IF NOT EXISTS PAGES(
+  id             SERIAL    PRIMARY KEY,
+  title          TEXT      NOT NULL,
+  content        TEXT      NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS POSTS(
+  id             SERIAL    PRIMARY KEY,
+  title          TEXT      NOT NULL,
+  content        TEXT      NOT NULL,
+  date_created   DATE      NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS COMMENTS(
+  id             SERIAL    PRIMARY KEY,
+  author         TEXT      NOT NULL,
+  content        TEXT      NOT NULL,
+  date_created   DATE      NOT NULL,
+  post_id        INT       references POSTS(id)
+);
+`,
+		Down: `
+DROP TABLE COMMENTS;
+DROP TABLE POSTS;
+DROP TABLE PAGES;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "add slugs to pages and posts",
+		// Existing rows get a slug made from their title and ID, which is
+		// unique without having to check
+		Up: `
+ALTER TABLE PAGES ADD COLUMN slug TEXT;
+UPDATE PAGES SET slug = trim(both '-' from lower(regexp_replace(title, '[^a-zA-Z0-9]+', '-', 'g'))) || '-' || id;
+ALTER TABLE PAGES ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX pages_slug_idx ON PAGES(slug);
+
+ALTER TABLE POSTS ADD COLUMN slug TEXT;
+UPDATE POSTS SET slug = trim(both '-' from lower(regexp_replace(title, '[^a-zA-Z0-9]+', '-', 'g'))) || '-' || id;
+ALTER TABLE POSTS ALTER COLUMN slug SET NOT NULL;
+CREATE UNIQUE INDEX posts_slug_idx ON POSTS(slug);
+
+CREATE TABLE SLUG_REDIRECTS(
+  kind           TEXT      NOT NULL,
+  slug           TEXT      NOT NULL,
+  item_id        INT       NOT NULL,
+  PRIMARY KEY (kind, slug)
+);
+`,
+		Down: `
+DROP TABLE SLUG_REDIRECTS;
+ALTER TABLE POSTS DROP COLUMN slug;
+ALTER TABLE PAGES DROP COLUMN slug;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "add full-text search to pages and posts",
+		// A trigger rather than a generated column keeps this working on
+		// Postgres versions before 12
+		Up: `
+CREATE FUNCTION cms_search_vector() RETURNS trigger AS $$
+BEGIN
+  NEW.search := setweight(to_tsvector('english', coalesce(NEW.title, '')), 'A') ||
+                setweight(to_tsvector('english', coalesce(NEW.content, '')), 'B');
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE PAGES ADD COLUMN search TSVECTOR;
+CREATE TRIGGER pages_search BEFORE INSERT OR UPDATE ON PAGES
+  FOR EACH ROW EXECUTE PROCEDURE cms_search_vector();
+UPDATE PAGES SET title = title;
+CREATE INDEX pages_search_idx ON PAGES USING GIN(search);
+
+ALTER TABLE POSTS ADD COLUMN search TSVECTOR;
+CREATE TRIGGER posts_search BEFORE INSERT OR UPDATE ON POSTS
+  FOR EACH ROW EXECUTE PROCEDURE cms_search_vector();
+UPDATE POSTS SET title = title;
+CREATE INDEX posts_search_idx ON POSTS USING GIN(search);
+`,
+		Down: `
+DROP TRIGGER posts_search ON POSTS;
+ALTER TABLE POSTS DROP COLUMN search;
+DROP TRIGGER pages_search ON PAGES;
+ALTER TABLE PAGES DROP COLUMN search;
+DROP FUNCTION cms_search_vector();
+`,
+	},
+	{
+		Version: 4,
+		Name:    "add creation dates to pages",
+		Up: `
+ALTER TABLE PAGES ADD COLUMN date_created TIMESTAMP NOT NULL DEFAULT now();
+CREATE INDEX pages_title_idx ON PAGES(lower(title), id);
+CREATE INDEX pages_date_created_idx ON PAGES(date_created, id);
+`,
+		Down: `
+DROP INDEX IF EXISTS pages_date_created_idx;
+DROP INDEX pages_title_idx;
+ALTER TABLE PAGES DROP COLUMN date_created;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "add page and post revisions",
+		// Everything that already exists starts off with a first revision
+		Up: `
+CREATE TABLE REVISIONS(
+  id             SERIAL    PRIMARY KEY,
+  kind           TEXT      NOT NULL,
+  item_id        INT       NOT NULL,
+  number         INT       NOT NULL,
+  title          TEXT      NOT NULL,
+  content        TEXT      NOT NULL,
+  author         TEXT      NOT NULL DEFAULT '',
+  note           TEXT      NOT NULL DEFAULT '',
+  date_created   TIMESTAMP NOT NULL,
+  UNIQUE (kind, item_id, number)
+);
+
+INSERT INTO REVISIONS(kind, item_id, number, title, content, note, date_created)
+  SELECT 'page', id, 1, title, content, 'Created', date_created FROM PAGES;
+INSERT INTO REVISIONS(kind, item_id, number, title, content, note, date_created)
+  SELECT 'post', id, 1, title, content, 'Created', date_created FROM POSTS;
+`,
+		Down: `
+DROP TABLE REVISIONS;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "add publishing workflow to pages and posts",
+		// Everything that already exists was public, so it starts out
+		// published; new rows default to drafts. Posts are published at a
+		// time of day now, so their dates become timestamps.
+		Up: `
+ALTER TABLE PAGES ADD COLUMN status TEXT NOT NULL DEFAULT 'published';
+ALTER TABLE PAGES ALTER COLUMN status SET DEFAULT 'draft';
+CREATE INDEX pages_status_idx ON PAGES(status);
+
+ALTER TABLE POSTS ADD COLUMN status TEXT NOT NULL DEFAULT 'published';
+ALTER TABLE POSTS ALTER COLUMN status SET DEFAULT 'draft';
+ALTER TABLE POSTS ADD COLUMN publish_at TIMESTAMP;
+ALTER TABLE POSTS ALTER COLUMN date_created TYPE TIMESTAMP;
+CREATE INDEX posts_status_idx ON POSTS(status, date_created);
+CREATE INDEX posts_publish_at_idx ON POSTS(publish_at) WHERE status = 'review';
+`,
+		Down: `
+ALTER TABLE POSTS ALTER COLUMN date_created TYPE DATE;
+ALTER TABLE POSTS DROP COLUMN publish_at;
+ALTER TABLE POSTS DROP COLUMN status;
+ALTER TABLE PAGES DROP COLUMN status;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "add tags and categories",
+		// Terms are tags or categories, and a category's parent_id is 0 at
+		// the top. term_items can hold pages and posts, so item_id can't
+		// reference either table.
+		Up: `
+CREATE TABLE TERMS(
+  id             SERIAL    PRIMARY KEY,
+  taxonomy       TEXT      NOT NULL,
+  slug           TEXT      NOT NULL,
+  name           TEXT      NOT NULL,
+  parent_id      INT       NOT NULL DEFAULT 0,
+  UNIQUE (taxonomy, parent_id, slug)
+);
+
+CREATE TABLE TERM_ITEMS(
+  term_id        INT       NOT NULL REFERENCES TERMS(id) ON DELETE CASCADE,
+  kind           TEXT      NOT NULL,
+  item_id        INT       NOT NULL,
+  PRIMARY KEY (term_id, kind, item_id)
+);
+CREATE INDEX term_items_item_idx ON TERM_ITEMS(kind, item_id);
+`,
+		Down: `
+DROP TABLE TERM_ITEMS;
+DROP TABLE TERMS;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "add comment moderation",
+		// Comments left so far were shown straight away, so they start out
+		// approved; new ones wait for a moderator
+		Up: `
+ALTER TABLE COMMENTS ADD COLUMN status TEXT NOT NULL DEFAULT 'approved';
+ALTER TABLE COMMENTS ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE COMMENTS ADD COLUMN ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE COMMENTS ALTER COLUMN date_created TYPE TIMESTAMP;
+CREATE INDEX comments_post_idx ON COMMENTS(post_id, status, date_created);
+CREATE INDEX comments_status_idx ON COMMENTS(status, date_created);
+`,
+		Down: `
+ALTER TABLE COMMENTS ALTER COLUMN date_created TYPE DATE;
+ALTER TABLE COMMENTS DROP COLUMN ip;
+ALTER TABLE COMMENTS DROP COLUMN status;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "add comment replies",
+		Up: `
+ALTER TABLE COMMENTS ADD COLUMN parent_id INT REFERENCES COMMENTS(id) ON DELETE CASCADE;
+CREATE INDEX comments_parent_idx ON COMMENTS(parent_id);
+`,
+		Down: `
+ALTER TABLE COMMENTS DROP COLUMN parent_id;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "add page versions and the trash",
+		// Pages in the trash are moved out of PAGES, so nothing that lists or
+		// searches pages has to skip them
+		Up: `
+ALTER TABLE PAGES ADD COLUMN version INT NOT NULL DEFAULT 1;
+CREATE TABLE PAGES_TRASH(
+  id             INT       PRIMARY KEY,
+  slug           TEXT      NOT NULL,
+  title          TEXT      NOT NULL,
+  content        TEXT      NOT NULL,
+  status         TEXT      NOT NULL,
+  version        INT       NOT NULL,
+  date_created   TIMESTAMP NOT NULL,
+  deleted_at     TIMESTAMP NOT NULL
+);
+CREATE INDEX pages_trash_deleted_idx ON PAGES_TRASH(deleted_at);
+`,
+		Down: `
+DROP TABLE PAGES_TRASH;
+ALTER TABLE PAGES DROP COLUMN version;
+`,
+	},
+	{
+		Version: 11,
+		Name:    "add authors to pages and posts",
+		// Content from before there were logins has no author
+		Up: `
+ALTER TABLE PAGES ADD COLUMN author TEXT NOT NULL DEFAULT '';
+ALTER TABLE PAGES_TRASH ADD COLUMN author TEXT NOT NULL DEFAULT '';
+ALTER TABLE POSTS ADD COLUMN author TEXT NOT NULL DEFAULT '';
+`,
+		Down: `
+ALTER TABLE POSTS DROP COLUMN author;
+ALTER TABLE PAGES_TRASH DROP COLUMN author;
+ALTER TABLE PAGES DROP COLUMN author;
+`,
+	},
+	{
+		Version: 12,
+		Name:    "add the media library",
+		Up: `
+CREATE TABLE IMAGES(
+  id             SERIAL    PRIMARY KEY,
+  filename       TEXT      NOT NULL UNIQUE,
+  mime_type      TEXT      NOT NULL,
+  width          INT       NOT NULL,
+  height         INT       NOT NULL,
+  size           BIGINT    NOT NULL,
+  alt            TEXT      NOT NULL,
+  uploader       TEXT      NOT NULL,
+  date_created   TIMESTAMP NOT NULL
+);
+CREATE INDEX images_date_created_idx ON IMAGES(date_created);
+ALTER TABLE POSTS ADD COLUMN featured_image_id INT REFERENCES IMAGES(id) ON DELETE SET NULL;
+`,
+		Down: `
+ALTER TABLE POSTS DROP COLUMN featured_image_id;
+DROP TABLE IMAGES;
+`,
+	},
+	{
+		Version: 13,
+		Name:    "add translations of pages and posts",
+		// Pages keep their ID in the trash, so item_id can't reference PAGES
+		Up: `
+CREATE TABLE TRANSLATIONS(
+  id             SERIAL    PRIMARY KEY,
+  kind           TEXT      NOT NULL,
+  item_id        INT       NOT NULL,
+  lang           TEXT      NOT NULL,
+  title          TEXT      NOT NULL,
+  content        TEXT      NOT NULL,
+  date_updated   TIMESTAMP NOT NULL,
+  UNIQUE (kind, item_id, lang)
+);
+`,
+		Down: `
+DROP TABLE TRANSLATIONS;
+`,
+	},
+}
@@ -0,0 +1,295 @@
+package cms
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The orders pages can be listed in.
+const (
+	SortID    = "id"
+	SortTitle = "title"
+	SortDate  = "date"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+var (
+	// ErrBadCursor is returned for a cursor that can't be decoded, or that
+	// was made for a different sort order.
+	ErrBadCursor = errors.New("cms: invalid cursor")
+
+	// ErrBadSort is returned when asked to sort by anything unsupported.
+	ErrBadSort = errors.New("cms: invalid sort, use id, title or date")
+)
+
+// PageQuery describes which pages to list, and in what order. Pagination is
+// either by Offset, or by keyset with the After and Before cursors from a
+// previous PageList, which stay correct while pages are being added.
+type PageQuery struct {
+	Limit  int
+	Offset int
+	After  string
+	Before string
+	Sort   string
+	Desc   bool
+	// Title only lists pages with this in their title, ignoring case
+	Title string
+	// Status only lists pages with this status. Empty lists every page.
+	Status string
+	// Tag only lists pages with the tag with this slug
+	Tag string
+}
+
+// PageList is one page of a page listing.
+type PageList struct {
+	Pages []*Page
+	// Total is how many pages match the query, across every page
+	Total int
+	// Next and Prev are cursors for the neighbouring pages of results. They
+	// are empty at either end.
+	Next string
+	Prev string
+}
+
+// ParsePageQuery reads a PageQuery from URL parameters: limit, offset, after,
+// before, sort, order (asc or desc), title, status and tag.
+func ParsePageQuery(v url.Values) (PageQuery, error) {
+	q := PageQuery{
+		After:  v.Get("after"),
+		Before: v.Get("before"),
+		Sort:   v.Get("sort"),
+		Desc:   v.Get("order") == "desc",
+		Title:  v.Get("title"),
+		Status: v.Get("status"),
+		Tag:    v.Get("tag"),
+	}
+	var err error
+	if s := v.Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil {
+			return q, errors.New("cms: invalid limit")
+		}
+	}
+	if s := v.Get("offset"); s != "" {
+		q.Offset, err = strconv.Atoi(s)
+		if err != nil || q.Offset < 0 {
+			return q, errors.New("cms: invalid offset")
+		}
+	}
+	return q, q.normalize()
+}
+
+// Values encodes the query back into URL parameters, leaving out defaults.
+func (q PageQuery) Values() url.Values {
+	v := url.Values{}
+	if q.Limit != 0 && q.Limit != defaultPageLimit {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		v.Set("offset", strconv.Itoa(q.Offset))
+	}
+	if q.After != "" {
+		v.Set("after", q.After)
+	}
+	if q.Before != "" {
+		v.Set("before", q.Before)
+	}
+	if q.Sort != "" && q.Sort != SortID {
+		v.Set("sort", q.Sort)
+	}
+	if q.Desc {
+		v.Set("order", "desc")
+	}
+	if q.Title != "" {
+		v.Set("title", q.Title)
+	}
+	if q.Status != "" {
+		v.Set("status", q.Status)
+	}
+	if q.Tag != "" {
+		v.Set("tag", q.Tag)
+	}
+	return v
+}
+
+// Link links to the neighbouring page of a listing served at path, keeping the
+// sort order and filter. param is "after" or "before", and cursor comes from
+// a PageList. It's empty if there's no cursor to link to.
+func (q PageQuery) Link(path, param, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	q.Offset, q.After, q.Before = 0, "", ""
+	v := q.Values()
+	v.Set(param, cursor)
+	return path + "?" + v.Encode()
+}
+
+// normalize fills in defaults and checks the query makes sense.
+func (q *PageQuery) normalize() error {
+	if q.Limit <= 0 {
+		q.Limit = defaultPageLimit
+	}
+	if q.Limit > maxPageLimit {
+		q.Limit = maxPageLimit
+	}
+	if q.Sort == "" {
+		q.Sort = SortID
+	}
+	if q.Sort != SortID && q.Sort != SortTitle && q.Sort != SortDate {
+		return ErrBadSort
+	}
+	if q.After != "" && q.Before != "" {
+		return errors.New("cms: use either after or before, not both")
+	}
+	if q.Status != "" {
+		return checkStatus(&q.Status)
+	}
+	return nil
+}
+
+// ListPages returns the pages matching q.
+func (site *Site) ListPages(q PageQuery) (*PageList, error) {
+	err := q.normalize()
+	if err != nil {
+		return nil, err
+	}
+	return site.store.ListPages(q)
+}
+
+// pageCursor is the position of a page in a listing: its sort value, with the
+// ID to break ties.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// encodeCursor makes an opaque cursor pointing at p.
+func encodeCursor(p *Page, sort string) string {
+	c := pageCursor{Sort: sort, ID: p.ID}
+	switch sort {
+	case SortTitle:
+		c.Value = p.Title
+	case SortDate:
+		c.Value = p.DateCreated.UTC().Format(time.RFC3339Nano)
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor turns a cursor back into the page it points at, with only the
+// ID and sort field set.
+func decodeCursor(s, sort string) (*Page, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	var c pageCursor
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Sort != sort {
+		return nil, ErrBadCursor
+	}
+
+	p := &Page{ID: c.ID}
+	switch sort {
+	case SortTitle:
+		p.Title = c.Value
+	case SortDate:
+		p.DateCreated, err = time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, ErrBadCursor
+		}
+	}
+	return p, nil
+}
+
+// comparePages orders pages by the sort field, then by ID. Titles are
+// compared ignoring case.
+func comparePages(a, b *Page, sort string) int {
+	switch sort {
+	case SortTitle:
+		if c := strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title)); c != 0 {
+			return c
+		}
+	case SortDate:
+		if a.DateCreated.Before(b.DateCreated) {
+			return -1
+		}
+		if a.DateCreated.After(b.DateCreated) {
+			return 1
+		}
+	}
+	return a.ID - b.ID
+}
+
+// listPages runs q over every page, for the stores that can't do it in a
+// query. q must already be normalized.
+func listPages(all []*Page, q PageQuery) (*PageList, error) {
+	title := strings.ToLower(q.Title)
+	pages := []*Page{}
+	for _, p := range all {
+		if strings.Contains(strings.ToLower(p.Title), title) && matchStatus(p.Status, q.Status) {
+			pages = append(pages, p)
+		}
+	}
+
+	// before reports whether a comes before b in the listing
+	before := func(a, b *Page) bool {
+		c := comparePages(a, b, q.Sort)
+		if q.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.Slice(pages, func(i, j int) bool { return before(pages[i], pages[j]) })
+
+	start, end := q.Offset, q.Offset+q.Limit
+	switch {
+	case q.After != "":
+		c, err := decodeCursor(q.After, q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		start = sort.Search(len(pages), func(i int) bool { return before(c, pages[i]) })
+		end = start + q.Limit
+	case q.Before != "":
+		c, err := decodeCursor(q.Before, q.Sort)
+		if err != nil {
+			return nil, err
+		}
+		end = sort.Search(len(pages), func(i int) bool { return !before(pages[i], c) })
+		start = end - q.Limit
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > len(pages) {
+		start = len(pages)
+	}
+	if end > len(pages) {
+		end = len(pages)
+	}
+
+	list := &PageList{
+		Pages: pages[start:end],
+		Total: len(pages),
+	}
+	if end < len(pages) && end > start {
+		list.Next = encodeCursor(pages[end-1], q.Sort)
+	}
+	if start > 0 && end > start {
+		list.Prev = encodeCursor(pages[start], q.Sort)
+	}
+	return list, nil
+}
@@ -0,0 +1,108 @@
+package cms
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// titles returns the titles of pages, for comparing listings
+func titles(pages []*Page) []string {
+	out := []string{}
+	for _, p := range pages {
+		out = append(out, p.Title)
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_ListPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		start := time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC)
+		for i, title := range []string{"Delta", "alpha", "Charlie", "Bravo", "Echo"} {
+			_, err := CreatePage(&Page{
+				Title:       title,
+				Content:     "content",
+				DateCreated: start.Add(-time.Duration(i) * time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		list, err := ListPages(PageQuery{Limit: 2, Sort: SortTitle})
+		if err != nil {
+			t.Fatalf("Failed to list pages: %s\n", err.Error())
+		}
+		if want := []string{"alpha", "Bravo"}; !equalStrings(titles(list.Pages), want) {
+			t.Errorf("Expected %v, got %v\n", want, titles(list.Pages))
+		}
+		if list.Total != 5 || list.Next == "" || list.Prev != "" {
+			t.Errorf("Unexpected pagination: %+v\n", list)
+		}
+
+		next, err := ListPages(PageQuery{Limit: 2, Sort: SortTitle, After: list.Next})
+		if err != nil {
+			t.Fatalf("Failed to list pages: %s\n", err.Error())
+		}
+		if want := []string{"Charlie", "Delta"}; !equalStrings(titles(next.Pages), want) {
+			t.Errorf("Expected %v, got %v\n", want, titles(next.Pages))
+		}
+
+		prev, err := ListPages(PageQuery{Limit: 2, Sort: SortTitle, Before: next.Prev})
+		if err != nil {
+			t.Fatalf("Failed to list pages: %s\n", err.Error())
+		}
+		if want := []string{"alpha", "Bravo"}; !equalStrings(titles(prev.Pages), want) {
+			t.Errorf("Expected %v, got %v\n", want, titles(prev.Pages))
+		}
+
+		list, err = ListPages(PageQuery{Limit: 3, Offset: 1, Sort: SortDate, Desc: true})
+		if err != nil {
+			t.Fatalf("Failed to list pages: %s\n", err.Error())
+		}
+		if want := []string{"alpha", "Charlie", "Bravo"}; !equalStrings(titles(list.Pages), want) {
+			t.Errorf("Expected %v, got %v\n", want, titles(list.Pages))
+		}
+
+		list, err = ListPages(PageQuery{Title: "LPH"})
+		if err != nil {
+			t.Fatalf("Failed to list pages: %s\n", err.Error())
+		}
+		if want := []string{"alpha"}; !equalStrings(titles(list.Pages), want) || list.Total != 1 {
+			t.Errorf("Expected %v, got %v\n", want, titles(list.Pages))
+		}
+
+		_, err = ListPages(PageQuery{Sort: SortID, After: next.Prev})
+		if err != ErrBadCursor {
+			t.Errorf("Expected ErrBadCursor for a cursor from another sort, got %v\n", err)
+		}
+	})
+}
+
+func Test_ParsePageQuery(t *testing.T) {
+	v := url.Values{"limit": {"500"}, "sort": {"title"}, "order": {"desc"}, "title": {"go"}}
+	q, err := ParsePageQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Limit != maxPageLimit || q.Sort != SortTitle || !q.Desc || q.Title != "go" {
+		t.Errorf("Unexpected query: %+v\n", q)
+	}
+
+	_, err = ParsePageQuery(url.Values{"sort": {"content"}})
+	if err != ErrBadSort {
+		t.Errorf("Expected ErrBadSort, got %v\n", err)
+	}
+}
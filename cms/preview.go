@@ -0,0 +1,186 @@
+package cms
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// PreviewKey signs the links sharing previews of pages and posts. It's
+	// random unless the application sets it, so links stop working when the
+	// server restarts.
+	PreviewKey = randomKey()
+
+	// PreviewExpiry is how long a preview link works for.
+	PreviewExpiry = 7 * 24 * time.Hour
+)
+
+// ErrBadPreview is returned for a preview link that has expired, or that
+// wasn't made by PreviewURL.
+var ErrBadPreview = errors.New("cms: the preview link is invalid or has expired")
+
+// randomKey makes a key for signing with.
+func randomKey() []byte {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// previewSig signs the preview of a page or post on the site until expires.
+func (site *Site) previewSig(kind string, id int, expires int64) string {
+	mac := hmac.New(sha256.New, PreviewKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%d", site.Name, kind, id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// PreviewURL returns a link anybody can follow to see a page or post as it
+// stands, whatever its status, until PreviewExpiry is up. The link is made
+// absolute with the site's BaseURL so it can be shared.
+func (site *Site) PreviewURL(kind string, id int) string {
+	expires := time.Now().Add(PreviewExpiry).Unix()
+	return strings.TrimRight(site.baseURL(), "/") + "/preview/" + kind + "/" + strconv.Itoa(id) +
+		"?expires=" + strconv.FormatInt(expires, 10) + "&sig=" + site.previewSig(kind, id, expires)
+}
+
+// checkPreview checks the expiry and signature of a preview link.
+func (site *Site) checkPreview(kind string, id int, expires, sig string) error {
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > t {
+		return ErrBadPreview
+	}
+	if !hmac.Equal([]byte(sig), []byte(site.previewSig(kind, id, t))) {
+		return ErrBadPreview
+	}
+	return nil
+}
+
+// previewTerms makes the terms typed into a form into ones a preview can show,
+// without creating them.
+func previewTerms(taxonomy string, names []string) []*Term {
+	terms := []*Term{}
+	for _, name := range names {
+		t := &Term{Taxonomy: taxonomy}
+		for _, part := range strings.Split(name, "/") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			t.Name, t.Slug = strings.TrimSpace(part), Slugify(part)
+			if t.Path != "" {
+				t.Path += "/"
+			}
+			t.Path += t.Slug
+			if taxonomy == TaxonomyTag {
+				break
+			}
+		}
+		if t.Slug != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// previewHeaders keeps a preview out of caches and search engines.
+func previewHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+}
+
+// previewPage renders a page that may not be saved as readers would see it.
+func (site *Site) previewPage(w http.ResponseWriter, p *Page) {
+	err := checkStatus(&p.Status)
+	if err != nil {
+		saveError(w, err)
+		return
+	}
+	if p.Slug == "" {
+		p.Slug = Slugify(p.Title)
+	}
+	if p.DateCreated.IsZero() {
+		p.DateCreated = now()
+	}
+	p.Preview = true
+	previewHeaders(w)
+	site.render(w, "page", p)
+}
+
+// previewPost renders a post that may not be saved as readers would see it,
+// without the comment form.
+func (site *Site) previewPost(w http.ResponseWriter, p *Post) {
+	err := schedulePost(p, "")
+	if err == nil {
+		err = site.LoadFeaturedImages(p)
+	}
+	if err != nil {
+		saveError(w, err)
+		return
+	}
+	if p.Slug == "" {
+		p.Slug = Slugify(p.Title)
+	}
+	if p.DatePublished.IsZero() {
+		p.DatePublished = now()
+	}
+	p.Preview = true
+	previewHeaders(w)
+	site.render(w, "post_page", &postPage{Post: p, Static: true})
+}
+
+// ServePreview serves /preview/{kind}/{id}, the links made by PreviewURL,
+// showing a page or post as it stands to anybody who has one. Links that have
+// expired or been tampered with are refused.
+func ServePreview(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/preview/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	kind := parts[0]
+	id, err := parseID(parts[1])
+	if err != nil {
+		lookupError(w, err)
+		return
+	}
+	err = site.checkPreview(kind, id, r.FormValue("expires"), r.FormValue("sig"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch kind {
+	case KindPage:
+		p, err := site.store.GetPage(id)
+		if err == nil {
+			err = site.LoadPageTerms(p)
+		}
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		site.previewPage(w, p)
+	case KindPost:
+		p, err := site.store.GetPost(id)
+		if err == nil {
+			err = site.LoadPostTerms(p)
+		}
+		if err != nil {
+			lookupError(w, err)
+			return
+		}
+		site.previewPost(w, p)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,113 @@
+package cms
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PreviewNew(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		defer loginAs("ann", RoleAuthor)()
+
+		// Authors can't publish, but they can see what publishing would look like
+		form := url.Values{
+			"action":      {"preview"},
+			"contentType": {"post"},
+			"title":       {"Unsaved"},
+			"content":     {"Not *yet*"},
+			"status":      {StatusPublished},
+			"tags":        {"Go, Web"},
+			"categories":  {"recipes/Desserts"},
+		}
+		w := postForm(HandleNew, "/new", form)
+		body := w.Body.String()
+		if w.Code != http.StatusOK || !strings.Contains(body, "<!DOCTYPE html>") || !strings.Contains(body, "<em>yet</em>") {
+			t.Fatalf("Expected the post rendered like its public page, got %d %s\n", w.Code, body)
+		}
+		for _, link := range []string{`href="/tag/go"`, `href="/tag/web"`, `href="/category/recipes/desserts"`} {
+			if !strings.Contains(body, link) {
+				t.Errorf("Expected the preview to link %s, got %s\n", link, body)
+			}
+		}
+		if strings.Contains(body, `name="comment"`) || w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("Expected no comment form and no caching, got %v %s\n", w.Header(), body)
+		}
+
+		form.Set("contentType", "page")
+		if w = postForm(HandleNew, "/new", form); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<h1>Unsaved</h1>") {
+			t.Errorf("Expected the page preview, got %d %s\n", w.Code, w.Body.String())
+		}
+		form.Set("status", "bogus")
+		if w = postForm(HandleNew, "/new", form); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected a bad status to be refused, got %d\n", w.Code)
+		}
+
+		posts, err := GetPosts("", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := GetPages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags, err := GetTags()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 0 || len(pages) != 0 || len(tags) != 0 {
+			t.Errorf("Expected previews to save nothing, got %d posts, %d pages and %d tags\n", len(posts), len(pages), len(tags))
+		}
+	})
+}
+
+func Test_PreviewLinks(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		id, err := CreatePage(&Page{Title: "Secret plans", Content: "Coming soon"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := CreatePost(&Post{Title: "Other", Content: "Also a draft"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := get(ServePage, "/page/secret-plans"); w.Code != http.StatusNotFound {
+			t.Fatalf("Expected the draft hidden from readers, got %d\n", w.Code)
+		}
+
+		link, err := url.Parse(PreviewURL(KindPage, id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := get(ServePreview, link.RequestURI())
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Coming soon") {
+			t.Errorf("Expected the draft through its preview link, got %d %s\n", w.Code, w.Body.String())
+		}
+		if w.Header().Get("X-Robots-Tag") != "noindex" {
+			t.Errorf("Expected the preview kept out of search engines, got %v\n", w.Header())
+		}
+
+		q := link.Query()
+		for name, path := range map[string]string{
+			"another item":       "/preview/post/" + strconv.Itoa(other) + "?" + q.Encode(),
+			"a forged signature": "/preview/page/" + strconv.Itoa(id) + "?expires=" + q.Get("expires") + "&sig=AAAAAAAAAAAAAAAAAAAAAA",
+			"a later expiry":     "/preview/page/" + strconv.Itoa(id) + "?expires=" + q.Get("expires") + "0&sig=" + q.Get("sig"),
+		} {
+			if w := get(ServePreview, path); w.Code != http.StatusForbidden {
+				t.Errorf("Expected a link for %s to be refused, got %d\n", name, w.Code)
+			}
+		}
+
+		old := PreviewExpiry
+		PreviewExpiry = -time.Minute
+		expired := PreviewURL(KindPost, other)
+		PreviewExpiry = old
+		link, _ = url.Parse(expired)
+		if w := get(ServePreview, link.RequestURI()); w.Code != http.StatusForbidden {
+			t.Errorf("Expected an expired link to be refused, got %d\n", w.Code)
+		}
+	})
+}
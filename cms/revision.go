@@ -0,0 +1,100 @@
+package cms
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrBadKind is returned when something other than a page or post is asked
+// for.
+var ErrBadKind = errors.New("cms: unknown kind, use page or post")
+
+// Revision is an immutable copy of a page or post, taken every time it's
+// saved. Number counts up from 1 for each page or post.
+type Revision struct {
+	ID          int
+	Kind        string
+	ItemID      int
+	Number      int
+	Title       string
+	Content     string
+	Author      string
+	Note        string
+	DateCreated time.Time
+}
+
+// RevisionStore stores the history of pages and posts. Revisions can only be
+// added, never changed or removed.
+type RevisionStore interface {
+	// CreateRevision saves a revision, numbering it after the latest
+	// revision of the same page or post.
+	CreateRevision(r *Revision) (int, error)
+	// GetRevisions returns every revision of a page or post, newest first.
+	GetRevisions(kind string, itemID int) ([]*Revision, error)
+	GetRevision(kind string, itemID, number int) (*Revision, error)
+}
+
+// GetRevisions returns the history of a page or post, newest first.
+func (site *Site) GetRevisions(kind string, itemID int) ([]*Revision, error) {
+	if kind != KindPage && kind != KindPost {
+		return nil, ErrBadKind
+	}
+	return site.store.GetRevisions(kind, itemID)
+}
+
+// GetRevision returns a single revision of a page or post.
+func (site *Site) GetRevision(kind string, itemID, number int) (*Revision, error) {
+	if kind != KindPage && kind != KindPost {
+		return nil, ErrBadKind
+	}
+	return site.store.GetRevision(kind, itemID, number)
+}
+
+// Rollback restores a page or post to an old revision. Nothing is lost: the
+// restored content is saved as a new revision on top of the history, credited
+// to the user rolling back.
+func (site *Site) Rollback(kind string, itemID, number int, user string) error {
+	rev, err := site.GetRevision(kind, itemID, number)
+	if err != nil {
+		return err
+	}
+	note := "Rolled back to revision " + strconv.Itoa(number)
+
+	if kind == KindPage {
+		p, err := site.store.GetPage(itemID)
+		if err != nil {
+			return err
+		}
+		p.Title, p.Content = rev.Title, rev.Content
+		err = site.store.UpdatePage(p)
+		if err != nil {
+			return err
+		}
+		return site.saveRevision(KindPage, p.ID, p.Title, p.Content, user, note)
+	}
+
+	p, err := site.store.GetPost(itemID)
+	if err != nil {
+		return err
+	}
+	p.Title, p.Content = rev.Title, rev.Content
+	err = site.store.UpdatePost(p)
+	if err != nil {
+		return err
+	}
+	return site.saveRevision(KindPost, p.ID, p.Title, p.Content, user, note)
+}
+
+// saveRevision records the state of a page or post that author just saved.
+func (site *Site) saveRevision(kind string, itemID int, title, content, author, note string) error {
+	_, err := site.store.CreateRevision(&Revision{
+		Kind:    kind,
+		ItemID:  itemID,
+		Title:   title,
+		Content: content,
+		Author:  author,
+		Note:    note,
+	})
+	return err
+}
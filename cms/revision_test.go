@@ -0,0 +1,123 @@
+package cms
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_Diff(t *testing.T) {
+	got := Diff("one\ntwo\nthree\n", "one\n2\nthree\nfour")
+	want := []DiffLine{
+		{DiffSame, "one"},
+		{DiffDelete, "two"},
+		{DiffAdd, "2"},
+		{DiffSame, "three"},
+		{DiffAdd, "four"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected diff:\n%+v\nexpected:\n%+v\n", got, want)
+	}
+
+	if got := Diff("", ""); len(got) != 0 {
+		t.Errorf("Expected no lines for empty texts, got %+v\n", got)
+	}
+}
+
+func Test_Revisions(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		p := &Page{Title: "v1", Content: "first", Author: "ann"}
+		id, err := CreatePage(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.ID = id
+		p.Title, p.Content, p.Editor = "v2", "second", "bob"
+		err = UpdatePage(p)
+		if err != nil {
+			t.Fatalf("Failed to update page: %s\n", err.Error())
+		}
+
+		err = Rollback(KindPage, id, 1, "cat")
+		if err != nil {
+			t.Fatalf("Failed to roll back: %s\n", err.Error())
+		}
+		page, err := DefaultSite.store.GetPage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Title != "v1" || page.Content != "first" {
+			t.Errorf("Rollback didn't restore the page: %+v\n", page)
+		}
+
+		revs, err := GetRevisions(KindPage, id)
+		if err != nil {
+			t.Fatalf("Failed to get revisions: %s\n", err.Error())
+		}
+		if len(revs) != 3 {
+			t.Fatalf("Expected 3 revisions, got %d\n", len(revs))
+		}
+		if revs[0].Number != 3 || revs[0].Content != "first" || revs[0].Note != "Rolled back to revision 1" {
+			t.Errorf("Unexpected latest revision: %+v\n", revs[0])
+		}
+		if revs[1].Content != "second" || revs[2].Note != "Created" {
+			t.Errorf("Unexpected history: %+v %+v\n", revs[1], revs[2])
+		}
+		for i, author := range []string{"cat", "bob", "ann"} {
+			if revs[i].Author != author {
+				t.Errorf("Expected revision %d by %s, got %q\n", revs[i].Number, author, revs[i].Author)
+			}
+		}
+
+		_, err = GetRevision(KindPost, id, 1)
+		if err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for another kind, got %v\n", err)
+		}
+		_, err = GetRevisions("comment", id)
+		if err != ErrBadKind {
+			t.Errorf("Expected ErrBadKind, got %v\n", err)
+		}
+	})
+}
+
+func Test_HistoryAuthors(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		logout := loginAs("ann", RoleAuthor)
+		w := postForm(HandleNew, "/new", url.Values{"contentType": {"page"}, "title": {"Plans"}, "content": {"first"}, "status": {StatusDraft}})
+		logout()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected the page to be created, got %d %s\n", w.Code, w.Body.String())
+		}
+		page, _, err := ResolvePage("plans")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := strconv.Itoa(page.ID)
+
+		logout = loginAs("bob", RoleEditor)
+		w = postForm(ServeAdminPages, "/admin/pages/"+id+"/edit", url.Values{"title": {"Plans"}, "content": {"second"}, "version": {"1"}})
+		logout()
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected the page to be edited, got %d %s\n", w.Code, w.Body.String())
+		}
+
+		defer loginAs("cat", RoleEditor)()
+		w = postForm(ServeHistory, "/history/page/"+id+"/rollback", url.Values{"rev": {"1"}})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected the page to be rolled back, got %d %s\n", w.Code, w.Body.String())
+		}
+		body := get(ServeHistory, "/history/page/"+id).Body.String()
+		for _, row := range []string{
+			"<td>cat</td>\n        <td>Rolled back to revision 1</td>",
+			"<td>bob</td>\n        <td></td>",
+			"<td>ann</td>\n        <td>Created</td>",
+		} {
+			if !strings.Contains(body, row) {
+				t.Errorf("Expected the history to have the row %q, got %s\n", row, body)
+			}
+		}
+	})
+}
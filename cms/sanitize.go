@@ -0,0 +1,193 @@
+package cms
+
+import (
+	"bytes"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// allowedTags maps every element that may appear in rendered content to the
+// attributes it may keep. Anything else is stripped.
+var allowedTags = map[string][]string{
+	"a":          {"href", "title"},
+	"img":        {"src", "alt", "title", "width", "height"},
+	"code":       {"class"},
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"p":          nil,
+	"br":         nil,
+	"hr":         nil,
+	"em":         nil,
+	"strong":     nil,
+	"del":        nil,
+	"sup":        nil,
+	"sub":        nil,
+	"blockquote": nil,
+	"pre":        nil,
+	"ul":         nil,
+	"ol":         nil,
+	"li":         nil,
+	"table":      nil,
+	"thead":      nil,
+	"tbody":      nil,
+	"tr":         nil,
+	"th":         {"align"},
+	"td":         {"align"},
+}
+
+// voidTags never have an end tag.
+var voidTags = map[string]bool{
+	"br":  true,
+	"hr":  true,
+	"img": true,
+}
+
+// droppedTags are removed along with everything inside them, rather than
+// just losing their tags.
+var droppedTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"iframe":   true,
+	"object":   true,
+	"embed":    true,
+	"textarea": true,
+	"title":    true,
+}
+
+var (
+	// languageClass is the only class allowed, used on code blocks
+	languageClass = regexp.MustCompile(`^language-[A-Za-z0-9_+#-]+$`)
+
+	// alignValue is the only thing table cells may be aligned with
+	alignValue = regexp.MustCompile(`^(left|right|center)$`)
+)
+
+// Sanitize cleans untrusted HTML with an allow-list: only the tags and
+// attributes in allowedTags survive, links may only use http, https or mailto,
+// and the output is always well formed.
+func Sanitize(s string) string {
+	var buf bytes.Buffer
+	var open []string
+	skip := 0
+
+	z := xhtml.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			break
+		}
+		tok := z.Token()
+
+		switch tt {
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if droppedTags[tok.Data] {
+				if tt == xhtml.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			attrs, ok := allowedTags[tok.Data]
+			if !ok || skip > 0 {
+				continue
+			}
+			writeStartTag(&buf, tok, attrs)
+			if !voidTags[tok.Data] {
+				open = append(open, tok.Data)
+			}
+
+		case xhtml.EndTagToken:
+			if droppedTags[tok.Data] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+			// Close everything back to the matching start tag, and ignore
+			// end tags that were never opened
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != tok.Data {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					buf.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+
+		case xhtml.TextToken:
+			if skip == 0 {
+				buf.WriteString(html.EscapeString(tok.Data))
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		buf.WriteString("</" + open[i] + ">")
+	}
+	return buf.String()
+}
+
+// writeStartTag writes tok with only its allowed, valid attributes.
+func writeStartTag(buf *bytes.Buffer, tok xhtml.Token, allowed []string) {
+	buf.WriteString("<" + tok.Data)
+	for _, attr := range tok.Attr {
+		if attr.Namespace != "" || !contains(allowed, attr.Key) || !validAttr(attr.Key, attr.Val) {
+			continue
+		}
+		buf.WriteString(" " + attr.Key + `="` + html.EscapeString(attr.Val) + `"`)
+	}
+	if tok.Data == "a" {
+		buf.WriteString(` rel="nofollow"`)
+	}
+	buf.WriteString(">")
+}
+
+// validAttr checks the value of an attribute that has already been allowed.
+func validAttr(key, val string) bool {
+	switch key {
+	case "href", "src":
+		return safeURL(val)
+	case "class":
+		return languageClass.MatchString(val)
+	case "align":
+		return alignValue.MatchString(val)
+	case "width", "height":
+		return strings.Trim(val, "0123456789") == ""
+	}
+	return true
+}
+
+// safeURL allows relative URLs and absolute ones with a harmless scheme,
+// which rules out javascript: and data: URLs.
+func safeURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
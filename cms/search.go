@@ -0,0 +1,269 @@
+package cms
+
+import (
+	"html"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// defaultSearchLimit is how many results a search returns
+const defaultSearchLimit = 20
+
+// snippetRadius is roughly how many characters of context a snippet shows on
+// each side of the first match
+const snippetRadius = 80
+
+// SearchResult is a single page or post matching a search.
+type SearchResult struct {
+	Kind    string
+	ID      int
+	Slug    string
+	Title   string
+	Snippet template.HTML
+	Rank    float64
+}
+
+// SearchStore finds pages and posts by their title and content.
+type SearchStore interface {
+	// Search only returns pages and posts with the status, unless it's
+	// empty.
+	Search(query, status string, limit int) ([]*SearchResult, error)
+}
+
+// Search returns the pages and posts with the status matching every word of
+// query, best matches first. An empty status searches everything.
+func (site *Site) Search(query, status string) ([]*SearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return []*SearchResult{}, nil
+	}
+	return site.store.Search(query, status, defaultSearchLimit)
+}
+
+// URL is the path the result is served from.
+func (r *SearchResult) URL() string {
+	return "/" + r.Kind + "/" + r.Slug
+}
+
+// tokenize splits text into lower case words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// docKey identifies a document in a searchIndex
+type docKey struct {
+	kind string
+	id   int
+}
+
+type searchDoc struct {
+	slug    string
+	title   string
+	content string
+	status  string
+}
+
+// searchIndex is a small inverted index, used by the stores that can't search
+// for themselves. Title words count for more than content words, and rarer
+// words count for more than common ones.
+type searchIndex struct {
+	mu    sync.RWMutex
+	docs  map[docKey]searchDoc
+	terms map[string]map[docKey]float64
+}
+
+// titleWeight is how much more a word in the title counts than one in the
+// content
+const titleWeight = 3
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		docs:  map[docKey]searchDoc{},
+		terms: map[string]map[docKey]float64{},
+	}
+}
+
+// add indexes a document, replacing any older version of it.
+func (idx *searchIndex) add(kind string, id int, slug, title, content, status string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	key := docKey{kind, id}
+	idx.removeLocked(key)
+	idx.docs[key] = searchDoc{slug, title, content, status}
+
+	for _, term := range tokenize(title) {
+		idx.addTerm(term, key, titleWeight)
+	}
+	for _, term := range tokenize(content) {
+		idx.addTerm(term, key, 1)
+	}
+}
+
+func (idx *searchIndex) addTerm(term string, key docKey, weight float64) {
+	postings, ok := idx.terms[term]
+	if !ok {
+		postings = map[docKey]float64{}
+		idx.terms[term] = postings
+	}
+	postings[key] += weight
+}
+
+// remove drops a document from the index.
+func (idx *searchIndex) remove(kind string, id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(docKey{kind, id})
+}
+
+func (idx *searchIndex) removeLocked(key docKey) {
+	doc, ok := idx.docs[key]
+	if !ok {
+		return
+	}
+	for _, term := range append(tokenize(doc.title), tokenize(doc.content)...) {
+		postings := idx.terms[term]
+		delete(postings, key)
+		if len(postings) == 0 {
+			delete(idx.terms, term)
+		}
+	}
+	delete(idx.docs, key)
+}
+
+// search returns the documents with the status containing every word of
+// query, ranked by a simple tf-idf score.
+func (idx *searchIndex) search(query, status string, limit int) []*SearchResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return []*SearchResult{}
+	}
+
+	scores := map[docKey]float64{}
+	for i, term := range terms {
+		postings := idx.terms[term]
+		idf := math.Log(float64(len(idx.docs))/float64(len(postings)+1)) + 1
+		next := map[docKey]float64{}
+		for key, tf := range postings {
+			// Only keep documents that matched every earlier term too
+			if _, ok := scores[key]; ok || i == 0 {
+				next[key] = scores[key] + tf*idf
+			}
+		}
+		scores = next
+	}
+
+	results := []*SearchResult{}
+	for key, score := range scores {
+		doc := idx.docs[key]
+		if !matchStatus(doc.status, status) {
+			continue
+		}
+		results = append(results, &SearchResult{
+			Kind:    key.kind,
+			ID:      key.id,
+			Slug:    doc.slug,
+			Title:   doc.title,
+			Snippet: snippet(doc.content, terms),
+			Rank:    score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].ID < results[j].ID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// snippet cuts the text around the first search term found, escapes it, and
+// wraps every occurrence of the terms in <mark>.
+func snippet(text string, terms []string) template.HTML {
+	words := wordSpans(text)
+	first := -1
+	for i, w := range words {
+		if isTerm(text[w[0]:w[1]], terms) {
+			first = i
+			break
+		}
+	}
+
+	start, end := 0, len(text)
+	if first >= 0 {
+		start = words[first][0] - snippetRadius
+		end = words[first][1] + snippetRadius
+	} else if end > 2*snippetRadius {
+		end = 2 * snippetRadius
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	// Only cut between words, so neither words nor runes get split
+	for _, w := range words {
+		if w[0] < start && w[1] > start {
+			start = w[1]
+		}
+		if w[0] < end && w[1] > end {
+			end = w[0]
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	pos := start
+	for _, w := range words {
+		if w[0] < start || w[1] > end || !isTerm(text[w[0]:w[1]], terms) {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:w[0]]))
+		b.WriteString("<mark>" + html.EscapeString(text[w[0]:w[1]]) + "</mark>")
+		pos = w[1]
+	}
+	b.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		b.WriteString(" …")
+	}
+	return template.HTML(b.String())
+}
+
+// wordSpans returns the byte offsets of every word in text, split the same
+// way as tokenize.
+func wordSpans(text string) [][2]int {
+	spans := [][2]int{}
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(text)})
+	}
+	return spans
+}
+
+func isTerm(word string, terms []string) bool {
+	return contains(terms, strings.ToLower(word))
+}
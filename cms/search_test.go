@@ -0,0 +1,79 @@
+package cms
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_Search(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		_, err := CreatePage(&Page{Title: "Gophers", Content: "All about the gopher, a <small> burrowing rodent."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreatePost(&Post{Title: "Burrows", Content: "Where a gopher lives."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreatePost(&Post{Title: "Unrelated", Content: "Nothing to see here."})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := Search("gopher", "")
+		if err != nil {
+			t.Fatalf("Failed to search: %s\n", err.Error())
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected 2 results, got %d: %+v\n", len(results), results)
+		}
+
+		results, err = Search("gopher burrowing", "")
+		if err != nil {
+			t.Fatalf("Failed to search: %s\n", err.Error())
+		}
+		if len(results) != 1 || results[0].Kind != KindPage || results[0].Slug != "gophers" {
+			t.Fatalf("Expected only the page, got %+v\n", results)
+		}
+		snippet := string(results[0].Snippet)
+		if !strings.Contains(snippet, "<mark>burrowing</mark>") || strings.Contains(snippet, "<small>") {
+			t.Errorf("Snippet not highlighted and escaped: %s\n", snippet)
+		}
+
+		results, err = Search("   ", "")
+		if err != nil || len(results) != 0 {
+			t.Errorf("Expected no results for an empty query, got %+v, %v\n", results, err)
+		}
+	})
+}
+
+func Test_SearchIndexUpdates(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		p := &Post{Title: "Draft", Content: "kangaroo"}
+		id, err := CreatePost(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.ID = id
+		p.Content = "wallaby"
+		err = UpdatePost(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if results, _ := Search("kangaroo", ""); len(results) != 0 {
+			t.Errorf("Old content still indexed: %+v\n", results)
+		}
+		if results, _ := Search("wallaby", ""); len(results) != 1 {
+			t.Errorf("New content not indexed: %+v\n", results)
+		}
+
+		err = DeletePost(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results, _ := Search("wallaby", ""); len(results) != 0 {
+			t.Errorf("Deleted post still indexed: %+v\n", results)
+		}
+	})
+}
@@ -0,0 +1,550 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Shortcode renders a shortcode written in page or post content, like
+// {{< image id=3 >}}, to HTML. What it returns isn't sanitized, so it must
+// escape anything the author wrote: rendering a template with call.Render
+// does. An error is shown in previews in place of the shortcode, and leaves
+// it out for readers.
+type Shortcode func(call *ShortcodeCall) (template.HTML, error)
+
+// ShortcodeCall is a shortcode written in some content.
+type ShortcodeCall struct {
+	Site *Site
+	Name string
+	// Args are the shortcode's name=value arguments. Values with spaces
+	// are quoted: caption="A day out".
+	Args map[string]string
+	// Inner is the content between the shortcode and its closing
+	// {{< /name >}}, for shortcodes that wrap some. It's "" for those that
+	// aren't closed.
+	Inner string
+}
+
+// Int returns an argument that must be a whole number.
+func (call *ShortcodeCall) Int(name string) (int, error) {
+	n, err := strconv.Atoi(call.Args[name])
+	if err != nil {
+		return 0, fmt.Errorf("%s needs a number for %s", call.Name, name)
+	}
+	return n, nil
+}
+
+// Render renders one of the site's templates for the shortcode, so themes
+// can change what it looks like.
+func (call *ShortcodeCall) Render(name string, data interface{}) (template.HTML, error) {
+	t, err := call.Site.Templates()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = t.ExecuteTemplate(&buf, name, data)
+	return template.HTML(buf.String()), err
+}
+
+// shortcodes are the registered shortcodes, by name.
+var shortcodes = struct {
+	sync.RWMutex
+	m map[string]Shortcode
+}{m: map[string]Shortcode{}}
+
+// shortcodeName is what the names of shortcodes and their arguments look like
+var shortcodeName = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// RegisterShortcode makes a shortcode available to the content of every
+// site, replacing any of the same name, built in ones included. Names are
+// lower case letters, digits, - and _, starting with a letter; it panics on
+// any other.
+func RegisterShortcode(name string, sc Shortcode) {
+	if !shortcodeName.MatchString(name) {
+		panic("cms: bad shortcode name " + strconv.Quote(name))
+	}
+	shortcodes.Lock()
+	defer shortcodes.Unlock()
+	shortcodes.m[name] = sc
+}
+
+// Shortcodes returns the names of the registered shortcodes, in order.
+func Shortcodes() []string {
+	shortcodes.RLock()
+	defer shortcodes.RUnlock()
+	names := []string{}
+	for name := range shortcodes.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterShortcode("image", imageShortcode)
+	RegisterShortcode("gallery", galleryShortcode)
+	RegisterShortcode("embed", embedShortcode)
+	RegisterShortcode("callout", calloutShortcode)
+	RegisterShortcode("snippet", snippetShortcode)
+}
+
+// shortcodePart is either text or a shortcode of some content, which is the
+// Source of the shortcode, and its Call or the Err parsing it.
+type shortcodePart struct {
+	Text   string
+	Source string
+	Call   *ShortcodeCall
+	Err    error
+}
+
+// closingShortcode is the closing tag of a shortcode: the submatch is its name
+var closingShortcode = regexp.MustCompile(`\{\{<\s*/\s*([a-z][a-z0-9_-]*)\s*>\}\}`)
+
+// parseShortcodes splits content into its text and shortcodes. A shortcode
+// starts with {{< and ends with >}} on the same line; {{</* and */>}} write
+// one without expanding it.
+func parseShortcodes(src string) []shortcodePart {
+	var parts []shortcodePart
+	for {
+		i := strings.Index(src, "{{<")
+		if i < 0 {
+			return append(parts, shortcodePart{Text: src})
+		}
+		parts = append(parts, shortcodePart{Text: src[:i]})
+		src = src[i:]
+
+		line := src
+		if nl := strings.IndexByte(line, '\n'); nl >= 0 {
+			line = line[:nl]
+		}
+		end := strings.Index(line, ">}}")
+		if end < 0 {
+			parts = append(parts, shortcodePart{Source: line, Err: errors.New("isn't closed with >}} on the same line")})
+			src = src[len(line):]
+			continue
+		}
+		tag, body := src[:end+3], strings.TrimSpace(src[3:end])
+		src = src[end+3:]
+
+		if strings.HasPrefix(body, "/*") && strings.HasSuffix(body, "*/") && len(body) >= 4 {
+			parts = append(parts, shortcodePart{Text: "{{< " + strings.TrimSpace(body[2:len(body)-2]) + " >}}"})
+			continue
+		}
+		if strings.HasPrefix(body, "/") {
+			parts = append(parts, shortcodePart{Source: tag, Err: errors.New("closes a shortcode that wasn't opened")})
+			continue
+		}
+		call, err := parseShortcode(body)
+		if err != nil {
+			parts = append(parts, shortcodePart{Source: tag, Err: err})
+			continue
+		}
+
+		// A shortcode wraps the content up to its closing tag, if it has one
+		for _, loc := range closingShortcode.FindAllStringSubmatchIndex(src, -1) {
+			if src[loc[2]:loc[3]] == call.Name {
+				call.Inner = src[:loc[0]]
+				tag += src[:loc[1]]
+				src = src[loc[1]:]
+				break
+			}
+		}
+		parts = append(parts, shortcodePart{Source: tag, Call: call})
+	}
+}
+
+// parseShortcode parses what's between the {{< and >}} of a shortcode: its
+// name and then its name=value arguments.
+func parseShortcode(body string) (*ShortcodeCall, error) {
+	name := body
+	if i := strings.IndexAny(body, " \t"); i >= 0 {
+		name, body = body[:i], body[i:]
+	} else {
+		body = ""
+	}
+	if !shortcodeName.MatchString(name) {
+		return nil, fmt.Errorf("%q isn't a shortcode name", name)
+	}
+	call := &ShortcodeCall{Name: name, Args: map[string]string{}}
+
+	for {
+		body = strings.TrimLeft(body, " \t")
+		if body == "" {
+			return call, nil
+		}
+		eq := strings.IndexByte(body, '=')
+		space := strings.IndexAny(body, " \t")
+		if eq < 0 || (space >= 0 && space < eq) {
+			word := body
+			if space >= 0 {
+				word = body[:space]
+			}
+			return nil, fmt.Errorf("expected name=value, got %q", word)
+		}
+		key := body[:eq]
+		if !shortcodeName.MatchString(key) {
+			return nil, fmt.Errorf("%q isn't an argument name", key)
+		}
+		if _, ok := call.Args[key]; ok {
+			return nil, fmt.Errorf("%s is given twice", key)
+		}
+		body = body[eq+1:]
+
+		var value string
+		if strings.HasPrefix(body, `"`) {
+			end := strings.IndexByte(body[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("the quote in %s isn't closed", key)
+			}
+			value, body = body[1:end+1], body[end+2:]
+		} else {
+			end := strings.IndexAny(body, " \t")
+			if end < 0 {
+				end = len(body)
+			}
+			value, body = body[:end], body[end:]
+		}
+		call.Args[key] = value
+	}
+}
+
+// shortcodeError is what's shown in a preview in place of a shortcode that
+// can't be expanded.
+type shortcodeError struct {
+	Source string
+	Err    error
+}
+
+// expandShortcodes runs the shortcodes in content, replacing each with a
+// placeholder of letters and digits that Markdown and Sanitize leave alone.
+// The replacer then puts their HTML in place of the placeholders. Shortcodes
+// that can't be expanded are left out, or when previewing, show why.
+func (site *Site) expandShortcodes(src string, preview bool) (string, *strings.Replacer) {
+	if !strings.Contains(src, "{{<") {
+		return src, strings.NewReplacer()
+	}
+	nonce := make([]byte, 8)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		panic(err)
+	}
+	prefix := "sc" + hex.EncodeToString(nonce) + "z"
+
+	var out strings.Builder
+	var pairs []string
+	for _, part := range parseShortcodes(src) {
+		if part.Source == "" {
+			out.WriteString(part.Text)
+			continue
+		}
+		var html template.HTML
+		err := part.Err
+		if err == nil {
+			html, err = site.runShortcode(part.Call)
+		}
+		if err != nil {
+			html = ""
+			if preview {
+				call := &ShortcodeCall{Site: site}
+				html, err = call.Render("shortcode_error", &shortcodeError{part.Source, err})
+				if err != nil {
+					html = template.HTML(template.HTMLEscapeString(err.Error()))
+				}
+			}
+		}
+
+		// A shortcode on a line of its own replaces the paragraph Markdown
+		// puts it in
+		placeholder := prefix + strconv.Itoa(len(pairs)/4) + "z"
+		out.WriteString(placeholder)
+		pairs = append(pairs, "<p>"+placeholder+"</p>", string(html), placeholder, string(html))
+	}
+	return out.String(), strings.NewReplacer(pairs...)
+}
+
+// runShortcode expands one shortcode with its registered handler.
+func (site *Site) runShortcode(call *ShortcodeCall) (template.HTML, error) {
+	shortcodes.RLock()
+	sc, ok := shortcodes.m[call.Name]
+	shortcodes.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("there's no %s shortcode", call.Name)
+	}
+	call.Site = site
+	return sc(call)
+}
+
+// shortcodeImageIDs returns the images from the media library that the image
+// and gallery shortcodes in content show.
+func shortcodeImageIDs(content string) []int {
+	var ids []int
+	for _, part := range parseShortcodes(content) {
+		if part.Call == nil {
+			continue
+		}
+		var list []string
+		switch part.Call.Name {
+		case "image":
+			list = []string{part.Call.Args["id"]}
+		case "gallery":
+			list = splitIDs(part.Call.Args["ids"])
+		}
+		for _, s := range list {
+			if id, err := strconv.Atoi(s); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		// Shortcodes wrapping content can have more inside
+		ids = append(ids, shortcodeImageIDs(part.Call.Inner)...)
+	}
+	return ids
+}
+
+// splitIDs splits a list of IDs separated by commas or spaces.
+func splitIDs(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+}
+
+// shortcodeImage is an image for the image and gallery templates.
+type shortcodeImage struct {
+	*Image
+	// Src is the image at the size asked for
+	Src     string
+	Caption string
+}
+
+// loadShortcodeImage loads an image from the media library at one of the
+// ImageSizes, or at full size for "".
+func loadShortcodeImage(call *ShortcodeCall, id int, size string) (*shortcodeImage, error) {
+	if _, ok := ImageSizes[size]; size != "" && !ok {
+		return nil, fmt.Errorf("there's no %s size", size)
+	}
+	img, err := call.Site.store.GetImage(id)
+	if err == ErrNotFound {
+		return nil, fmt.Errorf("there's no image %d in the media library", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	src := img.URL()
+	if size != "" {
+		src = img.SizeURL(size)
+	}
+	return &shortcodeImage{Image: img, Src: src}, nil
+}
+
+// imageShortcode shows an image from the media library, linked to the full
+// size image: {{< image id=3 size=medium caption="..." alt="..." >}}. The
+// alt text is the image's own unless given.
+func imageShortcode(call *ShortcodeCall) (template.HTML, error) {
+	id, err := call.Int("id")
+	if err != nil {
+		return "", err
+	}
+	img, err := loadShortcodeImage(call, id, call.Args["size"])
+	if err != nil {
+		return "", err
+	}
+	img.Caption = call.Args["caption"]
+	if alt, ok := call.Args["alt"]; ok {
+		img.Alt = alt
+	}
+	return call.Render("shortcode_image", img)
+}
+
+// galleryShortcode shows images from the media library as thumbnails linked
+// to their full size, in the order given: {{< gallery ids="3 4 5" >}}. The
+// size is thumb unless given.
+func galleryShortcode(call *ShortcodeCall) (template.HTML, error) {
+	ids := splitIDs(call.Args["ids"])
+	if len(ids) == 0 {
+		return "", errors.New("gallery needs the ids of its images")
+	}
+	size, ok := call.Args["size"]
+	if !ok {
+		size = "thumb"
+	}
+	images := []*shortcodeImage{}
+	for _, s := range ids {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return "", fmt.Errorf("%q isn't an image id", s)
+		}
+		img, err := loadShortcodeImage(call, id, size)
+		if err != nil {
+			return "", err
+		}
+		images = append(images, img)
+	}
+	return call.Render("shortcode_gallery", images)
+}
+
+// EmbedHosts are the hosts the embed shortcode may put in an iframe. Links to
+// a video on YouTube or Vimeo are turned into links to their players first.
+var EmbedHosts = []string{"www.youtube-nocookie.com", "player.vimeo.com"}
+
+// youtubeID is what the IDs of YouTube videos look like
+var youtubeID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// embedURL turns a link to a video into a link to its player, and checks the
+// player is on one of the EmbedHosts.
+func embedURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("%q isn't an https link", raw)
+	}
+	youtube, video := false, ""
+	switch strings.ToLower(u.Host) {
+	case "youtube.com", "www.youtube.com", "m.youtube.com":
+		youtube = true
+		if u.Path == "/watch" {
+			video = u.Query().Get("v")
+		} else {
+			video = strings.TrimPrefix(u.Path, "/embed/")
+		}
+	case "youtu.be":
+		youtube, video = true, strings.TrimPrefix(u.Path, "/")
+	case "vimeo.com", "www.vimeo.com":
+		id := strings.TrimPrefix(u.Path, "/")
+		if _, err := strconv.Atoi(id); err != nil {
+			return "", fmt.Errorf("%q isn't a link to a video", raw)
+		}
+		u = &url.URL{Scheme: "https", Host: "player.vimeo.com", Path: "/video/" + id}
+	}
+	if youtube {
+		if !youtubeID.MatchString(video) {
+			return "", fmt.Errorf("%q isn't a link to a video", raw)
+		}
+		u = &url.URL{Scheme: "https", Host: "www.youtube-nocookie.com", Path: "/embed/" + video}
+	}
+	host := strings.ToLower(u.Host)
+	if !contains(EmbedHosts, host) {
+		return "", fmt.Errorf("%s can't be embedded", host)
+	}
+	return u.String(), nil
+}
+
+// embed is an iframe for the embed template.
+type embed struct {
+	URL   string
+	Title string
+}
+
+// embedShortcode puts a video player, or anything else on one of the
+// EmbedHosts, in an iframe: {{< embed url="https://youtu.be/..." title="..." >}}.
+func embedShortcode(call *ShortcodeCall) (template.HTML, error) {
+	src, err := embedURL(call.Args["url"])
+	if err != nil {
+		return "", err
+	}
+	title := call.Args["title"]
+	if title == "" {
+		title = "Embedded video"
+	}
+	return call.Render("shortcode_embed", &embed{src, title})
+}
+
+// CalloutKinds are the kinds of box the callout shortcode makes, each a class
+// themes can style.
+var CalloutKinds = []string{"note", "tip", "warning"}
+
+// callout is a box for the callout template.
+type callout struct {
+	Kind  string
+	Title string
+	Body  template.HTML
+}
+
+// calloutShortcode sets the Markdown it wraps apart in a box of one of the
+// CalloutKinds, a note unless given:
+// {{< callout kind=warning title="..." >}}Mind the *gap*{{< /callout >}}.
+func calloutShortcode(call *ShortcodeCall) (template.HTML, error) {
+	kind := call.Args["kind"]
+	if kind == "" {
+		kind = "note"
+	}
+	if !contains(CalloutKinds, kind) {
+		return "", fmt.Errorf("there's no %s kind of callout", kind)
+	}
+	if strings.TrimSpace(call.Inner) == "" {
+		return "", errors.New("callout needs some content, closed with {{< /callout >}}")
+	}
+	return call.Render("shortcode_callout", &callout{kind, call.Args["title"], call.Site.Markdown(call.Inner)})
+}
+
+// SnippetDir is where the files the snippet shortcode shows are kept, for
+// sites without a SnippetDir of their own.
+var SnippetDir = "snippets"
+
+// maxSnippetSize is the biggest file the snippet shortcode shows, in bytes
+const maxSnippetSize = 256 << 10
+
+// codeSnippet is code for the snippet template.
+type codeSnippet struct {
+	Lang string
+	Code string
+}
+
+// snippetShortcode shows a file in the site's SnippetDir as code, or only
+// some of its lines: {{< snippet file="hello.go" lines="3-10" lang=go >}}.
+// Cached pages aren't told when the file changes, so that needs the cache
+// purged.
+func snippetShortcode(call *ShortcodeCall) (template.HTML, error) {
+	name := call.Args["file"]
+	path := within(call.Site.snippetDir(), name)
+	if name == "" || path == "" {
+		return "", fmt.Errorf("%q isn't a file in the snippets directory", name)
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("there's no snippet %s", name)
+	}
+	if info.Size() > maxSnippetSize {
+		return "", fmt.Errorf("%s is too big to show", name)
+	}
+	lang := call.Args["lang"]
+	if lang != "" && !shortcodeName.MatchString(lang) {
+		return "", fmt.Errorf("%q isn't a language name", lang)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	code := strings.TrimRight(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	if r := call.Args["lines"]; r != "" {
+		lines := strings.Split(code, "\n")
+		from, to, err := lineRange(r, len(lines))
+		if err != nil {
+			return "", err
+		}
+		code = strings.Join(lines[from-1:to], "\n")
+	}
+	return call.Render("shortcode_snippet", &codeSnippet{lang, code})
+}
+
+// lineRange reads the lines argument of a snippet, like "3-10" or "3", for a
+// file of n lines.
+func lineRange(s string, n int) (int, int, error) {
+	first, last := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		first, last = s[:i], s[i+1:]
+	}
+	from, err1 := strconv.Atoi(strings.TrimSpace(first))
+	to, err2 := strconv.Atoi(strings.TrimSpace(last))
+	if err1 != nil || err2 != nil || from < 1 || to < from || to > n {
+		return 0, 0, fmt.Errorf("lines %q isn't a range of the file's %d lines", s, n)
+	}
+	return from, to, nil
+}
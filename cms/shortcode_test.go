@@ -0,0 +1,232 @@
+package cms
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withShortcode registers a shortcode, and returns a func unregistering it.
+func withShortcode(name string, sc Shortcode) func() {
+	RegisterShortcode(name, sc)
+	return func() {
+		shortcodes.Lock()
+		delete(shortcodes.m, name)
+		shortcodes.Unlock()
+	}
+}
+
+func Test_ParseShortcodes(t *testing.T) {
+	tests := []struct {
+		in   string
+		args map[string]string
+		err  string
+	}{
+		{`{{< image id=3 >}}`, map[string]string{"id": "3"}, ""},
+		{`{{<image id=3 caption="A day out" size=thumb>}}`, map[string]string{"id": "3", "caption": "A day out", "size": "thumb"}, ""},
+		{`{{< gallery >}}`, map[string]string{}, ""},
+		{`{{< Gallery >}}`, nil, "isn't a shortcode name"},
+		{`{{< image 3 >}}`, nil, "expected name=value"},
+		{`{{< image id=3 id=4 >}}`, nil, "given twice"},
+		{`{{< image caption="unclosed >}}`, nil, "isn't closed"},
+		{"{{< image id=3\n>}}", nil, "same line"},
+		{`{{< /image >}}`, nil, "wasn't opened"},
+	}
+	for _, tt := range tests {
+		parts := parseShortcodes(tt.in)
+		if len(parts) < 2 || parts[1].Source == "" {
+			t.Errorf("Expected %q to be a shortcode, got %+v\n", tt.in, parts)
+			continue
+		}
+		part := parts[1]
+		if tt.err != "" {
+			if part.Err == nil || !strings.Contains(part.Err.Error(), tt.err) {
+				t.Errorf("Expected %q to be refused with %q, got %v\n", tt.in, tt.err, part.Err)
+			}
+			continue
+		}
+		if part.Err != nil || len(part.Call.Args) != len(tt.args) {
+			t.Errorf("Expected %q to have %v, got %+v %v\n", tt.in, tt.args, part.Call, part.Err)
+			continue
+		}
+		for k, v := range tt.args {
+			if part.Call.Args[k] != v {
+				t.Errorf("Expected %q to have %s=%q, got %q\n", tt.in, k, v, part.Call.Args[k])
+			}
+		}
+	}
+
+	parts := parseShortcodes("a {{< note kind=tip >}}Be *kind*{{</ note >}} b")
+	if len(parts) != 3 || parts[1].Call == nil || parts[1].Call.Inner != "Be *kind*" || parts[2].Text != " b" {
+		t.Errorf("Expected the closed shortcode to wrap its content, got %+v\n", parts)
+	}
+	parts = parseShortcodes("{{< note >}}a {{< /tip >}} b{{</note>}}")
+	if len(parts) != 3 || parts[1].Call == nil || parts[1].Call.Inner != "a {{< /tip >}} b" {
+		t.Errorf("Expected the shortcode to wrap up to its own closing tag, got %+v\n", parts)
+	}
+}
+
+func Test_EmbedURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1": "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ",
+		"https://youtu.be/dQw4w9WgXcQ":                    "https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ",
+		"https://vimeo.com/76979871":                      "https://player.vimeo.com/video/76979871",
+		"https://player.vimeo.com/video/76979871":         "https://player.vimeo.com/video/76979871",
+		"http://youtu.be/dQw4w9WgXcQ":                     "",
+		"https://youtu.be/\"><script>":                    "",
+		"https://evil.example.com/frame":                  "",
+		"javascript:alert(1)":                             "",
+	} {
+		got, err := embedURL(raw)
+		if want == "" && err == nil {
+			t.Errorf("Expected %s not to be embedded, got %s\n", raw, got)
+		} else if want != "" && got != want {
+			t.Errorf("Expected %s to embed %s, got %s %v\n", raw, want, got, err)
+		}
+	}
+}
+
+func Test_Shortcodes(t *testing.T) {
+	defer withShortcode("note", func(call *ShortcodeCall) (template.HTML, error) {
+		if call.Args["kind"] == "" {
+			return "", errors.New("note needs a kind")
+		}
+		return `<aside class="` + template.HTML(template.HTMLEscapeString(call.Args["kind"])) + `">` + call.Site.Markdown(call.Inner) + "</aside>", nil
+	})()
+
+	forEachStore(t, func(t *testing.T) {
+		defer useImageDir(t)()
+		defer withCacheSize(1 << 20)()
+
+		var images []*Image
+		for _, name := range []string{"cat.png", "dog.png", "bird.png"} {
+			img, err := SaveImage(name, bytes.NewReader(testPNG(t, 2, 2)), "A "+strings.TrimSuffix(name, ".png"), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			images = append(images, img)
+		}
+		content := strings.Join([]string{
+			"# Pets",
+			`{{< image id=` + strconv.Itoa(images[0].ID) + ` size=medium caption="<b>Tom</b>" >}}`,
+			`{{< gallery ids="` + strconv.Itoa(images[1].ID) + `, ` + strconv.Itoa(images[0].ID) + `" >}}`,
+			`{{< embed url="https://youtu.be/dQw4w9WgXcQ" >}}`,
+			"{{< note kind=tip >}}Be *kind*{{< /note >}}",
+			"Write {{</* image id=1 */>}} for an image.",
+			"<iframe src=\"https://evil.example.com\"></iframe>",
+			`{{< embed url="https://evil.example.com/frame" >}}`,
+			"{{< image id=999 >}}",
+			"{{< nope >}}",
+		}, "\n\n")
+		_, err := CreatePage(&Page{Title: "Pets", Content: content, Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := get(ServePage, "/page/pets").Body.String()
+		for _, want := range []string{
+			`<figure class="image">`,
+			`<img src="/image/cat.png?size=medium" alt="A cat">`,
+			`<figcaption>&lt;b&gt;Tom&lt;/b&gt;</figcaption>`,
+			`<a href="/image/dog.png"><img src="/image/dog.png?size=thumb" alt="A dog"></a>`,
+			`<iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ"`,
+			`<aside class="tip"><p>Be <em>kind</em></p>`,
+			"Write {{&lt; image id=1 &gt;}} for an image.",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Expected the page to contain %s, got %s\n", want, body)
+			}
+		}
+		if strings.Contains(body, "evil.example.com") || strings.Contains(body, "shortcode-error") || strings.Contains(body, "<p><figure") {
+			t.Errorf("Expected bad shortcodes and hand written iframes left out, got %s\n", body)
+		}
+
+		// Previews show what's wrong
+		logout := loginAs("ann", RoleEditor)
+		w := postForm(HandleNew, "/new", url.Values{"action": {"preview"}, "contentType": {"page"}, "title": {"Pets"}, "content": {content}})
+		body = w.Body.String()
+		for _, want := range []string{
+			"evil.example.com can&#39;t be embedded",
+			"there&#39;s no image 999 in the media library",
+			"there&#39;s no nope shortcode",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Expected the preview to say %s, got %s\n", want, body)
+			}
+		}
+		if strings.Count(body, `class="shortcode-error"`) != 3 {
+			t.Errorf("Expected three errors in the preview, got %s\n", body)
+		}
+		logout()
+
+		orphans, err := OrphanedImages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(orphans) != 1 || orphans[0].ID != images[2].ID {
+			t.Errorf("Expected only bird.png to be an orphan, got %+v\n", orphans)
+		}
+
+		// The page was cached with the image's alt text
+		err = SetImageAlt(images[0].ID, "A ginger cat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body = get(ServePage, "/page/pets").Body.String(); !strings.Contains(body, `alt="A ginger cat"`) {
+			t.Errorf("Expected the page to show the new alt text, got %s\n", body)
+		}
+	})
+}
+
+func Test_CalloutAndSnippet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cms-snippets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := SnippetDir
+	SnippetDir = filepath.Join(dir, "snippets")
+	defer func() { SnippetDir = old }()
+	os.MkdirAll(SnippetDir, 0755)
+	err = ioutil.WriteFile(filepath.Join(SnippetDir, "hello.go"), []byte("package main\r\n\r\nfunc main() {\r\n\tprintln(\"<hi>\")\r\n}\r\n"), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := string(Markdown(strings.Join([]string{
+		`{{< callout kind=warning title="Careful" >}}Mind the *gap*{{< /callout >}}`,
+		`{{< snippet file="hello.go" lines="3-5" lang=go >}}`,
+	}, "\n\n")))
+	for _, want := range []string{
+		`<aside class="callout callout-warning">`,
+		`<p class="callout-title">Careful</p>`,
+		`<p>Mind the <em>gap</em></p>`,
+		"<pre class=\"snippet\"><code class=\"language-go\">func main() {\n\tprintln(&#34;&lt;hi&gt;&#34;)\n}</code></pre>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %s, got %s\n", want, body)
+		}
+	}
+
+	for src, want := range map[string]string{
+		"{{< callout kind=shout >}}Hey{{< /callout >}}": "no shout kind",
+		"{{< callout >}}":                             "needs some content",
+		`{{< snippet file="../secret.txt" >}}`:        "isn&#39;t a file in the snippets directory",
+		`{{< snippet file="gone.go" >}}`:              "no snippet gone.go",
+		`{{< snippet file="hello.go" lines="4-9" >}}`: "the file&#39;s 5 lines",
+	} {
+		if body := string(DefaultSite.markdown(src, true)); !strings.Contains(body, want) {
+			t.Errorf("Expected %s to be refused with %q, got %s\n", src, want, body)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package cms
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Site is one of the sites the cms serves. Each site has a store of its own,
+// so its pages, posts, comments, terms and images are kept apart from every
+// other site's, and it can have its own theme, base URL, title and image
+// directory. Its methods work on its content just like the package level
+// functions of the same names, which work on the DefaultSite.
+type Site struct {
+	// Name identifies the site to the commands
+	Name string
+	// Hosts are the host names the site is served at, without ports
+	Hosts []string
+	// Title, BaseURL, Theme, ImageDir, SnippetDir and Languages are the
+	// package's SiteTitle, BaseURL, Theme, ImageDir, SnippetDir and
+	// Languages when they're empty
+	Title      string
+	BaseURL    string
+	Theme      string
+	ImageDir   string
+	SnippetDir string
+	Languages  []string
+
+	store     Store
+	cache     *renderCache
+	templates templateSet
+}
+
+// DefaultSite is served to every host no other site is served at, and is the
+// site the package level functions work on. Its store is set with SetStore.
+var DefaultSite = NewSite("default", NewMemStore())
+
+// ErrSiteTaken is returned when adding a site with the name or a host of
+// another.
+var ErrSiteTaken = errors.New("cms: a site already has the name or host")
+
+// NewSite makes a site storing its content in store. Its templates are loaded
+// when it's added with AddSite.
+func NewSite(name string, store Store) *Site {
+	site := &Site{Name: name, cache: newRenderCache()}
+	site.SetStore(store)
+	return site
+}
+
+// SetStore changes the backend the site's content is stored in, emptying its
+// rendered-page cache.
+func (site *Site) SetStore(s Store) {
+	site.store = withInvalidation(s, site.cache)
+	site.cache.purge()
+}
+
+func (site *Site) title() string {
+	if site.Title != "" {
+		return site.Title
+	}
+	return SiteTitle
+}
+
+func (site *Site) baseURL() string {
+	if site.BaseURL != "" {
+		return site.BaseURL
+	}
+	return BaseURL
+}
+
+func (site *Site) theme() string {
+	if site.Theme != "" {
+		return site.Theme
+	}
+	return Theme
+}
+
+func (site *Site) imageDir() string {
+	if site.ImageDir != "" {
+		return site.ImageDir
+	}
+	return ImageDir
+}
+
+func (site *Site) snippetDir() string {
+	if site.SnippetDir != "" {
+		return site.SnippetDir
+	}
+	return SnippetDir
+}
+
+func (site *Site) languages() []string {
+	if len(site.Languages) > 0 {
+		return site.Languages
+	}
+	return Languages
+}
+
+// sites are the sites added with AddSite, by name and by host.
+var sites = struct {
+	sync.RWMutex
+	byName map[string]*Site
+	byHost map[string]*Site
+}{
+	byName: map[string]*Site{},
+	byHost: map[string]*Site{},
+}
+
+// normalizeHost lower cases a host and drops its port.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// AddSite loads a site's templates and starts serving it at its hosts.
+func AddSite(site *Site) error {
+	sites.Lock()
+	defer sites.Unlock()
+	if site.Name == "" || site.Name == DefaultSite.Name || sites.byName[site.Name] != nil {
+		return ErrSiteTaken
+	}
+	for _, host := range site.Hosts {
+		if sites.byHost[normalizeHost(host)] != nil {
+			return ErrSiteTaken
+		}
+	}
+	err := site.LoadTemplates()
+	if err != nil {
+		return err
+	}
+	sites.byName[site.Name] = site
+	for _, host := range site.Hosts {
+		sites.byHost[normalizeHost(host)] = site
+	}
+	return nil
+}
+
+// Sites returns the DefaultSite and then the added sites, by name.
+func Sites() []*Site {
+	sites.RLock()
+	defer sites.RUnlock()
+	list := []*Site{}
+	for _, site := range sites.byName {
+		list = append(list, site)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return append([]*Site{DefaultSite}, list...)
+}
+
+// GetSite returns a site by its name.
+func GetSite(name string) (*Site, error) {
+	if name == DefaultSite.Name {
+		return DefaultSite, nil
+	}
+	sites.RLock()
+	defer sites.RUnlock()
+	site, ok := sites.byName[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return site, nil
+}
+
+// siteKey marks a request as being for a site, whatever its host
+type siteKey struct{}
+
+// withSite makes a request for the site.
+func withSite(r *http.Request, site *Site) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), siteKey{}, site))
+}
+
+// SiteOf returns the site a request is for, by its Host header. Hosts no
+// site is served at get the DefaultSite.
+func SiteOf(r *http.Request) *Site {
+	if site, ok := r.Context().Value(siteKey{}).(*Site); ok {
+		return site
+	}
+	sites.RLock()
+	defer sites.RUnlock()
+	if site, ok := sites.byHost[normalizeHost(r.Host)]; ok {
+		return site
+	}
+	return DefaultSite
+}
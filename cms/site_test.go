@@ -0,0 +1,124 @@
+package cms
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addTestSite adds the site, and returns a func removing it again.
+func addTestSite(t *testing.T, site *Site) func() {
+	err := AddSite(site)
+	if err != nil {
+		t.Fatalf("Failed to add site %s: %s\n", site.Name, err)
+	}
+	return func() {
+		sites.Lock()
+		defer sites.Unlock()
+		delete(sites.byName, site.Name)
+		for _, host := range site.Hosts {
+			delete(sites.byHost, normalizeHost(host))
+		}
+	}
+}
+
+// serveHost serves a GET of path at the host with the handler.
+func serveHost(h http.HandlerFunc, host, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", "http://"+host+path, nil))
+	return w
+}
+
+func Test_SiteOf(t *testing.T) {
+	blog := NewSite("blog", NewMemStore())
+	blog.Hosts = []string{"blog.example.com", "Journal.example.com"}
+	defer addTestSite(t, blog)()
+
+	for host, want := range map[string]*Site{
+		"blog.example.com":      blog,
+		"blog.example.com:8080": blog,
+		"journal.EXAMPLE.com.":  blog,
+		"example.com":           DefaultSite,
+		"localhost:3000":        DefaultSite,
+	} {
+		if site := SiteOf(httptest.NewRequest("GET", "http://"+host+"/", nil)); site != want {
+			t.Errorf("Expected %s to be served %s, got %s\n", host, want.Name, site.Name)
+		}
+	}
+
+	for _, site := range []*Site{
+		NewSite("blog", NewMemStore()),
+		NewSite(DefaultSite.Name, NewMemStore()),
+		&Site{Name: "other", Hosts: []string{"BLOG.example.com:80"}},
+	} {
+		if err := AddSite(site); err != ErrSiteTaken {
+			t.Errorf("Expected site %s %v to be refused, got %v\n", site.Name, site.Hosts, err)
+		}
+	}
+	if site, err := GetSite("blog"); site != blog || err != nil {
+		t.Errorf("Expected to get the blog by name, got %v %v\n", site, err)
+	}
+	if _, err := GetSite("missing"); err != ErrNotFound {
+		t.Errorf("Expected an unknown site not to be found, got %v\n", err)
+	}
+	if list := Sites(); len(list) != 2 || list[0] != DefaultSite || list[1] != blog {
+		t.Errorf("Expected the default site and then the blog, got %v\n", list)
+	}
+}
+
+func Test_Sites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cms-site-theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = ioutil.WriteFile(filepath.Join(dir, "nav.gohtml"), []byte(`{{ define "nav" }}<nav>Blog nav</nav>{{ end }}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forEachStore(t, func(t *testing.T) {
+		blog := NewSite("blog", NewMemStore())
+		blog.Hosts = []string{"blog.example.com"}
+		blog.Title = "The Blog"
+		blog.Theme = dir
+		defer addTestSite(t, blog)()
+
+		_, err := CreatePost(&Post{Title: "On the default site", Content: "text", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = blog.CreatePost(&Post{Title: "On the blog", Content: "text", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := serveHost(ServeIndex, "blog.example.com", "/").Body.String()
+		if !strings.Contains(body, "On the blog") || strings.Contains(body, "On the default site") {
+			t.Errorf("Expected only the blog's post on its home page, got %s\n", body)
+		}
+		if !strings.Contains(body, "The Blog") || !strings.Contains(body, "<nav>Blog nav</nav>") {
+			t.Errorf("Expected the blog's title and theme, got %s\n", body)
+		}
+		body = serveHost(ServeIndex, "example.com", "/").Body.String()
+		if !strings.Contains(body, "On the default site") || strings.Contains(body, "On the blog") {
+			t.Errorf("Expected only the default site's post on an unknown host, got %s\n", body)
+		}
+		if strings.Contains(body, "Blog nav") {
+			t.Errorf("Expected the default site to keep its theme, got %s\n", body)
+		}
+		if w := serveHost(ServePost, "example.com", "/post/on-the-blog"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected the blog's post not to be on the default site, got %d\n", w.Code)
+		}
+
+		defer loginAs("ed", RoleEditor)()
+		body = serveHost(ServeAdminPosts, "blog.example.com", "/admin/posts").Body.String()
+		if !strings.Contains(body, "On the blog") || strings.Contains(body, "On the default site") {
+			t.Errorf("Expected the admin screen to only list the blog's posts, got %s\n", body)
+		}
+	})
+}
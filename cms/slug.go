@@ -0,0 +1,170 @@
+package cms
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The kinds of content that have slugs. They're also used to namespace slug
+// redirects, so a page and a post may share a slug.
+const (
+	KindPage = "page"
+	KindPost = "post"
+)
+
+// maxSlugLen keeps generated slugs, and so URLs, a sensible length
+const maxSlugLen = 80
+
+// ErrSlugTaken is returned when a slug is already used by another page or
+// post of the same kind.
+var ErrSlugTaken = errors.New("cms: slug already in use")
+
+// Slugify turns a title into a URL friendly slug: lower case letters and
+// digits separated by single dashes.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+
+	slug := b.String()
+	if len(slug) > maxSlugLen {
+		// Cut on a rune boundary, so multi-byte letters survive intact
+		n := maxSlugLen
+		for n > 0 && !utf8.RuneStart(slug[n]) {
+			n--
+		}
+		slug = strings.TrimRight(slug[:n], "-")
+	}
+	return slug
+}
+
+// baseSlug picks the slug to try first: the one asked for, or else one made
+// from the title. Numeric slugs would be mistaken for IDs, so they get the
+// kind as a prefix.
+func baseSlug(kind, want, title string) string {
+	slug := Slugify(want)
+	if slug == "" {
+		slug = Slugify(title)
+	}
+	if slug == "" {
+		return kind
+	}
+	if isNumeric(slug) {
+		return kind + "-" + slug
+	}
+	return slug
+}
+
+// uniqueSlug returns the base slug, or the first of base-2, base-3, ... that
+// isn't already in use.
+func (site *Site) uniqueSlug(kind, want, title string) (string, error) {
+	base := baseSlug(kind, want, title)
+	slug := base
+	for i := 2; ; i++ {
+		taken, err := site.slugTaken(kind, slug)
+		if err != nil || !taken {
+			return slug, err
+		}
+		slug = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// slugTaken reports whether a page or post currently has the slug.
+func (site *Site) slugTaken(kind, slug string) (bool, error) {
+	var err error
+	if kind == KindPage {
+		_, err = site.store.GetPageBySlug(slug)
+	} else {
+		_, err = site.store.GetPostBySlug(slug)
+	}
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func isNumeric(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// ResolvePage finds a page from the last part of its URL, which may be its
+// current slug, a slug it used to have, or its numeric ID. canonical is false
+// for anything but the current slug, so handlers can redirect to it.
+func (site *Site) ResolvePage(ref string) (p *Page, canonical bool, err error) {
+	if isNumeric(ref) {
+		p, err = site.GetPage(ref)
+		return p, false, err
+	}
+
+	p, err = site.store.GetPageBySlug(ref)
+	if err != ErrNotFound {
+		return p, true, err
+	}
+
+	id, err := site.store.GetSlugRedirect(KindPage, ref)
+	if err != nil {
+		return nil, false, err
+	}
+	p, err = site.store.GetPage(id)
+	return p, false, err
+}
+
+// ResolvePost is ResolvePage for posts.
+func (site *Site) ResolvePost(ref string) (p *Post, canonical bool, err error) {
+	if isNumeric(ref) {
+		p, err = site.GetPost(ref)
+		return p, false, err
+	}
+
+	p, err = site.store.GetPostBySlug(ref)
+	if err != ErrNotFound {
+		return p, true, err
+	}
+
+	id, err := site.store.GetSlugRedirect(KindPost, ref)
+	if err != nil {
+		return nil, false, err
+	}
+	p, err = site.store.GetPost(id)
+	return p, false, err
+}
+
+// SetPageSlug renames a page. The old slug keeps working as a redirect.
+func (site *Site) SetPageSlug(id int, slug string) error {
+	p, err := site.store.GetPage(id)
+	if err != nil {
+		return err
+	}
+	slug = baseSlug(KindPage, slug, p.Title)
+	if slug == p.Slug {
+		return nil
+	}
+	return site.store.SetPageSlug(id, slug)
+}
+
+// SetPostSlug renames a post. The old slug keeps working as a redirect.
+func (site *Site) SetPostSlug(id int, slug string) error {
+	p, err := site.store.GetPost(id)
+	if err != nil {
+		return err
+	}
+	slug = baseSlug(KindPost, slug, p.Title)
+	if slug == p.Slug {
+		return nil
+	}
+	return site.store.SetPostSlug(id, slug)
+}
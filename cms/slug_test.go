@@ -0,0 +1,109 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func Test_Slugify(t *testing.T) {
+	tests := map[string]string{
+		"About Us":            "about-us",
+		"  Hello,  World! ":   "hello-world",
+		"Go 1.12 is out":      "go-1-12-is-out",
+		"Crème brûlée":        "crème-brûlée",
+		"!!!":                 "",
+		"already-a-slug-here": "already-a-slug-here",
+	}
+	for in, want := range tests {
+		if got := Slugify(in); got != want {
+			t.Errorf("Slugify(%q) = %q, expected %q\n", in, got, want)
+		}
+	}
+}
+
+func Test_Slugs(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		first := &Page{Title: "About Us", Content: "first", Status: StatusPublished}
+		id, err := CreatePage(first)
+		if err != nil {
+			t.Fatalf("Failed to create page: %s\n", err.Error())
+		}
+		if first.Slug != "about-us" {
+			t.Errorf("Expected slug about-us, got %q\n", first.Slug)
+		}
+
+		second := &Page{Title: "About us", Content: "second"}
+		_, err = CreatePage(second)
+		if err != nil {
+			t.Fatalf("Failed to create page: %s\n", err.Error())
+		}
+		if second.Slug != "about-us-2" {
+			t.Errorf("Expected slug about-us-2, got %q\n", second.Slug)
+		}
+
+		numeric := &Page{Title: "2019", Content: "numeric"}
+		_, err = CreatePage(numeric)
+		if err != nil {
+			t.Fatalf("Failed to create page: %s\n", err.Error())
+		}
+		if numeric.Slug != "page-2019" {
+			t.Errorf("Expected slug page-2019, got %q\n", numeric.Slug)
+		}
+
+		err = SetPageSlug(id, "about-us-2")
+		if err != ErrSlugTaken {
+			t.Errorf("Expected ErrSlugTaken, got %v\n", err)
+		}
+		err = SetPageSlug(id, "Company")
+		if err != nil {
+			t.Fatalf("Failed to rename page: %s\n", err.Error())
+		}
+
+		tests := []struct {
+			path     string
+			code     int
+			location string
+		}{
+			{"/page/company", http.StatusOK, ""},
+			{"/page/about-us", http.StatusMovedPermanently, "/page/company"},
+			{"/page/" + strconv.Itoa(id), http.StatusMovedPermanently, "/page/company"},
+			{"/page/missing", http.StatusNotFound, ""},
+		}
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			ServePage(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.code {
+				t.Errorf("GET %s: expected %d, got %d\n", tt.path, tt.code, w.Code)
+			}
+			if loc := w.Header().Get("Location"); loc != tt.location {
+				t.Errorf("GET %s: expected redirect to %q, got %q\n", tt.path, tt.location, loc)
+			}
+		}
+	})
+}
+
+func Test_PostSlugs(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		p := &Post{Title: "Hello, World!", Content: "hi"}
+		id, err := CreatePost(p)
+		if err != nil {
+			t.Fatalf("Failed to create post: %s\n", err.Error())
+		}
+		p.ID = id
+		p.Slug = "hello"
+		err = UpdatePost(p)
+		if err != nil {
+			t.Fatalf("Failed to update post: %s\n", err.Error())
+		}
+
+		got, canonical, err := ResolvePost("hello-world")
+		if err != nil {
+			t.Fatalf("Failed to resolve old slug: %s\n", err.Error())
+		}
+		if canonical || got.Slug != "hello" {
+			t.Errorf("Expected a redirect to hello, got %q (canonical %v)\n", got.Slug, canonical)
+		}
+	})
+}
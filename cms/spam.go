@@ -0,0 +1,93 @@
+package cms
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linkPattern finds links in comments, whether Markdown, HTML or bare
+var linkPattern = regexp.MustCompile(`(?i)https?://|www\.|<a\s`)
+
+// SpamFilter holds the heuristics readers' comments are checked against.
+// Its fields can be changed at startup, before any comments come in.
+type SpamFilter struct {
+	// MaxLinks is how many links a comment may have before it's spam. A
+	// negative number allows any.
+	MaxLinks int
+	// BlockedWords mark a comment as spam if any of them appear in it,
+	// ignoring case
+	BlockedWords []string
+	// Honeypot names a form field hidden from people. Bots fill it in.
+	Honeypot string
+	// RateLimit is how many comments an address may leave in RateWindow.
+	// Zero allows any.
+	RateLimit  int
+	RateWindow time.Duration
+
+	mu     sync.Mutex
+	recent map[string][]time.Time
+}
+
+// Spam is the filter SubmitComment uses.
+var Spam = &SpamFilter{
+	MaxLinks:   2,
+	Honeypot:   "website",
+	RateLimit:  5,
+	RateWindow: 10 * time.Minute,
+}
+
+// Check returns why a comment looks like spam, or "" if it doesn't.
+func (f *SpamFilter) Check(c *Comment, honeypot string) string {
+	if honeypot != "" {
+		return "honeypot filled in"
+	}
+	text := c.Author + "\n" + c.Comment
+	if f.MaxLinks >= 0 && len(linkPattern.FindAllString(text, -1)) > f.MaxLinks {
+		return "too many links"
+	}
+	lower := strings.ToLower(text)
+	for _, word := range f.BlockedWords {
+		if word != "" && strings.Contains(lower, strings.ToLower(word)) {
+			return "blocked word " + word
+		}
+	}
+	return ""
+}
+
+// Allow records a comment from ip at now, and reports whether the address is
+// still within the rate limit. Comments refused for the rate don't count
+// against it.
+func (f *SpamFilter) Allow(ip string, now time.Time) bool {
+	if f.RateLimit <= 0 {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.recent == nil {
+		f.recent = map[string][]time.Time{}
+	}
+	// Forget everything outside the window, for every address, so the map
+	// doesn't grow forever
+	for addr, times := range f.recent {
+		kept := times[:0]
+		for _, t := range times {
+			if now.Sub(t) < f.RateWindow {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 0 {
+			delete(f.recent, addr)
+		} else {
+			f.recent[addr] = kept
+		}
+	}
+
+	if len(f.recent[ip]) >= f.RateLimit {
+		return false
+	}
+	f.recent[ip] = append(f.recent[ip], now)
+	return true
+}
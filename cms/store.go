@@ -0,0 +1,335 @@
+package cms
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned by every Store when the requested page, post or
+	// comment doesn't exist.
+	ErrNotFound = errors.New("cms: not found")
+
+	// ErrConflict is returned for an edit to a page that has been saved since
+	// the edit began.
+	ErrConflict = errors.New("cms: the page was changed since it was loaded")
+)
+
+// PageStore stores pages.
+type PageStore interface {
+	GetPage(id int) (*Page, error)
+	GetPageBySlug(slug string) (*Page, error)
+	GetPages() ([]*Page, error)
+	// ListPages returns a page of a listing. q has already been normalized.
+	ListPages(q PageQuery) (*PageList, error)
+	CreatePage(p *Page) (int, error)
+	// UpdatePage overwrites the title, content and status of an existing
+	// page, and sets p.Version to its next version. Unless p.Version is 0,
+	// it must be the stored version, or ErrConflict is returned.
+	UpdatePage(p *Page) error
+	// SetPageSlug changes a page's slug, keeping the old one as a redirect.
+	SetPageSlug(id int, slug string) error
+	// TrashPage moves a page to the trash, where it's left out of everything
+	// but GetTrash until it's restored or purged.
+	TrashPage(id int) error
+	// GetTrash returns the pages in the trash, most recently deleted first.
+	GetTrash() ([]*Page, error)
+	// RestorePage moves a page out of the trash, giving it the slug.
+	RestorePage(id int, slug string) error
+	// PurgePage deletes a page in the trash for good, along with its terms,
+	// translations and old slugs.
+	PurgePage(id int) error
+}
+
+// PostStore stores blog posts.
+type PostStore interface {
+	GetPost(id int) (*Post, error)
+	GetPostBySlug(slug string) (*Post, error)
+	// GetPosts returns the most recent posts with the status, or with any
+	// status if it's empty.
+	GetPosts(status string, limit int) ([]*Post, error)
+	CreatePost(p *Post) (int, error)
+	// UpdatePost overwrites the title, content, status and dates of an
+	// existing post.
+	UpdatePost(p *Post) error
+	// PublishDuePosts publishes the posts in review whose PublishAt is no
+	// later than now, dating them by it, and returns them.
+	PublishDuePosts(now time.Time) ([]*Post, error)
+	// DeletePost deletes a post along with its comments, terms,
+	// translations and old slugs.
+	DeletePost(id int) error
+	// SetPostSlug changes a post's slug, keeping the old one as a redirect.
+	SetPostSlug(id int, slug string) error
+}
+
+// CommentStore stores the comments left on posts.
+type CommentStore interface {
+	// GetComments returns the comments on a post with the status, or with
+	// any status if it's empty, oldest first.
+	GetComments(postID int, status string) ([]*Comment, error)
+	// GetCommentsByStatus returns the comments on every post with the
+	// status, oldest first.
+	GetCommentsByStatus(status string) ([]*Comment, error)
+	CreateComment(c *Comment) (int, error)
+	SetCommentStatus(id int, status string) error
+	DeleteComment(id int) error
+}
+
+// SlugStore remembers the slugs pages and posts used to have, so that old
+// links can be redirected.
+type SlugStore interface {
+	// GetSlugRedirect returns the ID of the page or post, depending on kind,
+	// that used to have the slug.
+	GetSlugRedirect(kind, slug string) (int, error)
+}
+
+// Store is implemented by every storage backend: PgStore, BoltStore and
+// MemStore.
+type Store interface {
+	PageStore
+	PostStore
+	CommentStore
+	SlugStore
+	SearchStore
+	RevisionStore
+	TaxonomyStore
+	MediaStore
+	TranslationStore
+	Close() error
+}
+
+// Open opens the named storage backend. The dsn is a connection string for
+// "postgres" and a file path for "bolt"; an empty dsn uses a sensible default.
+func Open(backend, dsn string) (Store, error) {
+	switch backend {
+	case "memory":
+		return NewMemStore(), nil
+	case "bolt":
+		if dsn == "" {
+			dsn = "cms.db"
+		}
+		return NewBoltStore(dsn)
+	case "postgres":
+		if dsn == "" {
+			dsn = "user=goprojects dbname=goprojects sslmode=disable"
+		}
+		return NewPgStore(dsn)
+	}
+	return nil, fmt.Errorf("cms: unknown store %q", backend)
+}
+
+// SetStore changes the backend of the DefaultSite, which the package level
+// functions use. It starts out in memory so that importing cms never needs a
+// database; call SetStore at startup to use something persistent.
+func SetStore(s Store) {
+	DefaultSite.SetStore(s)
+}
+
+// now is the time stamped on new records. It's in UTC and no more precise than
+// Postgres can store, so records compare equal after a round trip through any
+// store.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// parseID converts an ID taken from a URL. Anything that isn't a number can't
+// match a row, so it's reported as ErrNotFound.
+func parseID(id string) (int, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, ErrNotFound
+	}
+	return n, nil
+}
+
+// GetPage gets a single page by its ID
+func (site *Site) GetPage(id string) (*Page, error) {
+	n, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	return site.store.GetPage(n)
+}
+
+// GetPages is the new function that allows us to get every page from our db
+func (site *Site) GetPages() ([]*Page, error) {
+	return site.store.GetPages()
+}
+
+// CreatePage saves a new page and returns its ID. The page gets a unique slug
+// based on the one it has, or on its title if it has none. If the page has no
+// creation date, the current time is used, and if it has no status it's a
+// draft. The page's first revision is saved along with it, credited to its
+// author.
+func (site *Site) CreatePage(p *Page) (int, error) {
+	err := checkStatus(&p.Status)
+	if err != nil {
+		return 0, err
+	}
+	slug, err := site.uniqueSlug(KindPage, p.Slug, p.Title)
+	if err != nil {
+		return 0, err
+	}
+	p.Slug = slug
+	id, err := site.store.CreatePage(p)
+	if err != nil {
+		return 0, err
+	}
+	return id, site.saveRevision(KindPage, id, p.Title, p.Content, p.Author, "Created")
+}
+
+// UpdatePage overwrites the title, content and status of an existing page, and
+// renames it if the slug changed. An empty status leaves it as it was, and the
+// author never changes. Like every save, it adds a revision, credited to
+// p.Editor. If p.Version is
+// set, the page must not have been saved since that version was loaded, or
+// ErrConflict is returned.
+func (site *Site) UpdatePage(p *Page) error {
+	stored, err := site.store.GetPage(p.ID)
+	if err != nil {
+		return err
+	}
+	// The store checks the version again as it saves, but checking here too
+	// means a conflicting edit doesn't rename the page
+	if p.Version != 0 && p.Version != stored.Version {
+		return ErrConflict
+	}
+	// A page keeps the author who created it, whoever edits it
+	p.Author = stored.Author
+	if p.Status == "" {
+		p.Status = statusOf(stored.Status)
+	}
+	err = checkStatus(&p.Status)
+	if err != nil {
+		return err
+	}
+	if p.Slug != "" {
+		err = site.SetPageSlug(p.ID, p.Slug)
+		if err != nil {
+			return err
+		}
+	}
+	err = site.store.UpdatePage(p)
+	if err != nil {
+		return err
+	}
+	return site.saveRevision(KindPage, p.ID, p.Title, p.Content, p.Editor, "")
+}
+
+// GetPost gets a single post by its ID. Comments are loaded separately with
+// GetComments.
+func (site *Site) GetPost(id string) (*Post, error) {
+	n, err := parseID(id)
+	if err != nil {
+		return nil, err
+	}
+	return site.store.GetPost(n)
+}
+
+// GetPosts returns the most recent posts with the status, newest first. An
+// empty status returns posts with any status, and a limit of 0 or less
+// returns every post.
+func (site *Site) GetPosts(status string, limit int) ([]*Post, error) {
+	return site.store.GetPosts(status, limit)
+}
+
+// CreatePost saves a new post and returns its ID. If the post has no publish
+// date, the current time is used. Slugs and statuses work as they do for
+// CreatePage, except that a post published with a PublishAt in the future
+// stays in review until the scheduler publishes it. A featured image must be
+// in the media library.
+func (site *Site) CreatePost(p *Post) (int, error) {
+	err := schedulePost(p, "")
+	if err == nil {
+		err = site.checkFeaturedImage(p)
+	}
+	if err != nil {
+		return 0, err
+	}
+	slug, err := site.uniqueSlug(KindPost, p.Slug, p.Title)
+	if err != nil {
+		return 0, err
+	}
+	p.Slug = slug
+	id, err := site.store.CreatePost(p)
+	if err != nil {
+		return 0, err
+	}
+	return id, site.saveRevision(KindPost, id, p.Title, p.Content, p.Author, "Created")
+}
+
+// UpdatePost overwrites the title, content and status of an existing post,
+// and renames it if the slug changed. An empty status leaves it as it was.
+// A post is dated when it's published, and keeps the author who wrote it.
+// The revision it adds is credited to p.Editor.
+func (site *Site) UpdatePost(p *Post) error {
+	stored, err := site.store.GetPost(p.ID)
+	if err != nil {
+		return err
+	}
+	if p.Status == "" {
+		p.Status, p.PublishAt = statusOf(stored.Status), stored.PublishAt
+	}
+	if p.DatePublished.IsZero() {
+		p.DatePublished = stored.DatePublished
+	}
+	p.Author = stored.Author
+	err = schedulePost(p, statusOf(stored.Status))
+	if err == nil {
+		err = site.checkFeaturedImage(p)
+	}
+	if err != nil {
+		return err
+	}
+	if p.Slug != "" {
+		err := site.SetPostSlug(p.ID, p.Slug)
+		if err != nil {
+			return err
+		}
+	}
+	err = site.store.UpdatePost(p)
+	if err != nil {
+		return err
+	}
+	return site.saveRevision(KindPost, p.ID, p.Title, p.Content, p.Editor, "")
+}
+
+// DeletePost deletes a post along with all of its comments.
+func (site *Site) DeletePost(id int) error {
+	return site.store.DeletePost(id)
+}
+
+// GetComments returns the approved comments on the given post as threads:
+// the top-level comments oldest first, each with its replies nested in it up
+// to MaxCommentDepth. The comments are loaded in one go and threaded here.
+func (site *Site) GetComments(postID int) ([]*Comment, error) {
+	comments, err := site.store.GetComments(postID, CommentApproved)
+	if err != nil {
+		return nil, err
+	}
+	return threadComments(comments, MaxCommentDepth), nil
+}
+
+// CreateComment saves a new comment on a post and returns its ID. Comments
+// without a status are approved; readers' comments go through SubmitComment
+// instead.
+func (site *Site) CreateComment(c *Comment) (int, error) {
+	if c.Status == "" {
+		c.Status = CommentApproved
+	}
+	if !contains(CommentStatuses, c.Status) {
+		return 0, ErrBadStatus
+	}
+	err := site.checkParent(c)
+	if err != nil {
+		return 0, err
+	}
+	return site.store.CreateComment(c)
+}
+
+// DeleteComment deletes a comment along with its replies.
+func (site *Site) DeleteComment(id int) error {
+	return site.store.DeleteComment(id)
+}
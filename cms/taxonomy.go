@@ -0,0 +1,369 @@
+package cms
+
+import (
+	"sort"
+	"strings"
+)
+
+// The taxonomies pages and posts are grouped by. Tags are a flat list of
+// keywords; categories nest, and are named by their path from the top.
+const (
+	TaxonomyTag      = "tag"
+	TaxonomyCategory = "category"
+)
+
+// Term is a single tag or category.
+type Term struct {
+	ID       int
+	Taxonomy string
+	Slug     string
+	Name     string
+	// ParentID is the category this one is nested in, or 0 at the top
+	ParentID int
+	// Path is the slugs of the category and its parents, joined by "/". For
+	// tags it's just the slug. It isn't stored, but filled in when loaded.
+	Path string
+	// Count is how many pages and posts have the term, in a tag cloud
+	Count int
+}
+
+// TaxonomyStore stores tags and categories, and which pages and posts have
+// them.
+type TaxonomyStore interface {
+	// GetTerms returns every term of the taxonomy, in the order they were
+	// created. Paths aren't filled in.
+	GetTerms(taxonomy string) ([]*Term, error)
+	// CreateTerm saves a new term. Its slug must be unique among the terms
+	// of the taxonomy with the same parent, or ErrSlugTaken is returned.
+	CreateTerm(t *Term) (int, error)
+	// SetItemTerms replaces the terms of the taxonomy on a page or post.
+	SetItemTerms(taxonomy, kind string, itemID int, termIDs []int) error
+	// GetItemTerms returns the terms on each of the pages or posts, keyed by
+	// their ID and ordered by name.
+	GetItemTerms(kind string, itemIDs []int) (map[int][]*Term, error)
+	// GetTermContent returns the pages and posts with the status, or with
+	// any status if it's empty, that have any of the terms. Pages are ordered
+	// by title and posts newest first.
+	GetTermContent(termIDs []int, status string) ([]*Page, []*Post, error)
+	// CountTermItems counts the pages and posts with the status, or with any
+	// status if it's empty, that have each term of the taxonomy. Terms
+	// nothing has are left out.
+	CountTermItems(taxonomy, status string) (map[int]int, error)
+}
+
+// termItem records that a page or post has a term, for the stores that don't
+// have join tables.
+type termItem struct {
+	TermID int
+	Kind   string
+	ItemID int
+}
+
+// URL is the path the term's listing is served from.
+func (t *Term) URL() string {
+	return "/" + t.Taxonomy + "/" + t.Path
+}
+
+// ParseTerms splits a comma separated list of tags or category paths, as
+// typed into a form, dropping blanks.
+func ParseTerms(s string) []string {
+	terms := []string{}
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// GetTags returns every tag, ordered by name.
+func (site *Site) GetTags() ([]*Term, error) {
+	return site.getTerms(TaxonomyTag)
+}
+
+// GetCategories returns every category, ordered by path, so that each one
+// follows its parent.
+func (site *Site) GetCategories() ([]*Term, error) {
+	return site.getTerms(TaxonomyCategory)
+}
+
+// getTerms loads a taxonomy, fills in the paths and sorts it.
+func (site *Site) getTerms(taxonomy string) ([]*Term, error) {
+	terms, err := site.store.GetTerms(taxonomy)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]*Term{}
+	for _, t := range terms {
+		byID[t.ID] = t
+	}
+	for _, t := range terms {
+		t.Path = termPath(t, byID)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if taxonomy == TaxonomyTag {
+			return strings.ToLower(terms[i].Name) < strings.ToLower(terms[j].Name)
+		}
+		return terms[i].Path < terms[j].Path
+	})
+	return terms, nil
+}
+
+// termPath joins the slugs of a term and its parents. A parent that has gone
+// missing ends the path rather than looping forever.
+func termPath(t *Term, byID map[int]*Term) string {
+	path := t.Slug
+	seen := map[int]bool{t.ID: true}
+	for parent := byID[t.ParentID]; parent != nil && !seen[parent.ID]; parent = byID[parent.ParentID] {
+		seen[parent.ID] = true
+		path = parent.Slug + "/" + path
+	}
+	return path
+}
+
+// GetTag returns the tag with the slug.
+func (site *Site) GetTag(slug string) (*Term, error) {
+	return site.findTerm(TaxonomyTag, slug)
+}
+
+// GetCategory returns the category with the path.
+func (site *Site) GetCategory(path string) (*Term, error) {
+	return site.findTerm(TaxonomyCategory, strings.Trim(path, "/"))
+}
+
+func (site *Site) findTerm(taxonomy, path string) (*Term, error) {
+	terms, err := site.getTerms(taxonomy)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range terms {
+		if t.Path == path {
+			return t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// TagCloud returns every tag used by pages and posts with the status, or
+// with any status if it's empty, along with how many use it. Tags are
+// ordered by name.
+func (site *Site) TagCloud(status string) ([]*Term, error) {
+	tags, err := site.GetTags()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := site.store.CountTermItems(TaxonomyTag, status)
+	if err != nil {
+		return nil, err
+	}
+	cloud := []*Term{}
+	for _, t := range tags {
+		t.Count = counts[t.ID]
+		if t.Count > 0 {
+			cloud = append(cloud, t)
+		}
+	}
+	return cloud, nil
+}
+
+// GetTermContent returns the pages and posts with the status, or with any
+// status if it's empty, that have the term. A category's content includes
+// everything in the categories nested inside it.
+func (site *Site) GetTermContent(t *Term, status string) ([]*Page, []*Post, error) {
+	ids := []int{t.ID}
+	if t.Taxonomy == TaxonomyCategory {
+		categories, err := site.GetCategories()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range categories {
+			if strings.HasPrefix(c.Path, t.Path+"/") {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return site.store.GetTermContent(ids, status)
+}
+
+// SetTags replaces the tags on a page or post, creating any tags that don't
+// exist yet. Tags are matched by their slug, so "Go" and "go" are the same.
+func (site *Site) SetTags(kind string, id int, names []string) error {
+	if kind != KindPage && kind != KindPost {
+		return ErrBadKind
+	}
+	tags, err := site.GetTags()
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	for _, name := range names {
+		t, err := site.ensureTerm(&tags, TaxonomyTag, 0, name)
+		if err != nil {
+			return err
+		}
+		ids = appendID(ids, t.ID)
+	}
+	return site.store.SetItemTerms(TaxonomyTag, kind, id, ids)
+}
+
+// SetCategories replaces the categories of a page or post. Categories are
+// given by their path, like "recipes/desserts", and any part of it that
+// doesn't exist yet is created.
+func (site *Site) SetCategories(kind string, id int, paths []string) error {
+	if kind != KindPage && kind != KindPost {
+		return ErrBadKind
+	}
+	categories, err := site.GetCategories()
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	for _, path := range paths {
+		parent := 0
+		for _, name := range strings.Split(path, "/") {
+			if strings.TrimSpace(name) == "" {
+				continue
+			}
+			t, err := site.ensureTerm(&categories, TaxonomyCategory, parent, name)
+			if err != nil {
+				return err
+			}
+			parent = t.ID
+		}
+		if parent != 0 {
+			ids = appendID(ids, parent)
+		}
+	}
+	return site.store.SetItemTerms(TaxonomyCategory, kind, id, ids)
+}
+
+// SetTerms replaces both the tags and the categories of a page or post.
+func (site *Site) SetTerms(kind string, id int, tags, categories []string) error {
+	err := site.SetTags(kind, id, tags)
+	if err != nil {
+		return err
+	}
+	return site.SetCategories(kind, id, categories)
+}
+
+// ensureTerm finds the term with the name's slug under parent, creating it
+// and adding it to terms if there isn't one.
+func (site *Site) ensureTerm(terms *[]*Term, taxonomy string, parent int, name string) (*Term, error) {
+	name = strings.TrimSpace(name)
+	slug := Slugify(name)
+	if slug == "" {
+		slug = taxonomy
+	}
+	for _, t := range *terms {
+		if t.Slug == slug && t.ParentID == parent {
+			return t, nil
+		}
+	}
+	t := &Term{Taxonomy: taxonomy, Slug: slug, Name: name, ParentID: parent}
+	id, err := site.store.CreateTerm(t)
+	if err != nil {
+		return nil, err
+	}
+	t.ID = id
+	*terms = append(*terms, t)
+	return t, nil
+}
+
+// appendID adds id to ids unless it's already there.
+func appendID(ids []int, id int) []int {
+	for _, existing := range ids {
+		if existing == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+// LoadPageTerms fills in the tags and categories of each page.
+func (site *Site) LoadPageTerms(pages ...*Page) error {
+	ids := []int{}
+	for _, p := range pages {
+		ids = append(ids, p.ID)
+	}
+	terms, err := site.itemTerms(KindPage, ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range pages {
+		p.Tags, p.Categories = splitTerms(terms[p.ID])
+	}
+	return nil
+}
+
+// LoadPostTerms fills in the tags and categories of each post.
+func (site *Site) LoadPostTerms(posts ...*Post) error {
+	ids := []int{}
+	for _, p := range posts {
+		ids = append(ids, p.ID)
+	}
+	terms, err := site.itemTerms(KindPost, ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		p.Tags, p.Categories = splitTerms(terms[p.ID])
+	}
+	return nil
+}
+
+// itemTerms loads the terms of the items, with category paths filled in.
+func (site *Site) itemTerms(kind string, ids []int) (map[int][]*Term, error) {
+	if len(ids) == 0 {
+		return map[int][]*Term{}, nil
+	}
+	terms, err := site.store.GetItemTerms(kind, ids)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := site.GetCategories()
+	if err != nil {
+		return nil, err
+	}
+	paths := map[int]string{}
+	for _, c := range categories {
+		paths[c.ID] = c.Path
+	}
+	for _, list := range terms {
+		for _, t := range list {
+			t.Path = t.Slug
+			if t.Taxonomy == TaxonomyCategory {
+				t.Path = paths[t.ID]
+			}
+		}
+	}
+	return terms, nil
+}
+
+// splitTerms separates an item's tags from its categories.
+func splitTerms(terms []*Term) (tags, categories []*Term) {
+	for _, t := range terms {
+		if t.Taxonomy == TaxonomyTag {
+			tags = append(tags, t)
+		} else {
+			categories = append(categories, t)
+		}
+	}
+	return tags, categories
+}
+
+// sortTerms orders terms by name, for the stores that can't sort in a query.
+func sortTerms(terms []*Term) {
+	sort.Slice(terms, func(i, j int) bool {
+		a, b := strings.ToLower(terms[i].Name), strings.ToLower(terms[j].Name)
+		if a != b {
+			return a < b
+		}
+		return terms[i].ID < terms[j].ID
+	})
+}
+
+// sortPagesByTitle orders pages as GetTermContent returns them.
+func sortPagesByTitle(pages []*Page) {
+	sort.Slice(pages, func(i, j int) bool { return comparePages(pages[i], pages[j], SortTitle) < 0 })
+}
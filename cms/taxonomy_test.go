@@ -0,0 +1,187 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Tags(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		pageID, err := CreatePage(&Page{Title: "Gophers", Content: "page", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		postID, err := CreatePost(&Post{Title: "Burrows", Content: "post", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		draftID, err := CreatePost(&Post{Title: "Draft", Content: "draft"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = SetTags(KindPage, pageID, ParseTerms("Go, Animals, go"))
+		if err != nil {
+			t.Fatalf("Failed to tag page: %s\n", err.Error())
+		}
+		err = SetTags(KindPost, postID, []string{"go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetTags(KindPost, draftID, []string{"Go"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := DefaultSite.store.GetPage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = LoadPageTerms(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Tags) != 2 || page.Tags[0].Slug != "animals" || page.Tags[1].Slug != "go" {
+			t.Errorf("Expected the page tagged animals and go, got %+v\n", page.Tags)
+		}
+
+		cloud, err := TagCloud(StatusPublished)
+		if err != nil {
+			t.Fatalf("Failed to get tag cloud: %s\n", err.Error())
+		}
+		counts := map[string]int{}
+		for _, tag := range cloud {
+			counts[tag.Slug] = tag.Count
+		}
+		if len(counts) != 2 || counts["go"] != 2 || counts["animals"] != 1 {
+			t.Errorf("Unexpected tag cloud: %v\n", counts)
+		}
+
+		tag, err := GetTag("go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, posts, err := GetTermContent(tag, StatusPublished)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != 1 || len(posts) != 1 || posts[0].ID != postID {
+			t.Errorf("Expected the page and published post, got %+v, %+v\n", pages, posts)
+		}
+
+		list, err := ListPages(PageQuery{Tag: "animals"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Pages) != 1 || list.Total != 1 {
+			t.Errorf("Expected one page tagged animals, got %+v\n", list)
+		}
+		list, err = ListPages(PageQuery{Tag: "missing"})
+		if err != nil || len(list.Pages) != 0 {
+			t.Errorf("Expected no pages for an unused tag, got %+v, %v\n", list, err)
+		}
+
+		err = SetTags(KindPage, pageID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if list, _ = ListPages(PageQuery{Tag: "animals"}); len(list.Pages) != 0 {
+			t.Errorf("Tags not replaced: %+v\n", list.Pages)
+		}
+	})
+}
+
+func Test_Categories(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		cake, err := CreatePost(&Post{Title: "Cake", Content: "cake", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		soup, err := CreatePost(&Post{Title: "Soup", Content: "soup", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetCategories(KindPost, cake, []string{"Recipes/Desserts"})
+		if err != nil {
+			t.Fatalf("Failed to categorise post: %s\n", err.Error())
+		}
+		err = SetCategories(KindPost, soup, []string{"recipes"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		categories, err := GetCategories()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(categories) != 2 || categories[0].Path != "recipes" || categories[1].Path != "recipes/desserts" {
+			t.Fatalf("Expected recipes and recipes/desserts, got %+v\n", categories)
+		}
+
+		recipes, err := GetCategory("recipes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, posts, err := GetTermContent(recipes, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 2 {
+			t.Errorf("Expected a category to include its subcategories, got %+v\n", posts)
+		}
+
+		desserts, err := GetCategory("/recipes/desserts/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, posts, err = GetTermContent(desserts, "")
+		if err != nil || len(posts) != 1 || posts[0].ID != cake {
+			t.Errorf("Expected only the cake, got %+v, %v\n", posts, err)
+		}
+
+		if _, err = GetCategory("desserts"); err != ErrNotFound {
+			t.Errorf("Expected ErrNotFound for a partial path, got %v\n", err)
+		}
+	})
+}
+
+func Test_ServeTaxonomy(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		id, err := CreatePost(&Post{Title: "Tagged post", Content: "post", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetTerms(KindPost, id, []string{"Go"}, []string{"Programming/Languages"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			path string
+			code int
+			want string
+		}{
+			{"/tag/", http.StatusOK, "Go</a> (1)"},
+			{"/tag/go", http.StatusOK, "Tagged post"},
+			{"/tag/missing", http.StatusNotFound, ""},
+			{"/category/", http.StatusOK, "programming/languages"},
+			{"/category/programming", http.StatusOK, "Tagged post"},
+			{"/category/languages", http.StatusNotFound, ""},
+		}
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			mux := http.NewServeMux()
+			mux.HandleFunc("/tag/", ServeTag)
+			mux.HandleFunc("/category/", ServeCategory)
+			mux.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.code {
+				t.Errorf("GET %s: expected %d, got %d\n", tt.path, tt.code, w.Code)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("GET %s: expected %q in %s\n", tt.path, tt.want, w.Body.String())
+			}
+		}
+	})
+}
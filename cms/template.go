@@ -2,38 +2,103 @@ package cms
 
 import (
 	"html/template"
-	"os"
+	"path/filepath"
+	"runtime"
 	"time"
 )
 
-var tmplPath = os.Getenv("GOPATH") + "/src/github.com/jywei/toy-projects/cms/templates"
+// funcs are the helpers available to every template
+var funcs = template.FuncMap{
+	"head": head,
+}
 
-// Tmpl is a reference to all of our templates
-// ParseGlob would return a template and error, and Must will do the eror checking
-var Tmpl = template.Must(template.ParseGlob(tmplPath))
+// sourceDir returns the directory this file was compiled from
+func sourceDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}
 
 // Page is the struct used for each webpage
 type Page struct {
-	ID      int
-	Title   string
-	Content string
-	Posts   []*Post
+	ID          int
+	Slug        string
+	Title       string
+	Content     string
+	Status      string
+	DateCreated time.Time
+	// Author is the user who created the page, or "" for pages from before
+	// there were logins
+	Author string
+	// Version counts the saves of the page, so an edit can tell whether it
+	// changed since the form was loaded
+	Version int
+	// Editor is the user saving the page, who UpdatePage credits with the
+	// revision. It isn't stored.
+	Editor string
+	// DeletedAt is when the page was moved to the trash. It's zero for the
+	// pages that aren't there.
+	DeletedAt time.Time
+	// Lang is the language the title and content are in, and Alternates
+	// link to the page in the others. They aren't stored, but filled in by
+	// TranslatePages.
+	Lang       string
+	Alternates []Alternate
+	// Preview is set when the page is rendered as a preview, which shows
+	// what's wrong with the shortcodes in its content rather than leaving
+	// them out. It isn't stored.
+	Preview    bool
+	Tags       []*Term
+	Categories []*Term
+	Posts      []*Post
 }
 
 // Post is the struct used for each blog post
 type Post struct {
 	ID            int
+	Slug          string
 	Title         string
 	Content       string
+	Status        string
 	DatePublished time.Time
-	Comments      []*Comment
+	// PublishAt is when the scheduler publishes a post that's in review.
+	// It's zero for posts that aren't scheduled.
+	PublishAt time.Time
+	// Author is the user who wrote the post, or "" for posts from before
+	// there were logins
+	Author string
+	// Editor is the user saving the post, like a page's
+	Editor string
+	// FeaturedImageID is the image from the media library shown with the
+	// post, or 0 for none. FeaturedImage is filled in by
+	// LoadFeaturedImages.
+	FeaturedImageID int
+	FeaturedImage   *Image
+	// Lang and Alternates are filled in by TranslatePosts, and Preview is
+	// set when previewing, like a page's
+	Lang       string
+	Alternates []Alternate
+	Preview    bool
+	Tags       []*Term
+	Categories []*Term
+	Comments   []*Comment
 }
 
 // Comment is the struct used for each comment
 type Comment struct {
-	ID            int
-	PostID        int
+	ID     int
+	PostID int
+	// ParentID is the comment this one replies to, or 0 if it's not a reply
+	ParentID      int
 	Author        string
 	Comment       string
+	Status        string
 	DatePublished time.Time
+	// IP is the address the comment was submitted from, for moderators
+	IP string
+	// Depth and Replies place the comment in its thread. They aren't stored,
+	// but filled in by GetComments.
+	Depth   int
+	Replies []*Comment
+	// ReplyURL links to the form replying to the comment, when there is one
+	ReplyURL string
 }
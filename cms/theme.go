@@ -0,0 +1,225 @@
+package cms
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// DefaultTheme is the directory of the theme every other theme builds on.
+	// It has every template the cms renders, and the static assets they use.
+	// It's found next to this source file, so it's there from tests and from
+	// cms/cmd no matter where the repo is checked out, but a deployed binary
+	// points it wherever the templates were copied to.
+	DefaultTheme = filepath.Join(sourceDir(), "templates")
+
+	// Theme is the directory of the theme in use, or "" for just the default
+	// theme. A theme only needs the templates and assets it changes: anything
+	// missing from it comes from the default theme.
+	Theme = ""
+
+	// DevMode reparses the templates whenever their files change, so themes
+	// can be worked on without restarting.
+	DevMode = false
+)
+
+// ErrNoTemplates is returned when rendering without any templates loaded.
+var ErrNoTemplates = errors.New("cms: no templates loaded")
+
+// Head is what a page gives the layout's header. Pages make one with the head
+// template func: {{ template "header" (head "Title") }}, or with the path of
+// a feed besides the site's, {{ template "header" (head "Title" "/tag/go") }}.
+type Head struct {
+	Title string
+	// Feed is a path with feed.rss and feed.atom under it
+	Feed string
+	// Lang is the language of the page, and Alternates link to it in the
+	// others. Pages of content set them with the Translated method:
+	// {{ template "header" ((head .Title).Translated .Lang .Alternates) }}.
+	Lang       string
+	Alternates []Alternate
+}
+
+// Translated gives the head the language of the page and its alternates.
+func (h Head) Translated(lang string, alternates []Alternate) Head {
+	h.Lang, h.Alternates = lang, alternates
+	return h
+}
+
+func head(title string, feed ...string) Head {
+	h := Head{Title: title}
+	if len(feed) > 0 {
+		h.Feed = feed[0]
+	}
+	return h
+}
+
+// themeDirs are the directories templates and assets are looked up in, the
+// default theme first so the theme can override it.
+func (site *Site) themeDirs() []string {
+	theme := site.theme()
+	if theme == "" || theme == DefaultTheme {
+		return []string{DefaultTheme}
+	}
+	return []string{DefaultTheme, theme}
+}
+
+// templateSet holds a site's parsed templates, and the state of the files
+// they were parsed from, so DevMode can tell when they change.
+type templateSet struct {
+	sync.Mutex
+	tmpl  *template.Template
+	stamp string
+	err   error
+}
+
+func init() {
+	// Templates that fail to parse now are reported when a page is
+	// rendered, giving the application a chance to move DefaultTheme and call
+	// LoadTemplates first
+	DefaultSite.templates.err = DefaultSite.LoadTemplates()
+}
+
+// LoadTemplates parses the templates of the default theme and then of the
+// site's theme, whose templates replace any of the same name. Call it after
+// changing DefaultTheme or the theme.
+func (site *Site) LoadTemplates() error {
+	site.templates.Lock()
+	defer site.templates.Unlock()
+	return site.loadTemplates()
+}
+
+func (site *Site) loadTemplates() error {
+	stamp, err := site.themeStamp()
+	if err == nil {
+		t := template.New("cms").Funcs(funcs).Funcs(template.FuncMap{"site": site.title, "markdown": site.markdown})
+		for _, dir := range site.themeDirs() {
+			var files []string
+			files, err = filepath.Glob(filepath.Join(dir, "*.gohtml"))
+			if err != nil {
+				break
+			}
+			if len(files) == 0 {
+				err = fmt.Errorf("cms: no templates in %s", dir)
+				break
+			}
+			t, err = t.ParseFiles(files...)
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			site.templates.tmpl, site.templates.stamp = t, stamp
+			site.cache.purge()
+		}
+	}
+	site.templates.err = err
+	return err
+}
+
+// themeStamp sums up the names, sizes and modification times of the
+// templates, which change whenever a template is edited, added or removed.
+func (site *Site) themeStamp() (string, error) {
+	var stamp []string
+	for _, dir := range site.themeDirs() {
+		files, err := filepath.Glob(filepath.Join(dir, "*.gohtml"))
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				return "", err
+			}
+			stamp = append(stamp, fmt.Sprintf("%s %d %d", file, info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	sort.Strings(stamp)
+	return strings.Join(stamp, "\n"), nil
+}
+
+// Templates returns the parsed templates, first reparsing them if DevMode is
+// on and they've changed.
+func (site *Site) Templates() (*template.Template, error) {
+	site.templates.Lock()
+	defer site.templates.Unlock()
+
+	if DevMode {
+		stamp, err := site.themeStamp()
+		if err != nil {
+			return nil, err
+		}
+		if stamp != site.templates.stamp {
+			err = site.loadTemplates()
+			if err != nil {
+				// Keep showing the error until the template is fixed
+				site.templates.stamp = stamp
+				log.Printf("cms: reloading templates: %s", err)
+			}
+		}
+	}
+	if site.templates.err != nil {
+		return nil, site.templates.err
+	}
+	if site.templates.tmpl == nil {
+		return nil, ErrNoTemplates
+	}
+	return site.templates.tmpl, nil
+}
+
+// render executes a template into the response. It's rendered in full first,
+// so a template that fails gives a clean 500 rather than half a page.
+func (site *Site) render(w http.ResponseWriter, name string, data interface{}) {
+	site.renderStatus(w, http.StatusOK, name, data)
+}
+
+// renderStatus is render with a status code other than 200.
+func (site *Site) renderStatus(w http.ResponseWriter, status int, name string, data interface{}) {
+	t, err := site.Templates()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	err = t.ExecuteTemplate(&buf, name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// ServeTheme serves the static assets of the theme at /theme/, from the
+// theme's static directory or else the default theme's.
+func ServeTheme(w http.ResponseWriter, r *http.Request) {
+	site := SiteOf(r)
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/theme/"))
+	dirs := site.themeDirs()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		f, err := http.Dir(filepath.Join(dirs[i], "static")).Open(name)
+		if err != nil {
+			continue
+		}
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			f.Close()
+			continue
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+		f.Close()
+		return
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,101 @@
+package cms
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTheme switches to the theme in dir, and returns a func switching back.
+func useTheme(t *testing.T, dir string, dev bool) func() {
+	oldTheme, oldDev := Theme, DevMode
+	Theme, DevMode = dir, dev
+	err := LoadTemplates()
+	if err != nil {
+		t.Fatalf("Failed to load the theme: %s\n", err)
+	}
+	return func() {
+		Theme, DevMode = oldTheme, oldDev
+		LoadTemplates()
+	}
+}
+
+func Test_Theme(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cms-theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = os.Mkdir(filepath.Join(dir, "static"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "nav.gohtml"), []byte(`{{ define "nav" }}<nav>Themed</nav>{{ end }}`), 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dir, "static", "style.css"), []byte("body { color: red; }"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer useTheme(t, dir, false)()
+
+	forEachStore(t, func(t *testing.T) {
+		w := httptest.NewRecorder()
+		ServeTag(w, httptest.NewRequest("GET", "/tag/", nil))
+		body := w.Body.String()
+		if !strings.Contains(body, "<nav>Themed</nav>") {
+			t.Errorf("Expected the theme's nav, got %s\n", body)
+		}
+		if !strings.Contains(body, "<h1>Tags</h1>") {
+			t.Errorf("Expected the default theme's template for the page, got %s\n", body)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	ServeTheme(w, httptest.NewRequest("GET", "/theme/style.css", nil))
+	if w.Body.String() != "body { color: red; }" {
+		t.Errorf("Expected the theme's stylesheet, got %q\n", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	ServeTheme(w, httptest.NewRequest("GET", "/theme/../theme.go", nil))
+	if w.Code != 404 {
+		t.Errorf("Expected 404 outside the static directory, got %d\n", w.Code)
+	}
+}
+
+func Test_ThemeReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cms-theme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	nav := filepath.Join(dir, "nav.gohtml")
+	err = ioutil.WriteFile(nav, []byte(`{{ define "nav" }}<nav>Before</nav>{{ end }}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer useTheme(t, dir, true)()
+
+	renderNav := func() string {
+		w := httptest.NewRecorder()
+		DefaultSite.render(w, "nav", nil)
+		return w.Body.String()
+	}
+	if !strings.Contains(renderNav(), "Before") {
+		t.Fatalf("Expected the theme's nav\n")
+	}
+
+	err = ioutil.WriteFile(nav, []byte(`{{ define "nav" }}<nav>After</nav>{{ end }}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the change shows even on file systems with coarse times
+	later := time.Now().Add(time.Second)
+	os.Chtimes(nav, later, later)
+	if !strings.Contains(renderNav(), "After") {
+		t.Errorf("Expected the edited nav after a reload\n")
+	}
+}
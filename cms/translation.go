@@ -0,0 +1,344 @@
+package cms
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Languages are the languages a site without Languages of its own can be read
+// in, as lower case tags like "en" or "pt-br". Pages and posts are written in
+// the first, and translated into the others. There must be at least one.
+var Languages = []string{"en"}
+
+// ErrBadLang is returned for a translation into a language that isn't one of
+// the site's languages, or into the one pages and posts are written in.
+var ErrBadLang = errors.New("cms: unknown language to translate into")
+
+// Translation is a page or post in another of its site's languages. The page
+// or post it translates is the canonical one: it keeps the slug, status,
+// terms and everything else, and it stands in for whatever the translation
+// leaves blank.
+type Translation struct {
+	ID          int
+	Kind        string
+	ItemID      int
+	Lang        string
+	Title       string
+	Content     string
+	DateUpdated time.Time
+}
+
+// TranslationStore stores the translations of pages and posts. A page or post
+// has at most one translation into each language.
+type TranslationStore interface {
+	// SetTranslation saves a translation, replacing the one the page or post
+	// already had into the language, and returns its ID.
+	SetTranslation(t *Translation) (int, error)
+	// GetTranslations returns the translations of each of the pages or
+	// posts, keyed by their ID and ordered by language.
+	GetTranslations(kind string, itemIDs []int) (map[int][]*Translation, error)
+	DeleteTranslation(kind string, itemID int, lang string) error
+}
+
+// Alternate links to a page in one of the languages it can be read in, for
+// the hreflang links in its head.
+type Alternate struct {
+	// Lang is the language, or x-default for the page to show readers
+	// whose language it isn't in
+	Lang string
+	URL  string
+}
+
+// langKey marks a request as being for a language, whatever the reader's
+// Accept-Language header asks for
+type langKey struct{}
+
+// matchLang returns the one of the site's languages a language tag asks for,
+// or "" if none. A tag for a regional variant, like fr-ca, gets the language
+// without the region if it isn't one of the languages itself.
+func (site *Site) matchLang(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if contains(site.languages(), tag) {
+		return tag
+	}
+	if i := strings.Index(tag, "-"); i > 0 && contains(site.languages(), tag[:i]) {
+		return tag[:i]
+	}
+	return ""
+}
+
+// negotiateLang picks the language an Accept-Language header likes best of
+// the site's languages, or the first of them if it likes none.
+func (site *Site) negotiateLang(header string) string {
+	best, bestQ := site.languages()[0], 0.0
+	for _, accepted := range strings.Split(header, ",") {
+		params := strings.Split(accepted, ";")
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				q, err = strconv.ParseFloat(param[2:], 64)
+				if err != nil {
+					q = 0
+				}
+			}
+		}
+		if lang := site.matchLang(params[0]); lang != "" && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// LangOf returns the language to serve a request in: the one its path was
+// prefixed with under /lang/, or else the one its Accept-Language header
+// likes best.
+func LangOf(r *http.Request) string {
+	if lang, ok := r.Context().Value(langKey{}).(string); ok {
+		return lang
+	}
+	return SiteOf(r).negotiateLang(r.Header.Get("Accept-Language"))
+}
+
+// langPath is the path of a page in the language.
+func langPath(lang, path string) string {
+	return "/lang/" + lang + path
+}
+
+// localPath keeps a path in the language of the request, if it was prefixed
+// with one under /lang/.
+func localPath(r *http.Request, path string) string {
+	if lang, ok := r.Context().Value(langKey{}).(string); ok {
+		return langPath(lang, path)
+	}
+	return path
+}
+
+// LangPrefix serves /lang/{lang}/... with h as if it were ..., in the
+// language rather than the one the reader's Accept-Language header asks for.
+// Only the languages of the request's site are found.
+func LangPrefix(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/lang/")
+		lang, path := rest, "/"
+		if i := strings.Index(rest, "/"); i >= 0 {
+			lang, path = rest[:i], rest[i:]
+		}
+		if !contains(SiteOf(r).languages(), lang) {
+			http.NotFound(w, r)
+			return
+		}
+		if path == "/" && !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, langPath(lang, "/"), http.StatusMovedPermanently)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), langKey{}, lang))
+		u := *r.URL
+		u.Path, u.RawPath = path, ""
+		r.URL = &u
+		h.ServeHTTP(w, r)
+	})
+}
+
+// alternates links to the page at path in each of the languages, the first
+// of them being the default. A page in only one language has none.
+func alternates(path string, langs []string) []Alternate {
+	if len(langs) < 2 {
+		return nil
+	}
+	list := []Alternate{{Lang: "x-default", URL: path}}
+	for _, lang := range langs {
+		list = append(list, Alternate{Lang: lang, URL: langPath(lang, path)})
+	}
+	return list
+}
+
+// translate swaps the title and content of a page or post for their
+// translation into lang, if there is one, and returns the language they're
+// then in along with the alternates of the page or post at path.
+func (site *Site) translate(lang, path string, translations []*Translation, title, content *string) (string, []Alternate) {
+	in, langs := site.languages()[0], []string{site.languages()[0]}
+	for _, t := range translations {
+		// Translations into a language that was since dropped are kept,
+		// but not shown
+		if !contains(site.languages(), t.Lang) {
+			continue
+		}
+		langs = append(langs, t.Lang)
+		if t.Lang != lang {
+			continue
+		}
+		in = lang
+		if t.Title != "" {
+			*title = t.Title
+		}
+		if t.Content != "" {
+			*content = t.Content
+		}
+	}
+	return in, alternates(path, langs)
+}
+
+// TranslatePages puts the pages into lang, where they've been translated into
+// it, and links each to its other languages.
+func (site *Site) TranslatePages(lang string, pages ...*Page) error {
+	ids := make([]int, len(pages))
+	for i, p := range pages {
+		ids[i] = p.ID
+	}
+	translations, err := site.store.GetTranslations(KindPage, ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range pages {
+		p.Lang, p.Alternates = site.translate(lang, "/page/"+p.Slug, translations[p.ID], &p.Title, &p.Content)
+	}
+	return nil
+}
+
+// TranslatePosts puts the posts into lang, where they've been translated into
+// it, and links each to its other languages.
+func (site *Site) TranslatePosts(lang string, posts ...*Post) error {
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	translations, err := site.store.GetTranslations(KindPost, ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		p.Lang, p.Alternates = site.translate(lang, "/post/"+p.Slug, translations[p.ID], &p.Title, &p.Content)
+	}
+	return nil
+}
+
+// GetTranslations returns the translations of a page or post, by language.
+func (site *Site) GetTranslations(kind string, itemID int) ([]*Translation, error) {
+	if kind != KindPage && kind != KindPost {
+		return nil, ErrBadKind
+	}
+	translations, err := site.store.GetTranslations(kind, []int{itemID})
+	if err != nil {
+		return nil, err
+	}
+	if translations[itemID] == nil {
+		return []*Translation{}, nil
+	}
+	return translations[itemID], nil
+}
+
+// untranslated returns the title and content of a page or post as written.
+func (site *Site) untranslated(kind string, itemID int) (string, string, error) {
+	switch kind {
+	case KindPage:
+		p, err := site.store.GetPage(itemID)
+		if err != nil {
+			return "", "", err
+		}
+		return p.Title, p.Content, nil
+	case KindPost:
+		p, err := site.store.GetPost(itemID)
+		if err != nil {
+			return "", "", err
+		}
+		return p.Title, p.Content, nil
+	}
+	return "", "", ErrBadKind
+}
+
+// SetTranslation translates a page or post into one of the site's languages
+// but the first, replacing the translation it had. A translation with neither
+// a title nor content deletes the one there was.
+func (site *Site) SetTranslation(t *Translation) error {
+	t.Lang = strings.ToLower(t.Lang)
+	if !contains(site.languages()[1:], t.Lang) {
+		return ErrBadLang
+	}
+	_, _, err := site.untranslated(t.Kind, t.ItemID)
+	if err != nil {
+		return err
+	}
+	if t.Title == "" && t.Content == "" {
+		err = site.store.DeleteTranslation(t.Kind, t.ItemID, t.Lang)
+		if err == ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	t.DateUpdated = now()
+	t.ID, err = site.store.SetTranslation(t)
+	return err
+}
+
+// TranslationStatus is how far a page or post has been translated.
+type TranslationStatus struct {
+	Kind   string
+	ItemID int
+	Title  string
+	URL    string
+	// Translated are the languages it's been translated into, and Missing
+	// the rest of the site's languages but the first, in the same order
+	Translated []string
+	Missing    []string
+}
+
+// GetTranslationStatus returns how far every page and then every post has been
+// translated into the site's languages.
+func (site *Site) GetTranslationStatus() ([]*TranslationStatus, error) {
+	pages, err := site.store.GetPages()
+	if err != nil {
+		return nil, err
+	}
+	posts, err := site.store.GetPosts("", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []*TranslationStatus{}
+	add := func(kind string, translations map[int][]*Translation, id int, title, url string) {
+		status := &TranslationStatus{Kind: kind, ItemID: id, Title: title, URL: url}
+		have := map[string]bool{}
+		for _, t := range translations[id] {
+			have[t.Lang] = true
+		}
+		for _, lang := range site.languages()[1:] {
+			if have[lang] {
+				status.Translated = append(status.Translated, lang)
+			} else {
+				status.Missing = append(status.Missing, lang)
+			}
+		}
+		list = append(list, status)
+	}
+
+	ids := make([]int, len(pages))
+	for i, p := range pages {
+		ids[i] = p.ID
+	}
+	translations, err := site.store.GetTranslations(KindPage, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pages {
+		add(KindPage, translations, p.ID, p.Title, "/page/"+p.Slug)
+	}
+
+	ids = make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	translations, err = site.store.GetTranslations(KindPost, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range posts {
+		add(KindPost, translations, p.ID, p.Title, "/post/"+p.Slug)
+	}
+	return list, nil
+}
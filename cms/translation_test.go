@@ -0,0 +1,231 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// withLanguages sets the Languages, and returns a func setting them back.
+func withLanguages(langs ...string) func() {
+	old := Languages
+	Languages = langs
+	return func() { Languages = old }
+}
+
+func Test_NegotiateLang(t *testing.T) {
+	defer withLanguages("en", "fr", "pt-br")()
+
+	for header, want := range map[string]string{
+		"":                         "en",
+		"fr":                       "fr",
+		"FR-ca, en;q=0.5":          "fr",
+		"de, en;q=0.2, fr;q=0.8":   "fr",
+		"pt-BR,pt;q=0.9":           "pt-br",
+		"pt":                       "en",
+		"fr;q=0, en;q=0.1":         "en",
+		"*":                        "en",
+		"de;q=oops, fr;q=0.3, es":  "fr",
+		"en-gb;q=0.9, fr-fr;q=0.4": "en",
+	} {
+		if lang := DefaultSite.negotiateLang(header); lang != want {
+			t.Errorf("Accept-Language %q: expected %s, got %s\n", header, want, lang)
+		}
+	}
+}
+
+func Test_SiteLanguages(t *testing.T) {
+	defer withLanguages("en", "fr")()
+	blog := NewSite("blog", NewMemStore())
+	blog.Hosts = []string{"blog.example.com"}
+	blog.Languages = []string{"de", "en"}
+	defer addTestSite(t, blog)()
+
+	if lang := blog.negotiateLang("fr, en;q=0.5"); lang != "en" {
+		t.Errorf("Expected the blog's languages to be negotiated, got %s\n", lang)
+	}
+	if lang := blog.negotiateLang("fr"); lang != "de" {
+		t.Errorf("Expected the blog's first language by default, got %s\n", lang)
+	}
+	id, err := blog.CreatePage(&Page{Title: "Hallo", Slug: "hallo", Content: "Guten Morgen", Status: StatusPublished})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = blog.SetTranslation(&Translation{Kind: KindPage, ItemID: id, Lang: "fr", Title: "x"}); err != ErrBadLang {
+		t.Errorf("Expected no translation into a language of the other site, got %v\n", err)
+	}
+	if err = blog.SetTranslation(&Translation{Kind: KindPage, ItemID: id, Lang: "en", Title: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page/", ServePage)
+	mux.Handle("/lang/", LangPrefix(mux))
+	for path, want := range map[string]int{
+		"http://blog.example.com/lang/en/page/hallo": http.StatusOK,
+		"http://blog.example.com/lang/fr/page/hallo": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("Expected %d for %s, got %d\n", want, path, w.Code)
+		}
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "http://blog.example.com/lang/en/page/hallo", nil))
+	if body := w.Body.String(); !strings.Contains(body, "<h1>Hello</h1>") || !strings.Contains(body, `hreflang="de" href="/lang/de/page/hallo"`) {
+		t.Errorf("Expected the page in English with the blog's alternates, got %s\n", body)
+	}
+}
+
+func Test_Translations(t *testing.T) {
+	defer withLanguages("en", "fr")()
+
+	forEachStore(t, func(t *testing.T) {
+		defer withCacheSize(1 << 20)()
+
+		pageID, err := CreatePage(&Page{Title: "Hello", Slug: "hello", Content: "Good morning", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		postID, err := CreatePost(&Post{Title: "News", Slug: "news", Content: "Fresh news", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, lang := range []string{"en", "de"} {
+			if err = SetTranslation(&Translation{Kind: KindPage, ItemID: pageID, Lang: lang, Title: "x"}); err != ErrBadLang {
+				t.Errorf("Expected no translation into %s, got %v\n", lang, err)
+			}
+		}
+		if err = SetTranslation(&Translation{Kind: KindPage, ItemID: 999, Lang: "fr", Title: "x"}); err != ErrNotFound {
+			t.Errorf("Expected no translation of a missing page, got %v\n", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", ServeIndex)
+		mux.HandleFunc("/page/", ServePage)
+		mux.HandleFunc("/post/", ServePost)
+		mux.Handle("/lang/", LangPrefix(mux))
+		get := func(path, acceptLanguage string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("GET", path, nil)
+			r.Header.Set("Accept-Language", acceptLanguage)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			return w
+		}
+
+		// Cached before there's a translation, which must then drop it
+		if body := get("/page/hello", "fr").Body.String(); !strings.Contains(body, "Good morning") || strings.Contains(body, "hreflang") {
+			t.Errorf("Expected the untranslated page without alternates, got %s\n", body)
+		}
+		err = SetTranslation(&Translation{Kind: KindPage, ItemID: pageID, Lang: "FR", Title: "Bonjour", Content: "Bonjour à tous"})
+		if err == nil {
+			err = SetTranslation(&Translation{Kind: KindPost, ItemID: postID, Lang: "fr", Content: "Nouvelles fraîches"})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := get("/page/hello", "fr-CA, en;q=0.5")
+		body := w.Body.String()
+		if !strings.Contains(body, "<h1>Bonjour</h1>") || !strings.Contains(body, `<html lang="fr">`) {
+			t.Errorf("Expected the page in French, got %s\n", body)
+		}
+		for _, link := range []string{
+			`hreflang="x-default" href="/page/hello"`,
+			`hreflang="en" href="/lang/en/page/hello"`,
+			`hreflang="fr" href="/lang/fr/page/hello"`,
+		} {
+			if !strings.Contains(body, link) {
+				t.Errorf("Expected the alternate %s, got %s\n", link, body)
+			}
+		}
+		if w.Header().Get("Vary") != "Accept-Language" {
+			t.Errorf("Expected the page to vary by language, got %v\n", w.Header())
+		}
+		if body = get("/page/hello", "en").Body.String(); !strings.Contains(body, "Good morning") {
+			t.Errorf("Expected the page in English, got %s\n", body)
+		}
+		if body = get("/lang/fr/page/hello", "en").Body.String(); !strings.Contains(body, "Bonjour à tous") {
+			t.Errorf("Expected the /lang/ prefix to pick French, got %s\n", body)
+		}
+		if w = get("/lang/fr/page/"+strconv.Itoa(pageID), "en"); w.Code != http.StatusMovedPermanently {
+			t.Errorf("Expected the page by ID to redirect, got %d\n", w.Code)
+		} else if w.Header().Get("Location") != "/lang/fr/page/hello" {
+			t.Errorf("Expected the redirect to stay in French, got %s\n", w.Header().Get("Location"))
+		}
+		if w = get("/lang/de/page/hello", "en"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected an unknown language not to be found, got %d\n", w.Code)
+		}
+
+		// The post's title isn't translated, so it falls back to the original
+		body = get("/", "fr").Body.String()
+		if !strings.Contains(body, "News") || !strings.Contains(body, "Nouvelles fraîches") {
+			t.Errorf("Expected the post in French on the home page, got %s\n", body)
+		}
+		if !strings.Contains(body, `hreflang="fr" href="/lang/fr/"`) {
+			t.Errorf("Expected the home page's alternates, got %s\n", body)
+		}
+
+		status, err := GetTranslationStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(status) != 2 || status[0].Kind != KindPage || !equalStrings(status[0].Translated, []string{"fr"}) {
+			t.Errorf("Expected the page translated into French, got %+v\n", status)
+		}
+
+		err = SetTranslation(&Translation{Kind: KindPage, ItemID: pageID, Lang: "fr"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body = get("/lang/fr/page/hello", "").Body.String(); !strings.Contains(body, "Good morning") {
+			t.Errorf("Expected the translation to be deleted, got %s\n", body)
+		}
+		err = DeletePost(postID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		translations, err := DefaultSite.store.GetTranslations(KindPost, []int{postID})
+		if err != nil || len(translations) != 0 {
+			t.Errorf("Expected a deleted post's translations to be deleted, got %v %v\n", translations, err)
+		}
+	})
+}
+
+func Test_TranslationsHandler(t *testing.T) {
+	defer withLanguages("en", "fr", "es")()
+
+	forEachStore(t, func(t *testing.T) {
+		defer loginAs("ann", RoleAuthor)()
+		id, err := CreatePost(&Post{Title: "Recipe", Content: "Mix well", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := "/admin/translations/post/" + strconv.Itoa(id) + "/es"
+
+		w := get(ServeTranslations, path)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Mix well") {
+			t.Fatalf("Expected the form with the original, got %d %s\n", w.Code, w.Body.String())
+		}
+		w = postForm(ServeTranslations, path, url.Values{"title": {"Receta"}, "content": {"Mezclar bien"}})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("Expected the translation to be saved, got %d %s\n", w.Code, w.Body.String())
+		}
+		if w = get(ServeTranslations, "/admin/translations/post/"+strconv.Itoa(id)+"/en"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected no form translating into the original language, got %d\n", w.Code)
+		}
+
+		body := get(ServeTranslations, "/admin/translations?missing=es").Body.String()
+		if strings.Contains(body, "Recipe") {
+			t.Errorf("Expected the post not to be missing Spanish, got %s\n", body)
+		}
+		body = get(ServeTranslations, "/admin/translations?missing=fr").Body.String()
+		if !strings.Contains(body, `href="/admin/translations/post/`+strconv.Itoa(id)+`/fr"`) {
+			t.Errorf("Expected the post to be missing French, got %s\n", body)
+		}
+	})
+}
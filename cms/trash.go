@@ -0,0 +1,46 @@
+package cms
+
+import "time"
+
+// DeletePage moves a page to the trash. It's gone from the site, but can be
+// restored until it's purged.
+func (site *Site) DeletePage(id int) error {
+	return site.store.TrashPage(id)
+}
+
+// GetTrash returns the pages in the trash, most recently deleted first.
+func (site *Site) GetTrash() ([]*Page, error) {
+	return site.store.GetTrash()
+}
+
+// RestorePage takes a page back out of the trash and returns it. If another
+// page has taken its slug in the meantime, it gets a new one.
+func (site *Site) RestorePage(id int) (*Page, error) {
+	trash, err := site.store.GetTrash()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range trash {
+		if p.ID != id {
+			continue
+		}
+		slug, err := site.uniqueSlug(KindPage, p.Slug, p.Title)
+		if err != nil {
+			return nil, err
+		}
+		err = site.store.RestorePage(id, slug)
+		if err != nil {
+			return nil, err
+		}
+		p.Slug = slug
+		p.DeletedAt = time.Time{}
+		return p, nil
+	}
+	return nil, ErrNotFound
+}
+
+// PurgePage deletes a page in the trash for good. Pages have to be deleted
+// before they can be purged.
+func (site *Site) PurgePage(id int) error {
+	return site.store.PurgePage(id)
+}
@@ -0,0 +1,161 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_PageVersions(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		p := &Page{Title: "Versioned", Content: "one", Status: StatusPublished}
+		id, err := CreatePage(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Version != 1 {
+			t.Errorf("Expected a new page to be version 1, got %d\n", p.Version)
+		}
+
+		first := &Page{ID: id, Title: "Versioned", Content: "two", Version: 1}
+		err = UpdatePage(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.Version != 2 {
+			t.Errorf("Expected the edit to make version 2, got %d\n", first.Version)
+		}
+
+		stale := &Page{ID: id, Title: "Renamed", Slug: "renamed", Content: "three", Version: 1}
+		err = UpdatePage(stale)
+		if err != ErrConflict {
+			t.Errorf("Expected ErrConflict for a stale edit, got %v\n", err)
+		}
+		page, err := GetPage(strconv.Itoa(id))
+		if err != nil || page.Content != "two" || page.Slug != "versioned" {
+			t.Errorf("Expected the stale edit to change nothing, got %+v, %v\n", page, err)
+		}
+
+		err = DefaultSite.store.UpdatePage(&Page{ID: id, Title: "Versioned", Content: "three", Version: 1})
+		if err != ErrConflict {
+			t.Errorf("Expected the store to refuse a stale edit too, got %v\n", err)
+		}
+	})
+}
+
+func Test_Trash(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		p := &Page{Title: "Doomed", Content: "findable words", Status: StatusPublished}
+		id, err := CreatePage(p)
+		if err == nil {
+			err = SetTags(KindPage, id, []string{"gone"})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = DeletePage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = GetPage(strconv.Itoa(id)); err != ErrNotFound {
+			t.Errorf("Expected a trashed page not to be found, got %v\n", err)
+		}
+		if pages, _ := GetPages(); len(pages) != 0 {
+			t.Errorf("Expected a trashed page not to be listed, got %d\n", len(pages))
+		}
+		if results, _ := Search("findable", ""); len(results) != 0 {
+			t.Errorf("Expected a trashed page not to be found by search, got %+v\n", results)
+		}
+		trash, err := GetTrash()
+		if err != nil || len(trash) != 1 || trash[0].ID != id || trash[0].DeletedAt.IsZero() {
+			t.Fatalf("Expected the page in the trash, got %+v, %v\n", trash, err)
+		}
+
+		// Someone else takes the slug while the page is in the trash
+		_, err = CreatePage(&Page{Title: "Doomed", Content: "new", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, err := RestorePage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.Slug != "doomed-2" {
+			t.Errorf("Expected the restored page to get a new slug, got %q\n", restored.Slug)
+		}
+		page, err := GetPage(strconv.Itoa(id))
+		if err != nil || page.Content != "findable words" {
+			t.Errorf("Expected the page back, got %+v, %v\n", page, err)
+		}
+		if results, _ := Search("findable", ""); len(results) != 1 {
+			t.Errorf("Expected the restored page to be searchable, got %+v\n", results)
+		}
+
+		if err = PurgePage(id); err != ErrNotFound {
+			t.Errorf("Expected only pages in the trash to be purged, got %v\n", err)
+		}
+		err = DeletePage(id)
+		if err == nil {
+			err = PurgePage(id)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trash, _ := GetTrash(); len(trash) != 0 {
+			t.Errorf("Expected the trash to be empty, got %+v\n", trash)
+		}
+		if _, err = RestorePage(id); err != ErrNotFound {
+			t.Errorf("Expected a purged page to be gone, got %v\n", err)
+		}
+	})
+}
+
+func Test_EditPageHandler(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		p := &Page{Title: "Editable", Content: "before", Status: StatusPublished}
+		id, err := CreatePage(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := "/admin/pages/" + strconv.Itoa(id)
+
+		w := httptest.NewRecorder()
+		ServeAdminPages(w, httptest.NewRequest("GET", base+"/edit", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="version" value="1"`) {
+			t.Errorf("Expected the form pre-filled with the version, got %d %s\n", w.Code, w.Body.String())
+		}
+
+		post := func(path string, form url.Values) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			ServeAdminPages(w, r)
+			return w
+		}
+		edit := url.Values{"title": {"Edited"}, "slug": {"edited"}, "content": {"after"}, "status": {StatusPublished}, "tags": {"fresh"}, "version": {"1"}}
+		w = post(base+"/edit", edit)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/page/edited" {
+			t.Errorf("Expected a redirect to the edited page, got %d %q\n", w.Code, w.Header().Get("Location"))
+		}
+
+		edit.Set("content", "conflicting")
+		w = post(base+"/edit", edit)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "conflicting") {
+			t.Errorf("Expected the stale edit back with a 409, got %d\n", w.Code)
+		}
+
+		w = post(base+"/delete", url.Values{})
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("Expected a redirect after deleting, got %d\n", w.Code)
+		}
+		w = httptest.NewRecorder()
+		ServeTrash(w, httptest.NewRequest("GET", "/admin/trash", nil))
+		if !strings.Contains(w.Body.String(), "Edited") {
+			t.Errorf("Expected the page in the trash listing, got %s\n", w.Body.String())
+		}
+	})
+}
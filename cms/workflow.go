@@ -0,0 +1,215 @@
+package cms
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// The states a page or post moves through. Only published content is shown
+// to anonymous readers.
+const (
+	StatusDraft     = "draft"
+	StatusReview    = "review"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+// Statuses lists every status, in workflow order.
+var Statuses = []string{StatusDraft, StatusReview, StatusPublished, StatusArchived}
+
+// ErrBadStatus is returned for a status that isn't one of Statuses.
+var ErrBadStatus = errors.New("cms: unknown status")
+
+// ErrCantSchedule is returned for a post given a publish time by a user who
+// can't publish it.
+var ErrCantSchedule = errors.New("cms: only editors can schedule posts")
+
+// CurrentUser returns the logged-in user making the request, or "" for an
+// anonymous reader. Anonymous readers only see published content. Nobody is
+// logged in until the application replaces it.
+var CurrentUser = func(r *http.Request) string {
+	return ""
+}
+
+// anonymous reports whether the request comes from a reader who isn't logged
+// in.
+func anonymous(r *http.Request) bool {
+	return CurrentUser(r) == ""
+}
+
+// VisibleStatus is the status filter for what the request may list: only
+// published content for anonymous readers, anything for everybody else.
+func VisibleStatus(r *http.Request) string {
+	if anonymous(r) {
+		return StatusPublished
+	}
+	return ""
+}
+
+// CanSee reports whether the request may see content with the status.
+func CanSee(r *http.Request, status string) bool {
+	return matchStatus(status, VisibleStatus(r))
+}
+
+// statusOf treats content saved before there were statuses as published.
+func statusOf(status string) string {
+	if status == "" {
+		return StatusPublished
+	}
+	return status
+}
+
+// matchStatus reports whether status passes the filter, where an empty
+// filter matches everything.
+func matchStatus(status, filter string) bool {
+	return filter == "" || statusOf(status) == filter
+}
+
+// Published reports whether the page is visible to everybody.
+func (p *Page) Published() bool {
+	return statusOf(p.Status) == StatusPublished
+}
+
+// Published reports whether the post is visible to everybody.
+func (p *Post) Published() bool {
+	return statusOf(p.Status) == StatusPublished
+}
+
+// Scheduled reports whether the post is waiting for the scheduler to publish
+// it.
+func (p *Post) Scheduled() bool {
+	return p.Status == StatusReview && !p.PublishAt.IsZero()
+}
+
+// checkStatus validates a status, defaulting an empty one to a draft.
+func checkStatus(status *string) error {
+	if *status == "" {
+		*status = StatusDraft
+	}
+	for _, s := range Statuses {
+		if s == *status {
+			return nil
+		}
+	}
+	return ErrBadStatus
+}
+
+// schedulePost validates a post's status and publish time, given the status
+// it had before, which is empty for a new post. A post published with a
+// PublishAt in the future is held in review until then. A post that becomes
+// published is dated by its PublishAt, or else by when it was published.
+// The scheduler publishes posts with nobody there to check, so a post saved
+// by an Editor who can't publish can't have a PublishAt.
+func schedulePost(p *Post, previous string) error {
+	err := checkStatus(&p.Status)
+	if err != nil {
+		return err
+	}
+	if !p.PublishAt.IsZero() && p.Editor != "" && roleRank(UserRole(p.Editor)) < roleRank(RoleEditor) {
+		return ErrCantSchedule
+	}
+	if !p.PublishAt.IsZero() {
+		p.PublishAt = p.PublishAt.UTC().Truncate(time.Microsecond)
+	}
+	if p.Status == StatusPublished && p.PublishAt.After(time.Now()) {
+		p.Status = StatusReview
+	}
+	if p.Status == StatusPublished && previous != StatusPublished {
+		switch {
+		case !p.PublishAt.IsZero():
+			p.DatePublished = p.PublishAt
+		case previous != "":
+			p.DatePublished = now()
+		}
+	}
+	return nil
+}
+
+// publishAtLayout is how a datetime-local form field formats times
+const publishAtLayout = "2006-01-02T15:04"
+
+// parsePublishAt reads a publish time from a form, in UTC. An empty field
+// means the post isn't scheduled.
+func parsePublishAt(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(publishAtLayout, s)
+	if err != nil {
+		return time.Time{}, errors.New("cms: invalid publish time, use YYYY-MM-DDTHH:MM")
+	}
+	return t, nil
+}
+
+// duePost reports whether the scheduler should publish the post by now.
+func duePost(p *Post, now time.Time) bool {
+	return p.Scheduled() && !p.PublishAt.After(now)
+}
+
+// SetPageStatus moves a page through the workflow.
+func (site *Site) SetPageStatus(id int, status string) error {
+	p, err := site.store.GetPage(id)
+	if err != nil {
+		return err
+	}
+	p.Status = status
+	err = checkStatus(&p.Status)
+	if err != nil {
+		return err
+	}
+	return site.store.UpdatePage(p)
+}
+
+// SetPostStatus moves a post through the workflow. publishAt may be zero;
+// otherwise a post in review is published by the scheduler once it's due.
+// user is who's moving it, and must be able to publish to give it a
+// publishAt, unless it's "".
+func (site *Site) SetPostStatus(id int, status string, publishAt time.Time, user string) error {
+	p, err := site.store.GetPost(id)
+	if err != nil {
+		return err
+	}
+	previous := statusOf(p.Status)
+	p.Status, p.PublishAt, p.Editor = status, publishAt, user
+	err = schedulePost(p, previous)
+	if err != nil {
+		return err
+	}
+	return site.store.UpdatePost(p)
+}
+
+// PublishDuePosts publishes every scheduled post whose time has come, and
+// returns them.
+func (site *Site) PublishDuePosts() ([]*Post, error) {
+	return site.store.PublishDuePosts(time.Now())
+}
+
+// StartScheduler publishes the due posts of every site every interval, in the
+// background, until stop is called.
+func StartScheduler(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, site := range Sites() {
+				posts, err := site.PublishDuePosts()
+				if err != nil {
+					log.Printf("cms: publishing scheduled posts of %s: %s", site.Name, err)
+				}
+				for _, p := range posts {
+					log.Printf("cms: published scheduled post %q of %s", p.Slug, site.Name)
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,139 @@
+package cms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Workflow(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		draft := &Post{Title: "Secret draft", Content: "unfinished"}
+		id, err := CreatePost(draft)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if draft.Status != StatusDraft {
+			t.Errorf("Expected a new post to be a draft, got %q\n", draft.Status)
+		}
+		_, err = CreatePost(&Post{Title: "Bad", Content: "bad", Status: "pending"})
+		if err != ErrBadStatus {
+			t.Errorf("Expected ErrBadStatus, got %v\n", err)
+		}
+
+		posts, err := GetPosts(StatusPublished, 0)
+		if err != nil || len(posts) != 0 {
+			t.Errorf("Expected no published posts, got %+v, %v\n", posts, err)
+		}
+		if results, _ := Search("secret", StatusPublished); len(results) != 0 {
+			t.Errorf("Draft found by a search for published posts: %+v\n", results)
+		}
+
+		err = SetPostStatus(id, StatusPublished, time.Time{}, "")
+		if err != nil {
+			t.Fatalf("Failed to publish post: %s\n", err.Error())
+		}
+		posts, err = GetPosts(StatusPublished, 0)
+		if err != nil || len(posts) != 1 || posts[0].ID != id {
+			t.Errorf("Expected the published post, got %+v, %v\n", posts, err)
+		}
+		if results, _ := Search("secret", StatusPublished); len(results) != 1 {
+			t.Errorf("Published post not found: %+v\n", results)
+		}
+
+		err = SetPostStatus(id, "pending", time.Time{}, "")
+		if err != ErrBadStatus {
+			t.Errorf("Expected ErrBadStatus, got %v\n", err)
+		}
+	})
+}
+
+func Test_ScheduledPublishing(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		publishAt := time.Now().Add(time.Hour).UTC().Truncate(time.Minute)
+		p := &Post{Title: "Later", Content: "soon", Status: StatusPublished, PublishAt: publishAt}
+		id, err := CreatePost(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != StatusReview || !p.Scheduled() {
+			t.Fatalf("Expected a post published in the future to be scheduled, got %q\n", p.Status)
+		}
+
+		posts, err := PublishDuePosts()
+		if err != nil || len(posts) != 0 {
+			t.Errorf("Published a post before it was due: %+v, %v\n", posts, err)
+		}
+
+		posts, err = DefaultSite.store.PublishDuePosts(publishAt.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("Failed to publish due posts: %s\n", err.Error())
+		}
+		if len(posts) != 1 || posts[0].ID != id {
+			t.Fatalf("Expected the scheduled post to be published, got %+v\n", posts)
+		}
+
+		got, err := DefaultSite.store.GetPost(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != StatusPublished || !got.DatePublished.Equal(publishAt) {
+			t.Errorf("Expected the post published at %s, got %q at %s\n", publishAt, got.Status, got.DatePublished)
+		}
+	})
+}
+
+func Test_AnonymousReaders(t *testing.T) {
+	forEachStore(t, func(t *testing.T) {
+		_, err := CreatePost(&Post{Title: "Public", Slug: "public", Content: "for everyone", Status: StatusPublished})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreatePost(&Post{Title: "Private", Slug: "private", Content: "for editors"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = CreatePage(&Page{Title: "Hidden", Slug: "hidden", Content: "in review", Status: StatusReview})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		get := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			mux := http.NewServeMux()
+			mux.HandleFunc("/", ServeIndex)
+			mux.HandleFunc("/page/", ServePage)
+			mux.HandleFunc("/post/", ServePost)
+			mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			return w
+		}
+
+		body := get("/").Body.String()
+		if !strings.Contains(body, "Public") || strings.Contains(body, "Private") {
+			t.Errorf("Expected only the published post on the home page, got %s\n", body)
+		}
+		for path, code := range map[string]int{
+			"/post/public":  http.StatusOK,
+			"/post/private": http.StatusNotFound,
+			"/page/hidden":  http.StatusNotFound,
+		} {
+			if w := get(path); w.Code != code {
+				t.Errorf("GET %s: expected %d, got %d\n", path, code, w.Code)
+			}
+		}
+		if body := get("/page/").Body.String(); strings.Contains(body, "Hidden") {
+			t.Errorf("Unpublished page listed: %s\n", body)
+		}
+
+		CurrentUser = func(r *http.Request) string { return "editor" }
+		defer func() { CurrentUser = func(r *http.Request) string { return "" } }()
+		if w := get("/post/private"); w.Code != http.StatusOK {
+			t.Errorf("Expected editors to see drafts, got %d\n", w.Code)
+		}
+		if body := get("/page/").Body.String(); !strings.Contains(body, "Hidden") {
+			t.Errorf("Expected editors to see every page, got %s\n", body)
+		}
+	})
+}
@@ -9,6 +9,20 @@ import (
 	"github.com/jywei/toy-projects/users"
 )
 
+const loginTemplate = `
+<h1>Sign in</h1>
+<form action="/" method="POST">
+	<label for="user">Email</label>
+	<input type="email" name="user" required>
+
+	<label for="password">Password</label>
+	<input type="password" name="password" required>
+
+	<input type="submit" value="Sign in">
+</form>
+<a href="/auth/gplus/authorize">Sign in with Google</a>
+`
+
 func authHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
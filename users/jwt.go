@@ -7,6 +7,7 @@ import (
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/dgrijalva/jwt-go/request"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -63,10 +64,11 @@ func CallbackURLHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func genToken(w http.ResponseWriter, user string) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	token.Claims["sub"] = user
-	token.Claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
-	token.Claims["iat"] = time.Now().Unix()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": user,
+		"exp": time.Now().Add(time.Hour * 72).Unix(),
+		"iat": time.Now().Unix(),
+	})
 
 	tokenString, err := token.SignedString(signingKey)
 	if err != nil {
@@ -83,7 +85,7 @@ func genToken(w http.ResponseWriter, user string) {
 // VerifyToken gets the token from an HTTP request, and ensures that it's
 // valid. It'll return the user's username as a string.
 func VerifyToken(r *http.Request) (string, error) {
-	token, err := jwt.ParseFromRequest(r, func(token *jwt.Token) (interface{}, error) {
+	token, err := request.ParseFromRequest(r, request.OAuth2Extractor, func(token *jwt.Token) (interface{}, error) {
 		_, ok := token.Method.(*jwt.SigningMethodHMAC)
 		if !ok {
 			return nil, jwt.ErrSignatureInvalid
@@ -96,5 +98,13 @@ func VerifyToken(r *http.Request) (string, error) {
 	if token.Valid == false {
 		return "", jwt.ErrInvalidKey
 	}
-	return token.Claims["sub"].(string), nil
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", jwt.ErrInvalidKey
+	}
+	sub, ok := claims["sub"].(string)
+	if !ok {
+		return "", jwt.ErrInvalidKey
+	}
+	return sub, nil
 }
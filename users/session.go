@@ -4,8 +4,8 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gorilla/csrf"
+	bolt "go.etcd.io/bbolt"
 )
 
 const cookieName = "_goproj_sess"
@@ -30,6 +30,16 @@ func GetSession(w http.ResponseWriter, r *http.Request) string {
 	return user
 }
 
+// SessionUser returns the user logged in with the request's cookie, without
+// answering the request when there isn't one.
+func SessionUser(r *http.Request) (string, error) {
+	s, err := r.Cookie(cookieName)
+	if err != nil {
+		return "", err
+	}
+	return get(s.Value)
+}
+
 // SetSession sets the session for the given user.
 func SetSession(w http.ResponseWriter, user string) {
 	// genRandBytes to generate a random key
@@ -48,18 +58,20 @@ func SetSession(w http.ResponseWriter, user string) {
 }
 
 func save(id string, user string) error {
-	return DB.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(DB.Sessions))
+	db := store()
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Sessions))
 		return b.Put([]byte(id), []byte(user))
 	})
 }
 
 func get(id string) (string, error) {
 	var user []byte
+	db := store()
 	// Tx is transaction, it represents a single interaction with DB
-	DB.DB.View(func(tx *bolt.Tx) error {
+	db.DB.View(func(tx *bolt.Tx) error {
 		// DB.Sessions is a specific key values store to store sessions
-		b := tx.Bucket([]byte(DB.Sessions))
+		b := tx.Bucket([]byte(db.Sessions))
 		user = b.Get([]byte(id))
 		return nil
 	})
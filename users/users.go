@@ -2,15 +2,20 @@ package users
 
 import (
 	"errors"
+	"sync"
 	"time"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
-	// DB is the reference to our DB, which contains our user data.
-	DB = newDB()
+	// DB is the reference to our DB, which contains our user data. It's
+	// opened by the first function that needs it.
+	DB *Store
+
+	// dbOnce opens the DB
+	dbOnce sync.Once
 
 	// ErrUserAlreadyExists is the error thrown when a user attempts to create
 	// a new user in the DB with a duplicate username.
@@ -21,12 +26,24 @@ var (
 	ErrUserNotFound = errors.New("users: user not found")
 )
 
-// Store is a reference to our BoltDB instance that contains two seperate
-// internal stores: a user store, and a session store.
+// Store is a reference to our BoltDB instance that contains three seperate
+// internal stores: a user store, a session store, and a store of the users'
+// roles.
 type Store struct {
 	DB       *bolt.DB
 	Users    string
 	Sessions string
+	Roles    string
+}
+
+// store opens the DB the first time it's needed. Bolt locks the file while
+// it's open, so programs that import the package without using it, or only
+// use it for some commands, mustn't open it up front.
+func store() *Store {
+	dbOnce.Do(func() {
+		DB = newDB()
+	})
+	return DB
 }
 
 // newDB is a convenience method to initalize our DB.
@@ -57,10 +74,20 @@ func newDB() *Store {
 		return nil
 	})
 
+	// Create the Roles bucket
+	db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte("Roles"))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+
 	return &Store{
 		DB:       db,
 		Users:    "Users",
 		Sessions: "Sessions",
+		Roles:    "Roles",
 	}
 }
 
@@ -77,8 +104,9 @@ func NewUser(username string, password string) error {
 		return err
 	}
 
-	return DB.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(DB.Users))
+	db := store()
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Users))
 		return b.Put([]byte(username), hashedPassword)
 	})
 }
@@ -88,8 +116,9 @@ func NewUser(username string, password string) error {
 // error on failure.
 func AuthenticateUser(username string, password string) error {
 	var hashedPassword []byte
-	DB.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(DB.Users))
+	db := store()
+	db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Users))
 		hashedPassword = b.Get([]byte(username))
 		return nil
 	})
@@ -107,18 +136,45 @@ func OverrideOldPassword(username string, password string) error {
 		return err
 	}
 
-	return DB.DB.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(DB.Users))
+	db := store()
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Users))
 		return b.Put([]byte(username), hashedPassword)
 	})
 }
 
+// SetRole gives an existing user a role, which is up to the application
+// to make sense of.
+func SetRole(username string, role string) error {
+	if exists(username) == nil {
+		return ErrUserNotFound
+	}
+	db := store()
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Roles))
+		return b.Put([]byte(username), []byte(role))
+	})
+}
+
+// GetRole returns the role of a user, or "" if they haven't been given one.
+func GetRole(username string) string {
+	var role []byte
+	db := store()
+	db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Roles))
+		role = b.Get([]byte(username))
+		return nil
+	})
+	return string(role)
+}
+
 // exists is an internal utility function for ensuring the usernames are
 // unique.
 func exists(username string) error {
 	var result []byte
-	DB.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(DB.Users))
+	db := store()
+	db.DB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(db.Users))
 		result = b.Get([]byte(username))
 		return nil
 	})